@@ -0,0 +1,153 @@
+package access
+
+import (
+	"better_auth/clientip"
+	"better_auth/config"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type rule struct {
+	networks []*net.IPNet
+	hosts    []string
+	paths    []string
+	action   string
+}
+
+/// Rules decides whether a request may skip logging in, must log in, or is
+/// refused, based on the client's address and the host and path requested
+type Rules struct {
+	rules []rule
+}
+
+/// Decision is the outcome of checking a request against Rules. Rule is the
+/// 1-based position of the rule that matched, 0 if none did
+type Decision struct {
+	Action string
+	Rule   int
+}
+
+func (d Decision) String() string {
+	if d.Rule == 0 {
+		return fmt.Sprintf("%s, no rule matched", d.Action)
+	}
+	return fmt.Sprintf("%s by rule %d", d.Action, d.Rule)
+}
+
+/// Compiles cfg into Rules
+func New(cfg []config.AccessRule) (*Rules, error) {
+	r := &Rules{}
+	for i, c := range cfg {
+		switch c.Action {
+		case config.ActionAllow, config.ActionAuth, config.ActionDeny:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action `%s`", i+1, c.Action)
+		}
+
+		compiled := rule{action: c.Action}
+		for _, p := range c.Paths {
+			compiled.paths = append(compiled.paths, path.Clean(p))
+		}
+		for _, n := range c.Networks {
+			ipNet, err := clientip.ParseCIDR(n)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %s", i+1, err)
+			}
+			compiled.networks = append(compiled.networks, ipNet)
+		}
+		for _, h := range c.Hosts {
+			compiled.hosts = append(compiled.hosts, strings.ToLower(h))
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+/// Returns the action of the first rule matching a request from ip for host
+/// and uri, or `auth` if none match. ip may be empty if unknown, in which
+/// case rules with Networks never match. uri is cleaned by CleanPath first
+func (r *Rules) Check(ip string, host string, uri string) Decision {
+	addr := net.ParseIP(ip)
+	host = strings.ToLower(stripPort(host))
+	p := CleanPath(uri)
+	for i, rule := range r.rules {
+		if rule.matchesIP(addr) && rule.matchesHost(host) && rule.matchesPath(p) {
+			return Decision{Action: rule.action, Rule: i + 1}
+		}
+	}
+	return Decision{Action: config.ActionAuth}
+}
+
+func (r rule) matchesIP(ip net.IP) bool {
+	if len(r.networks) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, n := range r.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/// Hosts match exactly, or *.example.com matches any subdomain of example.com
+func (r rule) matchesHost(host string) bool {
+	if len(r.hosts) == 0 {
+		return true
+	}
+	for _, h := range r.hosts {
+		if h == host || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+/// Paths match whole segments, so /public matches /public and /public/x but
+/// not /publicly
+func (r rule) matchesPath(p string) bool {
+	if len(r.paths) == 0 {
+		return true
+	}
+	for _, prefix := range r.paths {
+		if PathUnder(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/// Returns the path of uri, such as nginx's $request_uri, as nginx serves it:
+/// without the query, percent-decoded, and with dot segments and repeated
+/// slashes resolved. Otherwise /public/../secret would match rules for
+/// /public while nginx serves /secret
+func CleanPath(uri string) string {
+	p := strings.SplitN(uri, "?", 2)[0]
+	if decoded, err := url.PathUnescape(p); err == nil {
+		p = decoded
+	}
+	return path.Clean("/" + p)
+}
+
+/// Returns bool indicating if the cleaned path p is prefix or below it.
+/// Only whole segments match, so /api does not match /apifoo
+func PathUnder(p string, prefix string) bool {
+	prefix = path.Clean(prefix)
+	if prefix == "/" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+package access
+
+import (
+	"better_auth/config"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	rules, err := New([]config.AccessRule{
+		{Networks: []string{"203.0.113.0/24"}, Action: config.ActionDeny},
+		{Networks: []string{"10.0.0.0/8"}, Paths: []string{"/admin/"}, Action: config.ActionAuth},
+		{Networks: []string{"10.0.0.0/8", "192.168.1.5"}, Hosts: []string{"*.internal.example.com"}, Action: config.ActionAllow},
+		{Hosts: []string{"status.example.com"}, Paths: []string{"/health"}, Action: config.ActionAllow},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		ip, host, path string
+		want           Decision
+	}{
+		{"203.0.113.9", "status.example.com", "/health", Decision{config.ActionDeny, 1}},
+		{"10.1.2.3", "grafana.internal.example.com", "/admin/users", Decision{config.ActionAuth, 2}},
+		{"10.1.2.3", "grafana.internal.example.com:443", "/d/home", Decision{config.ActionAllow, 3}},
+		{"192.168.1.5", "Grafana.Internal.Example.com", "/", Decision{config.ActionAllow, 3}},
+		{"192.168.1.6", "grafana.internal.example.com", "/", Decision{config.ActionAuth, 0}},
+		{"10.1.2.3", "internal.example.com.evil.net", "/", Decision{config.ActionAuth, 0}},
+		{"198.51.100.1", "status.example.com", "/health/db?full=1", Decision{config.ActionAllow, 4}},
+		{"198.51.100.1", "status.example.com", "/healthz", Decision{config.ActionAuth, 0}},
+		{"198.51.100.1", "status.example.com", "/health/../secret", Decision{config.ActionAuth, 0}},
+		{"198.51.100.1", "status.example.com", "/health%2F..%2Fsecret", Decision{config.ActionAuth, 0}},
+		{"198.51.100.1", "status.example.com", "//health/./", Decision{config.ActionAllow, 4}},
+		{"10.1.2.3", "grafana.internal.example.com", "/d/../admin/users", Decision{config.ActionAuth, 2}},
+		{"10.1.2.3", "grafana.internal.example.com", "/%61dmin/users", Decision{config.ActionAuth, 2}},
+		{"", "status.example.com", "/", Decision{config.ActionAuth, 0}},
+		{"", "grafana.internal.example.com", "/", Decision{config.ActionAuth, 0}},
+	} {
+		if got := rules.Check(c.ip, c.host, c.path); got != c.want {
+			t.Fatalf("%s %s%s: got %s, expected %s", c.ip, c.host, c.path, got, c.want)
+		}
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	for _, r := range []config.AccessRule{
+		{Action: "permit"},
+		{Networks: []string{"10.0.0.0/40"}, Action: config.ActionAllow},
+	} {
+		if _, err := New([]config.AccessRule{r}); err == nil {
+			t.Fatalf("Rule %+v accepted", r)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, c := range []struct{ uri, want string }{
+		{"/public/../secret", "/secret"},
+		{"/public%2F..%2Fsecret", "/secret"},
+		{"/a//b/./c/?x=/public", "/a/b/c"},
+		{"/../../etc", "/etc"},
+		{"/bad%zzescape/..", "/"},
+		{"", "/"},
+	} {
+		if got := CleanPath(c.uri); got != c.want {
+			t.Fatalf("CleanPath(%s) = %s, expected %s", c.uri, got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		p, prefix string
+		want      bool
+	}{
+		{"/public", "/public", true},
+		{"/public/x", "/public/", true},
+		{"/publicly-secret", "/public", false},
+		{"/publicly-secret", "/public/", false},
+		{"/anything", "/", true},
+	} {
+		if got := PathUnder(c.p, c.prefix); got != c.want {
+			t.Fatalf("PathUnder(%s, %s) = %t", c.p, c.prefix, got)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"better_auth/geoip"
+	"better_auth/logging"
+	"better_auth/pages"
+	"better_auth/token_store"
+	"fmt"
+	"net/http"
+)
+
+const accountErrWrongPassword = "wrong_password"
+
+/// How many of the latest logins the account page lists
+const accountLogins = 20
+
+/// Audit events listed as logins on the account page
+var accountLoginEvents = map[string]bool{
+	logging.AuditLoginSuccess:   true,
+	logging.AuditLoginFailure:   true,
+	logging.AuditLoginFlagged:   true,
+	logging.AuditLockout:        true,
+	logging.AuditPasswordChange: true,
+}
+
+/// Format of times shown on the account page
+const accountTimeFormat = "2006-01-02 15:04 MST"
+
+/// Returns the session id and user of a full session sent with r. Otherwise
+/// sends the client to log in, or to change their password if the session is
+/// restricted, and returns empty strings
+func (s *Server) accountSession(w http.ResponseWriter, r *http.Request) (string, string) {
+	id, _ := r.Cookie(SESSION_TOKEN)
+	if id == nil || !s.sessionStore.IsValid(id.Value) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return "", ""
+	}
+	if s.sessionStore.IsRestricted(id.Value) {
+		http.Redirect(w, r, changePasswordURL(r.URL.Path), http.StatusSeeOther)
+		return "", ""
+	}
+	return id.Value, s.sessionStore.User(id.Value)
+}
+
+/// Shows a logged in user their sessions and latest logins, so they can spot
+/// ones that were not them. Clients without a session are sent to /login,
+/// restricted sessions to /login/change
+/// GET returns the page
+/// POST signs out the session whose handle is posted as `revoke`, or every
+///   other session of the user if `revoke_others` is set, then redirects back
+///   to the page. The current session cannot be revoked here, /logout ends it.
+///   Returns 403 if the csrf token isn't valid
+func (s *Server) account(w http.ResponseWriter, r *http.Request) {
+	id, user := s.accountSession(w, r)
+	if user == "" {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderAccount(w, r, id, user)
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		handle := r.FormValue("revoke")
+		switch {
+		case r.FormValue("revoke_others") != "":
+			n := s.sessionStore.RemoveUserExcept(user, id)
+			s.audit(r, logging.AuditSessionRevoked, user, fmt.Sprintf("account page, ended %d other sessions", n))
+		case handle != "" && handle != token_store.Handle(id):
+			if s.sessionStore.RemoveHandle(user, handle) {
+				s.audit(r, logging.AuditSessionRevoked, user, "account page, session "+handle)
+			}
+		}
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Renders account.html for the user of session id
+func (s *Server) renderAccount(w http.ResponseWriter, r *http.Request, id string, user string) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	sessions := []pages.SessionInfo{}
+	for _, session := range s.sessionStore.Sessions(user) {
+		sessions = append(sessions, sessionInfo(session, token_store.Handle(id)))
+	}
+
+	events, err := logging.RecentAudit(accountLogins, func(e logging.AuditEvent) bool {
+		return e.User == user && accountLoginEvents[e.Event]
+	})
+	if err != nil {
+		logging.Error(fmt.Errorf("unable to read the audit log: %s", err))
+	}
+	logins := []pages.LoginInfo{}
+	for _, e := range events {
+		logins = append(logins, pages.LoginInfo{
+			Time:   e.Time.Local().Format(accountTimeFormat),
+			Event:  e.Event,
+			Device: describeDevice(e.UserAgent),
+			IP:     e.IP,
+		})
+	}
+
+	live := s.live()
+	data := pages.SessionsData{
+		Branding:  live.pages.Branding(),
+		Locale:    s.locale(w, r),
+		CSRFToken: csrf,
+		User:      user,
+		Sessions:  sessions,
+		Logins:    logins,
+		AuditLog:  s.startConf.AuditFile != "",
+	}
+	err = live.pages.Render(w, 200, "account.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
+
+/// Lets a logged in user change their own password. Clients without a session
+/// are sent to /login, restricted sessions to /login/change
+/// GET returns the form asking for the current and new password
+/// POST checks the current password, then sets the new one. If `revoke` is
+///   set the user's other sessions are ended, the current one is kept.
+///   Returns the form again with 401 if the current password is wrong, 429 if
+///   the user is locked out after too many wrong passwords, 400 if the new
+///   passwords do not match or are not allowed and 403 if the csrf token
+///   isn't valid
+func (s *Server) accountPassword(w http.ResponseWriter, r *http.Request) {
+	id, user := s.accountSession(w, r)
+	if user == "" {
+		return
+	}
+	data := pages.AccountData{User: user}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderAccountPassword(w, r, 200, data)
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			data.Error = loginErrExpired
+			s.renderAccountPassword(w, r, 403, data)
+			return
+		}
+
+		if s.lockout.IsLocked(user) {
+			s.audit(r, logging.AuditLoginFailure, user, "locked out, account page")
+			data.Error = loginErrLocked
+			s.renderAccountPassword(w, r, 429, data)
+			return
+		}
+		if !s.pwManager.Verify(user, r.FormValue("current")) {
+			s.audit(r, logging.AuditLoginFailure, user, "wrong current password, account page")
+			if s.lockout.Fail(user) {
+				s.audit(r, logging.AuditLockout, user, "")
+			}
+			data.Error = accountErrWrongPassword
+			s.renderAccountPassword(w, r, 401, data)
+			return
+		}
+
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			data.Error = resetErrMismatch
+			s.renderAccountPassword(w, r, 400, data)
+			return
+		}
+		err := s.pwManager.CheckPassword(user, password)
+		if err != nil {
+			data.Error = resetErrWeak
+			data.Problems = s.policyProblems(w, r, err)
+			s.renderAccountPassword(w, r, 400, data)
+			return
+		}
+
+		err = s.pwManager.SetPassword(user, password)
+		if err != nil {
+			logging.Error(err)
+			w.WriteHeader(500)
+			return
+		}
+		detail := "account page"
+		if r.FormValue("revoke") != "" {
+			n := s.sessionStore.RemoveUserExcept(user, id)
+			detail = fmt.Sprintf("account page, ended %d other sessions", n)
+		}
+		s.passwordChanged(w, r, user, detail)
+
+		data.Done = true
+		s.renderAccountPassword(w, r, 200, data)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Renders account_password.html with status
+func (s *Server) renderAccountPassword(w http.ResponseWriter, r *http.Request, status int, data pages.AccountData) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	live := s.live()
+	data.Branding = live.pages.Branding()
+	data.Locale = s.locale(w, r)
+	data.CSRFToken = csrf
+	err = live.pages.Render(w, status, "account_password.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
+
+/// Returns session as shown to people, marked current if its handle is current
+func sessionInfo(session token_store.Session, current string) pages.SessionInfo {
+	location := ""
+	if session.Country != "" || session.ASN != 0 {
+		location = geoip.Location{Country: session.Country, ASN: session.ASN}.String()
+	}
+	return pages.SessionInfo{
+		Handle:   session.Handle,
+		Device:   describeDevice(session.UserAgent),
+		IP:       session.IP,
+		Location: location,
+		LastSeen: session.LastSeen.Format(accountTimeFormat),
+		Current:  session.Handle == current,
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"better_auth/logging"
+	"better_auth/pages"
+	"better_auth/pw"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	adminErrInvalidUser  = "invalid_user"
+	adminErrInvalidEmail = "invalid_email"
+	adminErrInvalidGroup = "invalid_group"
+	adminErrSelf         = "self"
+	adminErrNoSuchUser   = "no_such_user"
+)
+
+/// How many of the latest audit events the admin console lists
+const adminAuditEvents = 50
+
+/// Returns the user of a full session sent with r if they are in Admin.Group.
+/// Otherwise sends the client to log in, or returns 403 to users outside the
+/// group, and returns an empty string
+func (s *Server) adminSession(w http.ResponseWriter, r *http.Request) string {
+	_, user := s.accountSession(w, r)
+	if user == "" {
+		return ""
+	}
+	if !s.pwManager.InGroup(user, s.live().conf.Admin.Group) {
+		s.audit(r, logging.AuditAccessDenied, user, "admin console")
+		w.WriteHeader(403)
+		return ""
+	}
+	return user
+}
+
+/// Lists every user and the latest audit events to members of Admin.Group
+/// GET returns the list
+/// POST adds the user named `username` with `password`, and optionally
+///   `email`, comma separated `groups` and `must_change`, then redirects to
+///   their page. Returns 400 and the list again if the user cannot be added,
+///   and 403 if the csrf token isn't valid
+func (s *Server) adminUsers(w http.ResponseWriter, r *http.Request) {
+	admin := s.adminSession(w, r)
+	if admin == "" {
+		return
+	}
+	data := pages.AdminData{Admin: admin}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderAdmin(w, r, 200, data)
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			data.Error = loginErrExpired
+			s.renderAdmin(w, r, 403, data)
+			return
+		}
+
+		name := strings.TrimSpace(r.FormValue("username"))
+		data.Error, data.Problems = s.adminAddUser(w, r, admin, name)
+		if data.Error != "" {
+			s.renderAdmin(w, r, 400, data)
+			return
+		}
+		http.Redirect(w, r, s.adminUserURL(name, "added"), http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Adds user name from the form posted to the admin console. Returns an error
+/// code and problems to show if the user was not added
+func (s *Server) adminAddUser(w http.ResponseWriter, r *http.Request, admin string, name string) (string, []string) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email != "" && pw.ValidateEmail(email) != nil {
+		return adminErrInvalidEmail, nil
+	}
+	groups := splitGroups(r.FormValue("groups"))
+	for _, g := range groups {
+		if err := pw.ValidateGroup(g); err != nil {
+			return adminErrInvalidGroup, []string{err.Error()}
+		}
+	}
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm") {
+		return resetErrMismatch, nil
+	}
+
+	err := s.pwManager.AddUser(name, password)
+	if _, weak := err.(pw.PolicyError); weak {
+		return resetErrWeak, s.policyProblems(w, r, err)
+	}
+	if err != nil {
+		return adminErrInvalidUser, []string{err.Error()}
+	}
+
+	// the user exists now, so failures below are logged rather than undone
+	if email != "" {
+		logIfError(s.pwManager.SetEmail(name, email))
+	}
+	if len(groups) > 0 {
+		logIfError(s.pwManager.SetGroups(name, groups))
+	}
+	if r.FormValue("must_change") != "" {
+		logIfError(s.pwManager.SetMustChange(name, true))
+	}
+	s.audit(r, logging.AuditUserAdded, name, "admin console by "+admin)
+	return "", nil
+}
+
+/// Shows one user to members of Admin.Group and makes changes to them
+/// GET returns the user named by `name`, or 404 if there is none
+/// POST makes the change posted as `action`, then redirects back to the user:
+///   password   sets `password`, requiring a change at the next login if
+///              `must_change` is set, and ends the user's sessions
+///   disable    stops the user logging in and ends their sessions
+///   enable     lets a disabled user log in again
+///   delete     removes the user and ends their sessions, then redirects to
+///              the list of users
+///   groups     sets the comma separated `groups`
+///   email      sets or removes `email`
+///   unlock     clears the user's failed logins and lockout
+///   revoke     ends the session whose handle is posted as `session`
+///   revoke_all ends every session of the user
+///  Admins cannot disable or delete themselves or leave Admin.Group. Returns
+///  400 and the user again if the change is not allowed, and 403 if the csrf
+///  token isn't valid. There is no action to reset second factors because
+///  better_auth does not have any yet
+func (s *Server) adminUser(w http.ResponseWriter, r *http.Request) {
+	admin := s.adminSession(w, r)
+	if admin == "" {
+		return
+	}
+	name := r.FormValue("name")
+	if !s.pwManager.Exists(name) {
+		s.renderAdminUser(w, r, 404, pages.AdminUserData{Admin: admin, Error: adminErrNoSuchUser})
+		return
+	}
+	data := pages.AdminUserData{Admin: admin, User: pages.AdminUser{Name: name}}
+
+	switch r.Method {
+	case http.MethodGet:
+		data.Done = r.FormValue("done")
+		s.renderAdminUser(w, r, 200, data)
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			data.Error = loginErrExpired
+			s.renderAdminUser(w, r, 403, data)
+			return
+		}
+
+		action := r.FormValue("action")
+		data.Error, data.Problems = s.adminChangeUser(w, r, admin, name, action)
+		if data.Error != "" {
+			s.renderAdminUser(w, r, 400, data)
+			return
+		}
+		if action == "delete" {
+			http.Redirect(w, r, s.startConf.Admin.Path, http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, s.adminUserURL(name, action), http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Makes the change posted as action to user name. Returns an error code and
+/// problems to show if it was not made
+func (s *Server) adminChangeUser(w http.ResponseWriter, r *http.Request, admin string, name string, action string) (string, []string) {
+	by := "admin console by " + admin
+	self := name == admin
+	var err error
+
+	switch action {
+	case "password":
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			return resetErrMismatch, nil
+		}
+		err = s.pwManager.SetPassword(name, password)
+		if _, weak := err.(pw.PolicyError); weak {
+			return resetErrWeak, s.policyProblems(w, r, err)
+		}
+		if err == nil && r.FormValue("must_change") != "" {
+			err = s.pwManager.SetMustChange(name, true)
+		}
+		if err == nil {
+			s.sessionStore.RemoveUser(name)
+			s.passwordChanged(w, r, name, by)
+		}
+	case "disable", "enable":
+		if self {
+			return adminErrSelf, nil
+		}
+		err = s.pwManager.SetDisabled(name, action == "disable")
+		if err == nil {
+			if action == "disable" {
+				s.sessionStore.RemoveUser(name)
+				s.basicCache.clear()
+			}
+			s.audit(r, logging.AuditUserChanged, name, action+"d, "+by)
+		}
+	case "delete":
+		if self {
+			return adminErrSelf, nil
+		}
+		err = s.pwManager.RemoveUser(name)
+		if err == nil {
+			s.sessionStore.RemoveUser(name)
+			s.basicCache.clear()
+			s.lockout.Reset(name)
+			s.audit(r, logging.AuditUserDeleted, name, by)
+		}
+	case "groups":
+		groups := splitGroups(r.FormValue("groups"))
+		for _, g := range groups {
+			if e := pw.ValidateGroup(g); e != nil {
+				return adminErrInvalidGroup, []string{e.Error()}
+			}
+		}
+		if self && !containsString(groups, s.live().conf.Admin.Group) {
+			return adminErrSelf, nil
+		}
+		err = s.pwManager.SetGroups(name, groups)
+		if err == nil {
+			s.audit(r, logging.AuditUserChanged, name, fmt.Sprintf("groups set to [%s], %s", strings.Join(groups, ","), by))
+		}
+	case "email":
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email != "" && pw.ValidateEmail(email) != nil {
+			return adminErrInvalidEmail, nil
+		}
+		err = s.pwManager.SetEmail(name, email)
+		if err == nil {
+			s.audit(r, logging.AuditUserChanged, name, "email changed, "+by)
+		}
+	case "unlock":
+		s.lockout.Reset(name)
+		s.audit(r, logging.AuditLockoutCleared, name, by)
+	case "revoke":
+		if s.sessionStore.RemoveHandle(name, r.FormValue("session")) {
+			s.audit(r, logging.AuditSessionRevoked, name, "session "+r.FormValue("session")+", "+by)
+		}
+	case "revoke_all":
+		n := s.sessionStore.RemoveUser(name)
+		s.audit(r, logging.AuditSessionRevoked, name, fmt.Sprintf("ended %d sessions, %s", n, by))
+	default:
+		return adminErrInvalidUser, []string{fmt.Sprintf("unknown action `%s`", action)}
+	}
+
+	if err != nil {
+		logging.Error(err)
+		return adminErrInvalidUser, []string{err.Error()}
+	}
+	return "", nil
+}
+
+/// Returns the admin console page of user name, noting the change done
+func (s *Server) adminUserURL(name string, done string) string {
+	return s.startConf.Admin.Path + "/user?" + url.Values{"name": {name}, "done": {done}}.Encode()
+}
+
+/// Returns what the admin console shows about user name
+func (s *Server) adminUserInfo(name string) pages.AdminUser {
+	info, _ := s.pwManager.Info(name)
+	set := ""
+	if !info.PasswordSet.IsZero() {
+		set = info.PasswordSet.Local().Format(accountTimeFormat)
+	}
+	return pages.AdminUser{
+		Name:           name,
+		Email:          info.Email,
+		Groups:         strings.Join(info.Groups, ", "),
+		PasswordSet:    set,
+		ChangeRequired: s.pwManager.ChangeRequired(name),
+		Disabled:       info.Disabled,
+		Locked:         s.lockout.IsLocked(name),
+		Sessions:       len(s.sessionStore.Sessions(name)),
+	}
+}
+
+/// Renders admin.html with status
+func (s *Server) renderAdmin(w http.ResponseWriter, r *http.Request, status int, data pages.AdminData) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	for _, name := range s.pwManager.Users() {
+		data.Users = append(data.Users, s.adminUserInfo(name))
+	}
+	events, err := logging.RecentAudit(adminAuditEvents, func(logging.AuditEvent) bool { return true })
+	if err != nil {
+		logging.Error(fmt.Errorf("unable to read the audit log: %s", err))
+	}
+	for _, e := range events {
+		data.Audit = append(data.Audit, pages.AuditInfo{
+			Time:   e.Time.Local().Format(accountTimeFormat),
+			Event:  e.Event,
+			User:   e.User,
+			IP:     e.IP,
+			Detail: e.Detail,
+		})
+	}
+
+	live := s.live()
+	data.Branding = live.pages.Branding()
+	data.Locale = s.locale(w, r)
+	data.CSRFToken = csrf
+	data.Path = s.startConf.Admin.Path
+	data.AuditLog = s.startConf.AuditFile != ""
+	err = live.pages.Render(w, status, "admin.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
+
+/// Renders admin_user.html with status
+func (s *Server) renderAdminUser(w http.ResponseWriter, r *http.Request, status int, data pages.AdminUserData) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if name := data.User.Name; name != "" && s.pwManager.Exists(name) {
+		data.User = s.adminUserInfo(name)
+		for _, session := range s.sessionStore.Sessions(name) {
+			data.Sessions = append(data.Sessions, sessionInfo(session, ""))
+		}
+	}
+
+	live := s.live()
+	data.Branding = live.pages.Branding()
+	data.Locale = s.locale(w, r)
+	data.CSRFToken = csrf
+	data.Path = s.startConf.Admin.Path
+	err = live.pages.Render(w, status, "admin_user.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
+
+/// Returns the groups in a comma separated list, without spaces around them
+func splitGroups(list string) []string {
+	groups := []string{}
+	for _, g := range strings.Split(list, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func logIfError(err error) {
+	if err != nil {
+		logging.Error(err)
+	}
+}
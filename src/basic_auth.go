@@ -0,0 +1,146 @@
+package main
+
+import (
+	"better_auth/access"
+	"better_auth/logging"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/// Remembers recently verified Basic auth credentials so bcrypt does not run
+/// on every nginx sub-request. Only a hash of the credentials is kept.
+type basicAuthCache struct {
+	entries  map[string]time.Time
+	lifetime time.Duration
+	lock     sync.Mutex
+}
+
+func newBasicAuthCache(lifetime int) *basicAuthCache {
+	return &basicAuthCache{
+		entries:  make(map[string]time.Time),
+		lifetime: time.Second * time.Duration(lifetime),
+		lock:     sync.Mutex{},
+	}
+}
+
+func basicAuthCacheKey(username string, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+/// Returns bool indicating if username and password were verified recently
+func (c *basicAuthCache) contains(username string, password string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := basicAuthCacheKey(username, password)
+	exp, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if exp.Before(time.Now()) {
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+func (c *basicAuthCache) add(username string, password string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lifetime <= 0 {
+		return
+	}
+
+	now := time.Now()
+	for k, exp := range c.entries {
+		if exp.Before(now) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[basicAuthCacheKey(username, password)] = now.Add(c.lifetime)
+}
+
+/// Changes how long, in seconds, credentials are remembered and forgets those
+/// already cached
+func (c *basicAuthCache) setLifetime(lifetime int) {
+	c.lock.Lock()
+	c.lifetime = time.Second * time.Duration(lifetime)
+	c.lock.Unlock()
+	c.clear()
+}
+
+/// Forgets all cached credentials, eg after the password file is reloaded
+func (c *basicAuthCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k := range c.entries {
+		delete(c.entries, k)
+	}
+}
+
+/// Returns bool indicating if Basic auth may be used for the original request
+/// nginx is asking about
+func (s *Server) basicAuthAllowed(r *http.Request) bool {
+	cfg := s.live().conf.BasicAuth
+	if !cfg.Enabled {
+		return false
+	}
+	if len(cfg.Locations) == 0 {
+		return true
+	}
+
+	p := access.CleanPath(r.Header.Get("X-Original-URI"))
+	for _, loc := range cfg.Locations {
+		if access.PathUnder(p, loc) {
+			return true
+		}
+	}
+	return false
+}
+
+/// Verifies the Authorization header of r, if any.
+/// Failures count towards the same lockout as form logins
+func (s *Server) verifyBasicAuth(r *http.Request) bool {
+	usr, pwd, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	if s.lockout.IsLocked(usr) {
+		s.audit(r, logging.AuditLoginFailure, usr, "basic auth, locked out")
+		return false
+	}
+
+	if s.basicCache.contains(usr, pwd) {
+		// the user may have been disabled or removed since
+		info, exists := s.pwManager.Info(usr)
+		return exists && !info.Disabled && s.pwManager.ChangeRequired(usr) == ""
+	}
+
+	if s.pwManager.Verify(usr, pwd) {
+		s.lockout.Reset(usr)
+		// there is no page to change it on, so the login page must be used
+		if reason := s.pwManager.ChangeRequired(usr); reason != "" {
+			s.audit(r, logging.AuditLoginFailure, usr, "basic auth, password change required: "+reason)
+			return false
+		}
+		s.basicCache.add(usr, pwd)
+		s.audit(r, logging.AuditLoginSuccess, usr, "basic auth")
+		return true
+	}
+
+	s.audit(r, logging.AuditLoginFailure, usr, "basic auth, invalid username or password")
+	if s.lockout.Fail(usr) {
+		s.audit(r, logging.AuditLockout, usr, "")
+	}
+	return false
+}
+
+func (s *Server) setBasicAuthChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, s.live().conf.BasicAuth.Realm))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"better_auth/logging"
+	"better_auth/pages"
+	"net/http"
+	"net/url"
+)
+
+/// Returns the page a user whose password must change is sent to, which
+/// sends them on to next once it has
+func changePasswordURL(next string) string {
+	return "/login/change?next=" + url.QueryEscape(next)
+}
+
+/// Lets a user whose password expired or was flagged for change choose a new
+/// one. Only sessions restricted by login may use it, others are sent on to
+/// the `next` path
+/// GET returns the form for the new password
+/// POST sets the new password and swaps the restricted session for a full
+///   one, then redirects to `next`. Returns 400 and the form again if the
+///   passwords do not match or are not allowed, and 403 if the csrf token
+///   isn't valid
+func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
+	next := localPath(r.FormValue("next"))
+	id, _ := r.Cookie(SESSION_TOKEN)
+	if id == nil || !s.sessionStore.IsRestricted(id.Value) {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	user := s.sessionStore.User(id.Value)
+
+	reason := s.pwManager.ChangeRequired(user)
+	if reason == "" {
+		// eg an admin cleared the flag since the user logged in
+		s.sessionStore.SetRestricted(id.Value, false)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	data := pages.ChangeData{Next: next, Reason: reason}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderChange(w, r, 200, data)
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			data.Error = loginErrExpired
+			s.renderChange(w, r, 403, data)
+			return
+		}
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			data.Error = resetErrMismatch
+			s.renderChange(w, r, 400, data)
+			return
+		}
+		err := s.pwManager.CheckPassword(user, password)
+		if err != nil {
+			data.Error = resetErrWeak
+			data.Problems = s.policyProblems(w, r, err)
+			s.renderChange(w, r, 400, data)
+			return
+		}
+
+		err = s.pwManager.SetPassword(user, password)
+		if err != nil {
+			logging.Error(err)
+			w.WriteHeader(500)
+			return
+		}
+		s.sessionStore.RemoveUser(user)
+		s.passwordChanged(w, r, user, "required change: "+reason)
+
+		token, err := s.sessionStore.NewUserToken(user, s.clientIP(r))
+		if err != nil {
+			logging.Error(err)
+			w.WriteHeader(500)
+			return
+		}
+		s.sessionStore.SetUserAgent(token.ID(), r.UserAgent())
+		// the login already checked and recorded the location, so it is only
+		// looked up for the session list
+		if live := s.live(); live.geo.Enabled() {
+			loc := live.geo.Lookup(s.clientIP(r))
+			s.sessionStore.SetLocation(token.ID(), loc.Country, loc.ASN)
+		}
+		http.SetCookie(w, token.ToCookie())
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Renders change.html with status
+func (s *Server) renderChange(w http.ResponseWriter, r *http.Request, status int, data pages.ChangeData) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	live := s.live()
+	data.Branding = live.pages.Branding()
+	data.Locale = s.locale(w, r)
+	data.CSRFToken = csrf
+	err = live.pages.Render(w, status, "change.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
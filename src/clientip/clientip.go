@@ -0,0 +1,125 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/// Resolver finds the address of the client behind any trusted proxies
+/// (usually nginx) from the request's peer address and forwarding headers.
+/// Forwarding headers are only believed when the peer is a trusted proxy,
+/// since anyone else can send whatever they like
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+/// Creates a Resolver trusting proxies in cidrs, eg "127.0.0.1/32" or
+/// "10.0.0.0/8". A plain ip address trusts just that address
+func New(cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, c := range cidrs {
+		n, err := ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, n)
+	}
+	return r, nil
+}
+
+/// Parses a cidr, or a single ip address as a /32 or /128 network
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("`%s` is not an ip address or cidr", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("`%s` is not an ip address or cidr", s)
+	}
+	return n, nil
+}
+
+/// Returns whether ip is a trusted proxy
+func (res *Resolver) IsTrusted(ip net.IP) bool {
+	for _, n := range res.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/// Returns the ip address of the client that sent r.
+///  If the peer is not a trusted proxy it is the client.
+///  Otherwise X-Forwarded-For is walked from right to left, skipping trusted
+///    proxies, and the first untrusted address is the client. Entries left of
+///    it were sent by the client and cannot be believed. If every entry is a
+///    trusted proxy the leftmost is the client.
+///  Without X-Forwarded-For, X-Real-IP is used.
+/// Peers without an ip address, ie connections over a unix socket, can only
+/// be local processes and are trusted
+func (res *Resolver) IP(r *http.Request) string {
+	peer := peerIP(r)
+	if peer != nil && !res.IsTrusted(peer) {
+		return peer.String()
+	}
+
+	client := peer
+	forwarded := forwardedFor(r)
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil {
+			// nothing left of a garbled entry can be believed
+			break
+		}
+		client = ip
+		if !res.IsTrusted(ip) {
+			break
+		}
+	}
+
+	if len(forwarded) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			client = ip
+		}
+	}
+	if client == nil {
+		return r.RemoteAddr
+	}
+	return client.String()
+}
+
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+/// Returns every address in X-Forwarded-For headers, oldest first
+func forwardedFor(r *http.Request) []string {
+	var addrs []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, a := range strings.Split(h, ",") {
+			a = strings.TrimSpace(a)
+			if a != "" {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,57 @@
+package clientip
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseCIDR(t *testing.T) {
+	for _, s := range []string{"127.0.0.1", "::1", "10.0.0.0/8", "fd00::/8"} {
+		if _, err := ParseCIDR(s); err != nil {
+			t.Fatalf("%s not parsed: %s", s, err)
+		}
+	}
+	for _, s := range []string{"", "localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := ParseCIDR(s); err == nil {
+			t.Fatalf("%s parsed", s)
+		}
+	}
+}
+
+func TestIP(t *testing.T) {
+	res, err := New([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name      string
+		peer      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"untrusted peer", "203.0.113.7:4000", []string{"198.51.100.1"}, "198.51.100.1", "203.0.113.7"},
+		{"trusted peer without headers", "127.0.0.1:4000", nil, "", "127.0.0.1"},
+		{"real ip", "127.0.0.1:4000", nil, "203.0.113.7", "203.0.113.7"},
+		{"forwarded", "127.0.0.1:4000", []string{"203.0.113.7"}, "203.0.113.7", "203.0.113.7"},
+		{"spoofed entries", "127.0.0.1:4000", []string{"198.51.100.1, 203.0.113.7"}, "", "203.0.113.7"},
+		{"proxy chain", "127.0.0.1:4000", []string{"203.0.113.7, 10.1.2.3", "10.0.0.1"}, "", "203.0.113.7"},
+		{"only proxies", "127.0.0.1:4000", []string{"10.0.0.2, 10.0.0.1"}, "", "10.0.0.2"},
+		{"garbled entry", "127.0.0.1:4000", []string{"203.0.113.7, nonsense, 10.0.0.1"}, "", "10.0.0.1"},
+		{"unix socket", "@", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"ipv6", "[::1]:4000", []string{"203.0.113.7"}, "", "::1"},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.peer
+		for _, f := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := res.IP(r); got != c.want {
+			t.Fatalf("%s: got %s, expected %s", c.name, got, c.want)
+		}
+	}
+}
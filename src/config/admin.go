@@ -0,0 +1,26 @@
+package config
+
+import "strings"
+
+/// Paths better_auth serves itself, which the admin console may not use
+var reservedPaths = []string{"/login", "/logout", "/account", "/authrequest", "/reloadpasswd", "/reloadconfig"}
+
+/// AdminConfig is the web console for managing users and sessions.
+///  Path is where it is served, eg /admin. NGINX must send it to better_auth.
+///  Group is the group in PasswdFile whose members may use it.
+type AdminConfig struct {
+	Enabled bool
+	Path    string
+	Group   string
+}
+
+func (c *Config) validateAdmin(v *validator) {
+	a := c.Admin
+	ok := strings.HasPrefix(a.Path, "/") && len(a.Path) > 1 && !strings.HasSuffix(a.Path, "/")
+	v.check("Admin.Path", ok, "must be a path such as /admin, got `%s`", a.Path)
+	for _, p := range reservedPaths {
+		v.check("Admin.Path", a.Path != p && !strings.HasPrefix(a.Path, p+"/"), "may not be under %s, which better_auth already serves", p)
+	}
+	v.check("Admin.Group", a.Group != "" && !strings.ContainsAny(a.Group, ":,= \t"),
+		"must be a group name without `:`, `,`, `=` or spaces, got `%s`", a.Group)
+}
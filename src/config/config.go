@@ -2,26 +2,169 @@ package config
 
 import (
 	"better_auth/files"
+	"better_auth/logging"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/alexflint/go-arg"
-	"github.com/jbrodriguez/mlog"
 )
 
 /// Config represents operating config for entire application
 /// Combines default options, file options, and cli arguments
 type Config struct {
-	AddUser        *adduserCmd `arg:"subcommand:adduser" json:"-"`
-	Address        string      `arg:"-a,--address" help:"server address"`
-	Port           int         `arg:"-p,--port" help:"server port"`
-	SessionTimeout int         `arg:"-"`
-	PasswdFile     string      `arg:"--pw" help:"path to better_auth.pw file"`
-	LogDir         string      `arg:"--logdir" help:"path to log directory"`
-	LogSize        int         `arg:"-"`
-	LogBackups     int         `arg:"-"`
-	ConfigFile     string      `arg:"--config" help:"path to better_auth.conf file" json:"-"`
+	AddUser        *adduserCmd     `arg:"subcommand:adduser" json:"-"`
+	SetEmail       *setemailCmd    `arg:"subcommand:setemail" json:"-"`
+	MustChange     *mustchangeCmd  `arg:"subcommand:mustchange" json:"-"`
+	SetGroups      *setgroupsCmd   `arg:"subcommand:setgroups" json:"-"`
+	InviteCmd      *inviteCmd      `arg:"subcommand:invite" json:"-"`
+	CheckConfig    *checkconfigCmd `arg:"subcommand:checkconfig" json:"-"`
+	ConfigCmd      *configCmd      `arg:"subcommand:config" json:"-"`
+	Address        string          `arg:"-a,--address" help:"server address, or unix:/path/to/socket"`
+	Port           int             `arg:"-p,--port" help:"server port"`
+	SessionTimeout int             `arg:"-"`
+	PasswdFile     string          `arg:"--pw" help:"path to better_auth.pw file"`
+	LogDir         string          `arg:"--logdir" help:"path to log directory"`
+	LogSize        int             `arg:"-"`
+	LogBackups     int             `arg:"-"`
+	LogLevel       string          `arg:"--loglevel" help:"debug, info, warning or error"`
+	LogFormat      string          `arg:"-"`
+	LogOutput      string          `arg:"-"`
+	AuditFile      string          `arg:"-"`
+	TrustedProxies []string        `arg:"-"`
+	Rules          []AccessRule    `arg:"-"`
+	Webhooks       []WebhookTarget `arg:"-"`
+	ConfigFile     string          `arg:"--config" help:"path to better_auth.conf file" json:"-"`
+
+	Lockout   LockoutConfig   `arg:"-"`
+	BasicAuth BasicAuthConfig `arg:"-"`
+	LoginPage LoginPageConfig `arg:"-"`
+	Locale    LocaleConfig    `arg:"-"`
+	Syslog    SyslogConfig    `arg:"-"`
+	TLS       TLSConfig       `arg:"-"`
+	Socket    SocketConfig    `arg:"-"`
+	GeoIP     GeoIPConfig     `arg:"-"`
+
+	WebhookDelivery WebhookDeliveryConfig `arg:"-"`
+	SMTP            SMTPConfig            `arg:"-"`
+	PasswordReset   PasswordResetConfig   `arg:"-"`
+	PasswordPolicy  PasswordPolicyConfig  `arg:"-"`
+	Admin           AdminConfig           `arg:"-"`
+	Invite          InviteConfig          `arg:"-"`
+
+	sources      map[string]Source // setting: where its value came from
+	loadProblems []Problem         // unknown keys and unparsable values found by Build
+}
+
+/// Returns options for logging.Start
+func (c *Config) LogOptions() logging.Options {
+	return logging.Options{
+		Level:     c.LogLevel,
+		Format:    c.LogFormat,
+		Output:    c.LogOutput,
+		Dir:       c.LogDir,
+		MaxSize:   c.LogSize,
+		Backups:   c.LogBackups,
+		AuditFile: c.AuditFile,
+		Syslog: logging.SyslogOptions{
+			Network:       c.Syslog.Network,
+			Address:       c.Syslog.Address,
+			Facility:      c.Syslog.Facility,
+			AuditFacility: c.Syslog.AuditFacility,
+			AppName:       c.Syslog.AppName,
+		},
+	}
+}
+
+/// SyslogConfig is used when LogOutput is "syslog".
+///  Network is unixgram, udp or tcp and Address the socket path or host:port.
+///  AuditFacility keeps audit events apart from the operational log.
+type SyslogConfig struct {
+	Network       string
+	Address       string
+	Facility      string
+	AuditFacility string
+	AppName       string
+}
+
+/// AccessRule decides what happens to requests from Networks for Hosts and
+/// Paths. Rules are checked in order and the first matching rule is used.
+///  Networks are ip addresses or cidrs of the client. Empty matches any client.
+///  Hosts are host names, or *.example.com for any subdomain. Empty matches any.
+///  Paths are path prefixes matching whole segments, so /public matches
+///    /public/x but not /publicly. Empty matches any path.
+///  Action is `allow` to let requests through without logging in, `auth` to
+///    require a login as usual, or `deny` to refuse requests and logins.
+type AccessRule struct {
+	Networks []string
+	Hosts    []string
+	Paths    []string
+	Action   string
+}
+
+/// Access rule actions
+const (
+	ActionAllow = "allow"
+	ActionAuth  = "auth"
+	ActionDeny  = "deny"
+)
+
+/// LockoutConfig controls how many failed logins a user may make before being
+/// locked out. MaxAttempts of 0 disables lockouts. Times are in seconds.
+type LockoutConfig struct {
+	MaxAttempts int
+	Window      int
+	Duration    int
+}
+
+/// LoginPageConfig controls branding of the login page.
+///  Title replaces the translated default page title.
+///  Logo is an image url, which may be a data: uri.
+///  CustomCSS is the path to a css file added after the default styles.
+///  Banner is a notice shown above the login form, eg "Authorized use only".
+///  TemplateDir may contain a login.html replacing the built-in template.
+type LoginPageConfig struct {
+	Title       string
+	Logo        string
+	CustomCSS   string
+	Footer      string
+	Banner      string
+	TemplateDir string
+}
+
+/// LocaleConfig controls the language of pages shown to users.
+///  Language forces a language, eg "de", instead of using the browser's
+///    Accept-Language header. Empty negotiates per request.
+///  CatalogDir holds additional message catalogs. Empty uses a `locales`
+///    directory next to the config file.
+type LocaleConfig struct {
+	Language   string
+	CatalogDir string
+}
+
+/// Returns the directory additional message catalogs are loaded from
+func (c *Config) LocaleDir() string {
+	if c.Locale.CatalogDir != "" {
+		return c.Locale.CatalogDir
+	}
+	return filepath.Join(filepath.Dir(c.ConfigFile), "locales")
+}
+
+/// BasicAuthConfig controls the opt-in HTTP Basic auth fallback on
+/// /authrequest for clients that cannot use the login page.
+///  Locations limits the fallback to request paths (as sent by nginx in
+///    X-Original-URI, decoded and cleaned) under one of the listed prefixes,
+///    matching whole segments. Empty allows all.
+///  CacheTTL is the time in seconds a successful verification is remembered.
+///  Challenge sends a WWW-Authenticate header with Realm on failure.
+type BasicAuthConfig struct {
+	Enabled   bool
+	Locations []string
+	CacheTTL  int
+	Challenge bool
+	Realm     string
 }
 
 func Default() *Config {
@@ -33,31 +176,157 @@ func Default() *Config {
 		LogDir:         DefaultPaths.Log,
 		LogSize:        1,
 		LogBackups:     5,
+		LogLevel:       "info",
+		LogFormat:      logging.FormatText,
+		LogOutput:      logging.OutputFile,
+		AuditFile:      filepath.Join(DefaultPaths.Log, "audit.log"),
+		TrustedProxies: []string{"127.0.0.1", "::1"},
+		Rules:          []AccessRule{},
+		Webhooks:       []WebhookTarget{},
 
 		ConfigFile: DefaultPaths.Config,
+
+		Lockout: LockoutConfig{
+			MaxAttempts: 5,
+			Window:      900,
+			Duration:    900,
+		},
+		BasicAuth: BasicAuthConfig{
+			Enabled:   false,
+			Locations: []string{},
+			CacheTTL:  60,
+			Challenge: false,
+			Realm:     "better_auth",
+		},
+		LoginPage: LoginPageConfig{},
+		Locale:    LocaleConfig{},
+		Syslog: SyslogConfig{
+			Network:       "unixgram",
+			Address:       "/dev/log",
+			Facility:      "daemon",
+			AuditFacility: "authpriv",
+			AppName:       "better_auth",
+		},
+		TLS: TLSConfig{
+			MinVersion: "1.2",
+		},
+		Socket: SocketConfig{
+			Mode: "0660",
+		},
+		GeoIP: GeoIPConfig{
+			Databases:        []string{},
+			NewCountry:       true,
+			ImpossibleTravel: true,
+			MaxSpeed:         1000,
+			Action:           GeoIPLog,
+		},
+		WebhookDelivery: WebhookDeliveryConfig{
+			QueueSize:      1000,
+			Retries:        5,
+			Timeout:        10,
+			DeadLetterFile: filepath.Join(DefaultPaths.Log, "webhook_dead_letters.log"),
+		},
+		SMTP: SMTPConfig{
+			Port:     587,
+			Security: SMTPStartTLS,
+		},
+		PasswordReset: PasswordResetConfig{
+			Enabled:  false,
+			Lifetime: 900,
+			Notify:   true,
+		},
+		PasswordPolicy: PasswordPolicyConfig{
+			MinLength:        8,
+			MaxLength:        64,
+			DisallowUsername: true,
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Path:    "/admin",
+			Group:   "admin",
+		},
+		Invite: InviteConfig{
+			Lifetime: 604800,
+		},
 	}
 }
 
 type adduserCmd struct {
-	Username string `arg:"positional,required" help:"New user name"`
-	Password string `arg:"positional" help:"New user's password"`
+	Username string   `arg:"positional,required" help:"New user name"`
+	Password string   `arg:"positional" help:"New user's password"`
+	Email    string   `arg:"--email" help:"New user's email address, where password reset links are sent"`
+	Change   bool     `arg:"--must-change" help:"require the user to choose a new password at their first login"`
+	Groups   []string `arg:"--groups" help:"groups the new user is in, eg admin"`
+}
+
+/// setemail sets or, given no Email, removes the email address of a user
+type setemailCmd struct {
+	Username string `arg:"positional,required" help:"user name"`
+	Email    string `arg:"positional" help:"email address, empty to remove it"`
+}
+
+/// mustchange requires a user to change their password at their next login,
+/// or with Clear no longer requires it
+type mustchangeCmd struct {
+	Username string `arg:"positional,required" help:"user name"`
+	Clear    bool   `arg:"--clear" help:"no longer require a change"`
+}
+
+/// setgroups sets the groups a user is in, given no Groups removes them from
+/// every group
+type setgroupsCmd struct {
+	Username string   `arg:"positional,required" help:"user name"`
+	Groups   []string `arg:"positional" help:"groups, eg admin"`
 }
 
+/// invite creates a link for a new user to choose their own password
+type inviteCmd struct {
+	Username string   `arg:"positional,required" help:"New user name"`
+	Groups   []string `arg:"--groups" help:"groups the new user is in, eg admin"`
+}
+
+/// checkconfig validates the config and exits non-zero if it has problems
+type checkconfigCmd struct{}
+
+/// config groups subcommands for inspecting the config
+type configCmd struct {
+	Show    *configShowCmd    `arg:"subcommand:show" help:"print the effective config and where each value came from"`
+	Migrate *configMigrateCmd `arg:"subcommand:migrate" help:"convert the config file to yaml or toml"`
+}
+
+/// config show prints the effective config with secrets redacted
+type configShowCmd struct{}
+
+/// config migrate writes the settings of the config file to Dest, in the
+/// format given by Dest's extension
+type configMigrateCmd struct {
+	Dest  string `arg:"positional,required" help:"new config file, eg /etc/better_auth/better_auth.yaml"`
+	Force bool   `arg:"--force" help:"overwrite Dest if it exists"`
+}
+
+/// Builds config from defaults, then the config file, then BETTER_AUTH_*
+/// environment variables, then cli arguments, recording which of them each
+/// setting came from
 func Build() (*Config, error) {
 	var err error
 
 	confPath := getConfigPath()
 	conf := Default()
-	err = parseJsonOver(conf, confPath)
+	err = parseFileOver(conf, confPath)
 	if err != nil {
 		return nil, err
 	}
+	parseEnvOver(conf, os.Environ())
+
+	before := snapshot(conf)
 	parseArgsOver(conf)
+	conf.recordChanges(before, SourceFlag)
 	return conf, nil
 }
 
 func getConfigPath() string {
 	def := Default()
+	parseEnvOver(def, os.Environ())
 	parseArgsOver(def)
 	return def.ConfigFile
 }
@@ -67,44 +336,74 @@ func parseArgsOver(conf *Config) {
 	arg.MustParse(conf)
 }
 
-func parseJsonOver(conf *Config, filePath string) error {
+/// Applies the config file at filePath over conf, writing a new default
+/// config first if it does not exist. The format is chosen by extension
+func parseFileOver(conf *Config, filePath string) error {
 	if !files.FileExists(filePath) {
 		writeNewDefault(filePath)
 	}
 
-	mlog.Info("Loading config file `%s`\n", filePath)
-	file, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
+	logging.Info("Loading config file `%s`\n", filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("unable to read from config file `%s`: %s", filePath, err)
 	}
-	defer file.Close()
 
-	err = json.NewDecoder(file).Decode(conf)
+	data, err = toJson(data, fileFormat(filePath))
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid config file `%s`: %s", filePath, err)
 	}
 
-	return nil
+	err = json.Unmarshal(data, conf)
+	if err != nil {
+		return fmt.Errorf("invalid config file `%s`: %s", filePath, err)
+	}
+
+	return conf.recordJsonKeys(data)
 }
 
 func writeNewDefault(filePath string) error {
-	mlog.Info("Config file not found at %s, writing a new default config\n", filePath)
+	logging.Info("Config file not found at %s, writing a new default config\n", filePath)
+	return writeConfigFile(Default(), filePath, os.O_WRONLY|os.O_APPEND)
+}
 
-	jsonDump, err := json.MarshalIndent(Default(), "", "\t")
+func writeConfigFile(conf *Config, filePath string, flag int) error {
+	f, err := files.MkDirsAndOpen(filePath, flag, 0644)
 	if err != nil {
-		return err
+		return fmt.Errorf("Config file `%s` could not be created: %s", filePath, err)
 	}
+	defer f.Close()
 
-	f, err := files.MkDirsAndOpen(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+	err = writeConfig(f, conf, fileFormat(filePath))
 	if err != nil {
-		return fmt.Errorf("Config file `%s` could not be created: %s", filePath, err)
+		return fmt.Errorf("Config file `%s` could not be written to: %s", filePath, err)
+	}
+	return nil
+}
 
+/// Writes the settings of the config file at src to dest, converting to the
+/// format of dest's extension. Environment variables and flags are not
+/// included. Refuses to drop keys src has that match no setting
+func Migrate(src string, dest string, overwrite bool) error {
+	if !files.FileExists(src) {
+		return fmt.Errorf("config file `%s` does not exist", src)
 	}
-	_, err = f.Write(jsonDump)
+	if files.FileExists(dest) && !overwrite {
+		return fmt.Errorf("`%s` already exists", dest)
+	}
+
+	conf := Default()
+	err := parseFileOver(conf, src)
 	if err != nil {
-		return fmt.Errorf("Config file `%s` could not be written to: %s", filePath, err)
+		return err
+	}
+	if len(conf.loadProblems) > 0 {
+		lost := []string{}
+		for _, p := range conf.loadProblems {
+			lost = append(lost, p.Field)
+		}
+		return fmt.Errorf("`%s` has unknown settings that would be lost: %s", src, strings.Join(lost, ", "))
 	}
-	f.Close()
 
-	return nil
+	return writeConfigFile(conf, dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 }
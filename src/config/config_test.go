@@ -1,16 +1,15 @@
 package config
 
 import (
+	"better_auth/logging"
 	"os"
 	"path"
 	"reflect"
 	"testing"
-
-	"github.com/jbrodriguez/mlog"
 )
 
 func TestMain(m *testing.M) {
-	mlog.Start(mlog.LevelError, "")
+	logging.SetLevel(logging.LevelError)
 	m.Run()
 }
 
@@ -34,7 +33,10 @@ func TestNew(t *testing.T) {
 	tc := vc.Type()
 	for i := 0; i < tc.NumField(); i++ {
 		n := tc.Field(i).Name
-		if vc.FieldByName(n).Interface() != vd.FieldByName(n).Interface() {
+		if !tc.Field(i).IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(vc.FieldByName(n).Interface(), vd.FieldByName(n).Interface()) {
 			t.Logf("Config mismatch at field %s", n)
 			t.FailNow()
 		}
@@ -66,10 +68,45 @@ func TestNewDefault(t *testing.T) {
 			if ft == "" {
 				t.Fatalf("Field %s should not be empty", vc.Field(i).Type().Name())
 			}
+		case []string:
+			if len(ft) == 0 {
+				t.Fatalf("Field %s should not be empty", n)
+			}
+		case []AccessRule:
+			// no rules by default
+		case []WebhookTarget:
+			// no webhooks by default
 		case *adduserCmd:
 			if ft != nil {
 				t.Fatal("Subcommand fields should be nil")
 			}
+		case *mustchangeCmd:
+			if ft != nil {
+				t.Fatal("Subcommand fields should be nil")
+			}
+		case *setemailCmd:
+			if ft != nil {
+				t.Fatal("Subcommand fields should be nil")
+			}
+		case *setgroupsCmd:
+			if ft != nil {
+				t.Fatal("Subcommand fields should be nil")
+			}
+		case *inviteCmd:
+			if ft != nil {
+				t.Fatal("Subcommand fields should be nil")
+			}
+		case *checkconfigCmd:
+			if ft != nil {
+				t.Fatal("Subcommand fields should be nil")
+			}
+		case *configCmd:
+			if ft != nil {
+				t.Fatal("Subcommand fields should be nil")
+			}
+		case LockoutConfig, BasicAuthConfig, LoginPageConfig, LocaleConfig, SyslogConfig, TLSConfig, SocketConfig, GeoIPConfig, WebhookDeliveryConfig,
+			SMTPConfig, PasswordResetConfig, PasswordPolicyConfig, AdminConfig, InviteConfig:
+			// groups of options, which may legitimately hold zero values
 		default:
 			t.Logf("Unexpected type %s. This may be an error or the test may need to be updated", vc.Field(i).Type())
 			t.Fail()
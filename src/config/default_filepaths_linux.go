@@ -3,13 +3,13 @@
 package config
 
 var DefaultPaths struct {
-	Config  string
-	Passwd 		string
-	Log         string
+	Config string
+	Passwd string
+	Log    string
 }
 
-func inti(){
+func init() {
 	DefaultPaths.Config = "/etc/better_auth/better_auth.conf"
 	DefaultPaths.Passwd = "/etc/better_auth/better_auth.pw"
 	DefaultPaths.Log = "/var/log/better_auth/"
-}
\ No newline at end of file
+}
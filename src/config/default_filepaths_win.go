@@ -18,5 +18,5 @@ func init() {
 	dir := path.Join(u.HomeDir, "better_auth")
 	DefaultPaths.Config = path.Join(dir, "better_auth.conf")
 	DefaultPaths.Passwd = path.Join(dir, "better_auth.pw")
-	DefaultPaths.Log = path.Join(dir, "logs", "better_auth.log")
+	DefaultPaths.Log = path.Join(dir, "logs")
 }
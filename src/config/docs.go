@@ -0,0 +1,116 @@
+package config
+
+/// Descriptions of every setting and group, written as comments in new yaml
+/// and toml config files. Keep in line with the settings in README.md
+var settingDocs = map[string]string{
+	"Address":        "ip address on which the server will listen, or unix:/path/to/socket",
+	"Port":           "port number on which the server will listen, unused for unix sockets",
+	"SessionTimeout": "time in seconds after which an inactive session expires",
+	"PasswdFile":     "file containing users and passwords entered via `adduser`",
+	"LogDir":         "directory containing better_auth.log",
+	"LogSize":        "size in megabytes at which better_auth.log is rotated",
+	"LogBackups":     "number of rotated log files kept",
+	"LogLevel":       "lowest level logged: debug, info, warning or error",
+	"LogFormat":      "text, or json for one json object per line",
+	"LogOutput":      "file to write to LogDir, stdout, journald or syslog",
+	"AuditFile":      "append-only audit log of security events, empty disables it",
+	"TrustedProxies": "ip addresses or cidrs of proxies whose X-Forwarded-For and X-Real-IP headers are believed",
+	"Rules": "access rules checked in order, the first match decides. Each has Networks (cidrs),\n" +
+		"Hosts (eg *.example.com), Paths (prefixes), all empty to match anything, and\n" +
+		"Action: allow (no login needed), auth (login as usual) or deny",
+	"Webhooks": "urls sent audit events as they happen. Each has a URL, Events to send (empty sends\n" +
+		"all), a Secret signing requests with HMAC-SHA256, and a Template for the body (empty\n" +
+		"sends the event's json)",
+
+	"Lockout":             "Failed login limits, shared by the login page and Basic auth",
+	"Lockout.MaxAttempts": "failed logins allowed before a user is locked out, 0 disables lockouts",
+	"Lockout.Window":      "time in seconds over which failed logins are counted",
+	"Lockout.Duration":    "time in seconds a locked out user must wait",
+
+	"BasicAuth":           "HTTP Basic auth for clients that cannot use the login page",
+	"BasicAuth.Enabled":   "accept an `Authorization: Basic` header on /authrequest",
+	"BasicAuth.Locations": "path prefixes where Basic auth is accepted, empty allows every location",
+	"BasicAuth.CacheTTL":  "time in seconds a verified username and password is remembered",
+	"BasicAuth.Challenge": "send a WWW-Authenticate header so clients prompt for credentials",
+	"BasicAuth.Realm":     "realm sent with the challenge",
+
+	"LoginPage":             "Branding for the login page",
+	"LoginPage.Title":       "page title, replacing the translated default",
+	"LoginPage.Logo":        "image url or data:image/... uri shown above the form",
+	"LoginPage.CustomCSS":   "path to a css file applied after the default styles",
+	"LoginPage.Footer":      "text shown below the form",
+	"LoginPage.Banner":      "notice shown above the form, eg Authorized use only",
+	"LoginPage.TemplateDir": "directory containing a login.html replacing the built-in template",
+
+	"Locale":            "Language of the login page",
+	"Locale.Language":   "language used for every visitor, eg de. Empty uses Accept-Language",
+	"Locale.CatalogDir": "directory of additional message catalogs, empty uses `locales` next to this file",
+
+	"Syslog":               "RFC 5424 syslog, used when LogOutput is syslog",
+	"Syslog.Network":       "unixgram, udp or tcp",
+	"Syslog.Address":       "socket path or host:port of the syslog daemon",
+	"Syslog.Facility":      "facility of operational messages",
+	"Syslog.AuditFacility": "facility of audit events, sent in addition to AuditFile",
+	"Syslog.AppName":       "APP-NAME of every message",
+
+	"TLS":              "Serve https, for when nginx and better_auth are on different hosts",
+	"TLS.CertFile":     "pem certificate (chain), empty serves plain http",
+	"TLS.KeyFile":      "pem private key of CertFile",
+	"TLS.MinVersion":   "lowest TLS version accepted, 1.2 or 1.3",
+	"TLS.ClientCAFile": "pem CA certificates; if set clients must present a certificate signed by one of them",
+
+	"Socket":       "Ownership of the socket when Address is unix:/path/to/socket",
+	"Socket.Owner": "user name or id owning the socket, empty keeps the service's user",
+	"Socket.Group": "group name or id of the socket, eg www-data so nginx can connect",
+	"Socket.Mode":  "octal permissions of the socket",
+
+	"GeoIP":                  "Flag unusual logins using local MaxMind databases",
+	"GeoIP.Databases":        ".mmdb files, eg GeoLite2-City.mmdb and GeoLite2-ASN.mmdb, empty disables GeoIP",
+	"GeoIP.NewCountry":       "flag logins from a country the user has not logged in from before",
+	"GeoIP.ImpossibleTravel": "flag logins too far from the user's previous login to have travelled in between",
+	"GeoIP.MaxSpeed":         "fastest travel in km/h considered possible",
+	"GeoIP.Action":           "log to audit flagged logins, or block to refuse them too. Asking for a second factor is not supported yet",
+
+	"WebhookDelivery":                "How events are sent to Webhooks",
+	"WebhookDelivery.QueueSize":      "events waiting to be sent to each webhook, more are not sent",
+	"WebhookDelivery.Retries":        "times a failed request is repeated, with exponential backoff",
+	"WebhookDelivery.Timeout":        "time in seconds allowed per request",
+	"WebhookDelivery.DeadLetterFile": "events that could not be sent, one json object per line. Empty only logs them",
+
+	"SMTP":          "Mail server used to send password reset links",
+	"SMTP.Host":     "host name of the mail server, empty if email is not used",
+	"SMTP.Port":     "port of the mail server, usually 587 for starttls and 465 for tls",
+	"SMTP.Security": "starttls, tls or none for a local relay",
+	"SMTP.Username": "user to log in to the mail server as, empty does not log in",
+	"SMTP.Password": "password of Username",
+	"SMTP.From":     "sender of every email, eg better_auth <noreply@example.com>",
+
+	"PasswordReset":          "Let users email themselves a link to choose a new password",
+	"PasswordReset.Enabled":  "show a forgot password link on the login page, requires SMTP",
+	"PasswordReset.URL":      "url of the site the login page is on, eg https://example.com, used in links",
+	"PasswordReset.Lifetime": "time in seconds a reset link works for",
+	"PasswordReset.Notify":   "email users whenever their password is changed",
+
+	"PasswordPolicy":                  "Rules every new password must follow",
+	"PasswordPolicy.MinLength":        "fewest characters allowed",
+	"PasswordPolicy.MaxLength":        "most characters allowed, at most 72. Passwords are never longer than 72 bytes either",
+	"PasswordPolicy.RequireUpper":     "require an upper case letter",
+	"PasswordPolicy.RequireLower":     "require a lower case letter",
+	"PasswordPolicy.RequireDigit":     "require a digit",
+	"PasswordPolicy.RequireSymbol":    "require a character that is not a letter or digit",
+	"PasswordPolicy.DisallowUsername": "refuse passwords containing the username",
+	"PasswordPolicy.MinEntropy":       "least estimated bits of entropy, 0 skips the estimate",
+	"PasswordPolicy.BreachedFile":     "Have I Been Pwned SHA-1 file sorted by hash, or directory of files per 5 character prefix",
+	"PasswordPolicy.History":          "number of the user's latest passwords, including the current one, that cannot be reused",
+	"PasswordPolicy.MaxAge":           "days after which a password must be changed at the next login, 0 never expires passwords",
+
+	"Admin":         "Web console for managing users and sessions",
+	"Admin.Enabled": "serve the admin console",
+	"Admin.Path":    "path the admin console is served at, NGINX must send it to better_auth",
+	"Admin.Group":   "group in PasswdFile whose members may use the admin console",
+
+	"Invite":          "Links for new users to choose their own password, created by the invite subcommand",
+	"Invite.File":     "file pending invites are kept in, empty keeps them next to PasswdFile",
+	"Invite.URL":      "url of the site the login page is on, eg https://example.com, used in links",
+	"Invite.Lifetime": "time in seconds an invite works for",
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/// Prefix of every environment variable read by better_auth
+const EnvPrefix = "BETTER_AUTH_"
+
+/// Suffix of environment variables naming a file to read a value from,
+/// eg a secret mounted by docker or kubernetes
+const envFileSuffix = "_FILE"
+
+/// Returns the environment variable for the setting at path, eg
+/// BETTER_AUTH_LOCKOUT_MAX_ATTEMPTS for "Lockout.MaxAttempts"
+func EnvName(path string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, part := range strings.Split(path, ".") {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteString(snakeCase(part))
+	}
+	return b.String()
+}
+
+/// Converts CamelCase to upper SNAKE_CASE, keeping acronyms together so
+/// "CacheTTL" becomes CACHE_TTL and "CSSFile" CSS_FILE
+func snakeCase(name string) string {
+	r := []rune(name)
+	var b strings.Builder
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			prevLower := !unicode.IsUpper(r[i-1])
+			acronymEnd := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if prevLower || acronymEnd {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+	return b.String()
+}
+
+/// Applies BETTER_AUTH_* variables from environ, in os.Environ's KEY=value
+/// form, over conf. NAME_FILE reads the value of NAME from a file with
+/// trailing line breaks removed. Unknown variables and values that cannot be
+/// parsed are recorded as problems rather than stopping the build
+func parseEnvOver(conf *Config, environ []string) {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		k, v, found := strings.Cut(kv, "=")
+		if found && strings.HasPrefix(k, EnvPrefix) {
+			vars[k] = v
+		}
+	}
+
+	for _, s := range fieldValues(conf) {
+		name := EnvName(s.Path)
+		val, isSet := vars[name]
+		file, isFile := vars[name+envFileSuffix]
+		delete(vars, name)
+		delete(vars, name+envFileSuffix)
+
+		switch {
+		case isSet && isFile:
+			conf.loadProblem(s.Path, SourceEnv, "both %s and %s are set", name, name+envFileSuffix)
+		case isSet:
+			conf.applyEnv(s, name, val, SourceEnv)
+		case isFile:
+			data, err := os.ReadFile(file)
+			if err != nil {
+				conf.loadProblem(s.Path, SourceEnvFile, "%s: %s", name+envFileSuffix, err)
+				continue
+			}
+			conf.applyEnv(s, name+envFileSuffix, strings.TrimRight(string(data), "\r\n"), SourceEnvFile)
+		}
+	}
+
+	unknown := []string{}
+	for k := range vars {
+		unknown = append(unknown, k)
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		conf.loadProblem(k, SourceEnv, "unknown setting")
+	}
+}
+
+func (c *Config) applyEnv(s setting, name string, val string, src Source) {
+	err := setFromString(s.Value, val)
+	if err != nil {
+		c.loadProblem(s.Path, src, "%s: %s", name, err)
+		return
+	}
+	c.setSource(s.Path, src)
+}
+
+/// Parses val into v according to v's type. Lists of strings are comma
+/// separated, other lists json
+func setFromString(v reflect.Value, val string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Int:
+		i, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("`%s` is not a whole number", val)
+		}
+		v.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("`%s` is not true or false", val)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			// eg Rules, given as json
+			ptr := reflect.New(v.Type())
+			err := json.Unmarshal([]byte(val), ptr.Interface())
+			if err != nil {
+				return fmt.Errorf("not a json list: %s", err)
+			}
+			v.Set(ptr.Elem())
+			return nil
+		}
+		list := []string{}
+		for _, item := range strings.Split(val, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	for p, want := range map[string]string{
+		"Port":                "BETTER_AUTH_PORT",
+		"SessionTimeout":      "BETTER_AUTH_SESSION_TIMEOUT",
+		"Lockout.MaxAttempts": "BETTER_AUTH_LOCKOUT_MAX_ATTEMPTS",
+		"BasicAuth.CacheTTL":  "BETTER_AUTH_BASIC_AUTH_CACHE_TTL",
+		"LoginPage.CustomCSS": "BETTER_AUTH_LOGIN_PAGE_CUSTOM_CSS",
+	} {
+		if got := EnvName(p); got != want {
+			t.Fatalf("EnvName(%s) is %s, expected %s", p, got, want)
+		}
+	}
+}
+
+/// Tests that the environment overrides the config file and flags override
+/// the environment
+func TestEnvLayer(t *testing.T) {
+	dir := t.TempDir()
+	f := path.Join(dir, "better_auth.conf")
+	os.WriteFile(f, []byte(`{"Port": 9000, "SessionTimeout": 60, "Lockout": {"MaxAttempts": 3}}`), 0644)
+	realm := path.Join(dir, "realm")
+	os.WriteFile(realm, []byte("secret realm\n"), 0600)
+
+	t.Setenv("BETTER_AUTH_CONFIG_FILE", f)
+	t.Setenv("BETTER_AUTH_PORT", "9001")
+	t.Setenv("BETTER_AUTH_SESSION_TIMEOUT", "120")
+	t.Setenv("BETTER_AUTH_LOCKOUT_WINDOW", "30")
+	t.Setenv("BETTER_AUTH_BASIC_AUTH_ENABLED", "true")
+	t.Setenv("BETTER_AUTH_BASIC_AUTH_LOCATIONS", "/dav/, /git/")
+	t.Setenv("BETTER_AUTH_BASIC_AUTH_REALM_FILE", realm)
+
+	os.Args = []string{os.Args[0], "--port", "9002"}
+	c, err := Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.ConfigFile != f || c.Port != 9002 || c.SessionTimeout != 120 || c.Lockout.MaxAttempts != 3 ||
+		c.Lockout.Window != 30 || !c.BasicAuth.Enabled || c.BasicAuth.Realm != "secret realm" ||
+		!reflect.DeepEqual(c.BasicAuth.Locations, []string{"/dav/", "/git/"}) {
+		t.Fatalf("Unexpected config %+v", c)
+	}
+
+	for field, want := range map[string]Source{
+		"Port":                SourceFlag,
+		"SessionTimeout":      SourceEnv,
+		"Lockout.MaxAttempts": SourceFile,
+		"Lockout.Window":      SourceEnv,
+		"Lockout.Duration":    SourceDefault,
+		"BasicAuth.Realm":     SourceEnvFile,
+	} {
+		if c.Source(field) != want {
+			t.Fatalf("%s from %s, expected %s", field, c.Source(field), want)
+		}
+	}
+	if len(c.Validate()) != 0 {
+		t.Fatalf("Unexpected problems %v", c.Validate())
+	}
+}
+
+func TestEnvProblems(t *testing.T) {
+	c := tempConfig(t)
+	parseEnvOver(c, []string{
+		"BETTER_AUTH_PORT=eighty",
+		"BETTER_AUTH_BASIC_AUTH_CHALLENGE=maybe",
+		"BETTER_AUTH_LOG_LEVEL=debug",
+		"BETTER_AUTH_LOG_LEVEL_FILE=/dev/null",
+		"BETTER_AUTH_AUDIT_FILE_FILE=" + path.Join(t.TempDir(), "missing"),
+		"BETTER_AUTH_PROT=80",
+		"HOME=/root",
+	})
+
+	problems := c.Validate()
+	for field, want := range map[string]Source{
+		"Port":                SourceEnv,
+		"BasicAuth.Challenge": SourceEnv,
+		"LogLevel":            SourceEnv,
+		"AuditFile":           SourceEnvFile,
+		"BETTER_AUTH_PROT":    SourceEnv,
+	} {
+		p := findProblem(problems, field)
+		if p == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+		if p.Source != want {
+			t.Fatalf("Problem with %s reported from %s, expected %s", field, p.Source, want)
+		}
+	}
+	if len(problems) != 5 {
+		t.Fatalf("Unexpected problems %v", problems)
+	}
+}
+
+func TestShow(t *testing.T) {
+	c := tempConfig(t)
+	secret := path.Join(t.TempDir(), "footer")
+	os.WriteFile(secret, []byte("hunter2"), 0600)
+	parseEnvOver(c, []string{"BETTER_AUTH_PORT=9001", "BETTER_AUTH_LOGIN_PAGE_FOOTER_FILE=" + secret})
+	c.Webhooks = []WebhookTarget{{URL: "https://hooks.example.com/", Secret: "swordfish"}}
+
+	var out bytes.Buffer
+	err := c.Show(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), "swordfish") {
+		t.Fatalf("Secret shown in\n%s", out.String())
+	}
+	for _, want := range []string{"Port", "9001", "env", "LoginPage.Footer", redacted, "env_file", "Lockout.MaxAttempts", "hooks.example.com"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Expected `%s` in\n%s", want, out.String())
+		}
+	}
+}
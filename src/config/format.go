@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/// Config file formats, chosen by file extension
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+/// Returns the format of the config file at path. Anything other than
+/// .yaml, .yml or .toml is json, including the default better_auth.conf
+func fileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+/// Converts a yaml or toml config to json so every format is decoded, and
+/// its keys matched to settings, the same way
+func toJson(data []byte, format string) ([]byte, error) {
+	raw := make(map[string]any)
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &raw)
+	case FormatTOML:
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+/// Writes c as a config file in format. Yaml and toml are written with a
+/// comment describing every setting; json cannot hold comments
+func writeConfig(w io.Writer, c *Config, format string) error {
+	if format == FormatJSON {
+		jsonDump, err := json.MarshalIndent(c, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(jsonDump)
+		return err
+	}
+
+	t := &templateWriter{format: format}
+	t.comment("", "better_auth config. Every setting may also be set with a BETTER_AUTH_*")
+	t.comment("", "environment variable, see `better_auth config show`")
+
+	v := reflect.ValueOf(c).Elem()
+	var groups, tableLists []int
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !isFileSetting(f) {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			groups = append(groups, i)
+			continue
+		}
+		// json objects are not toml, lists of them are written as [[tables]]
+		if t.format == FormatTOML && isStructList(f.Type) && v.Field(i).Len() > 0 {
+			tableLists = append(tableLists, i)
+			continue
+		}
+		t.setting("", f.Name, v.Field(i))
+	}
+
+	// toml tables must follow every top level key
+	for _, i := range groups {
+		name := v.Type().Field(i).Name
+		t.group(name)
+		g := v.Field(i)
+		for j := 0; j < g.NumField(); j++ {
+			if isFileSetting(g.Type().Field(j)) {
+				t.setting(name, g.Type().Field(j).Name, g.Field(j))
+			}
+		}
+	}
+
+	for _, i := range tableLists {
+		t.tableList(v.Type().Field(i).Name, v.Field(i))
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+	_, err := w.Write(t.buf.Bytes())
+	return err
+}
+
+/// Returns whether f belongs in a config file. Subcommands and settings
+/// tagged json:"-" are only given as flags or environment variables
+func isFileSetting(f reflect.StructField) bool {
+	return f.IsExported() && f.Tag.Get("json") != "-" && !strings.HasPrefix(f.Tag.Get("arg"), "subcommand")
+}
+
+func isStructList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct
+}
+
+type templateWriter struct {
+	format string
+	buf    bytes.Buffer
+	err    error
+}
+
+func (t *templateWriter) indent(group string) string {
+	if group != "" && t.format == FormatYAML {
+		return "  "
+	}
+	return ""
+}
+
+func (t *templateWriter) comment(group string, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&t.buf, "%s# %s\n", t.indent(group), line)
+	}
+}
+
+func (t *templateWriter) group(name string) {
+	t.buf.WriteString("\n")
+	t.comment("", settingDocs[name])
+	if t.format == FormatTOML {
+		fmt.Fprintf(&t.buf, "[%s]\n", name)
+	} else {
+		fmt.Fprintf(&t.buf, "%s:\n", name)
+	}
+}
+
+/// Writes every element of a list of structs as a toml [[name]] table
+func (t *templateWriter) tableList(name string, v reflect.Value) {
+	t.buf.WriteString("\n")
+	t.comment("", settingDocs[name])
+	for i := 0; i < v.Len(); i++ {
+		fmt.Fprintf(&t.buf, "[[%s]]\n", name)
+		elem := v.Index(i)
+		for j := 0; j < elem.NumField(); j++ {
+			val, err := encodeValue(elem.Field(j))
+			if err != nil {
+				t.err = err
+				return
+			}
+			fmt.Fprintf(&t.buf, "%s = %s\n", elem.Type().Field(j).Name, val)
+		}
+	}
+}
+
+/// Json scalars and lists of strings are valid yaml and toml, so values are
+/// written json encoded
+func encodeValue(v reflect.Value) (string, error) {
+	var val bytes.Buffer
+	enc := json.NewEncoder(&val)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v.Interface())
+	return strings.TrimSpace(val.String()), err
+}
+
+/// Writes a setting as `name: value` or `name = value`
+func (t *templateWriter) setting(group string, name string, v reflect.Value) {
+	val, err := encodeValue(v)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	path := name
+	if group != "" {
+		path = group + "." + name
+	}
+	t.buf.WriteString("\n")
+	t.comment(group, settingDocs[path])
+
+	sep := ": "
+	if t.format == FormatTOML {
+		sep = " = "
+	}
+	fmt.Fprintf(&t.buf, "%s%s%s%s\n", t.indent(group), name, sep, val)
+}
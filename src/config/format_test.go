@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+/// Tests that every setting written to config files is described
+func TestSettingDocs(t *testing.T) {
+	v := reflect.TypeOf(Config{})
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !isFileSetting(f) {
+			continue
+		}
+		if settingDocs[f.Name] == "" {
+			t.Fatalf("No description of %s", f.Name)
+		}
+		if f.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < f.Type.NumField(); j++ {
+			if settingDocs[f.Name+"."+f.Type.Field(j).Name] == "" {
+				t.Fatalf("No description of %s.%s", f.Name, f.Type.Field(j).Name)
+			}
+		}
+	}
+}
+
+/// Tests writing a config in every format and reading it back
+func TestFormatRoundTrip(t *testing.T) {
+	want := Default()
+	want.Port = 9000
+	want.BasicAuth.Enabled = true
+	want.BasicAuth.Locations = []string{"/dav/", "/git/"}
+	want.LoginPage.Banner = `Authorized "use" only <b>`
+	want.Rules = []AccessRule{
+		{Networks: []string{"10.0.0.0/8"}, Hosts: []string{}, Paths: []string{"/public/"}, Action: ActionAllow},
+		{Networks: []string{}, Hosts: []string{"*.example.com"}, Paths: []string{}, Action: ActionDeny},
+	}
+	want.Webhooks = []WebhookTarget{
+		{URL: "https://chat.example.com/hooks/1", Events: []string{"lockout"}, Secret: "s3cret", Template: `{"text": {{json .User}}}`},
+	}
+
+	for _, name := range []string{"better_auth.conf", "better_auth.yaml", "better_auth.yml", "better_auth.toml"} {
+		f := path.Join(t.TempDir(), name)
+		var buf bytes.Buffer
+		err := writeConfig(&buf, want, fileFormat(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(f, buf.Bytes(), 0644)
+
+		got := Default()
+		err = parseFileOver(got, f)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err, buf.String())
+		}
+		if len(got.loadProblems) > 0 {
+			t.Fatalf("%s: unexpected problems %v", name, got.loadProblems)
+		}
+		got.sources = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: read %+v, expected %+v", name, got, want)
+		}
+	}
+}
+
+/// Tests that keys match settings regardless of case in every format and
+/// unknown keys are reported
+func TestFormatKeys(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := path.Join(dir, "better_auth.yaml")
+	os.WriteFile(yamlFile, []byte("port: 9000\nlockout:\n  maxattempts: 3\n  tries: 4\n"), 0644)
+	tomlFile := path.Join(dir, "better_auth.toml")
+	os.WriteFile(tomlFile, []byte("port = 9000\n[lockout]\nmaxattempts = 3\ntries = 4\n"), 0644)
+
+	for _, f := range []string{yamlFile, tomlFile} {
+		c := tempConfig(t)
+		err := parseFileOver(c, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Port != 9000 || c.Lockout.MaxAttempts != 3 || c.Source("Lockout.MaxAttempts") != SourceFile {
+			t.Fatalf("%s: unexpected config %+v", f, c)
+		}
+		if findProblem(c.Validate(), "Lockout.tries") == nil {
+			t.Fatalf("%s: unknown key not reported", f)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	src := path.Join(dir, "better_auth.conf")
+	os.WriteFile(src, []byte(`{"Port": 9000, "BasicAuth": {"Locations": ["/dav/"]}}`), 0644)
+	dest := path.Join(dir, "better_auth.yaml")
+
+	err := Migrate(src, dest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Default()
+	err = parseFileOver(c, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 9000 || !reflect.DeepEqual(c.BasicAuth.Locations, []string{"/dav/"}) {
+		t.Fatalf("Settings lost in migration: %+v", c)
+	}
+
+	if Migrate(src, dest, false) == nil {
+		t.Fatal("Existing file overwritten")
+	}
+	if Migrate(src, dest, true) != nil {
+		t.Fatal("Existing file not overwritten with force")
+	}
+
+	os.WriteFile(src, []byte(`{"Port": 9000, "Prot": 80}`), 0644)
+	if Migrate(src, path.Join(dir, "better_auth.toml"), false) == nil {
+		t.Fatal("Unknown setting silently dropped")
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"better_auth/geoip"
+)
+
+/// What happens to a login GeoIP flags as unusual. GeoIPSecondFactor is
+/// reserved for asking for a second factor, which better_auth does not have
+/// yet, so it is refused by validation
+const (
+	GeoIPLog          = "log"
+	GeoIPBlock        = "block"
+	GeoIPSecondFactor = "second_factor"
+)
+
+/// GeoIPConfig looks up where logins come from in local MaxMind databases and
+/// flags logins that are unusual for the user.
+///  Databases are .mmdb files, eg GeoLite2-City and GeoLite2-ASN, empty disables GeoIP.
+///  MaxSpeed is in km/h, logins further apart than this allows are impossible travel.
+///  Action is log to only audit flagged logins, or block to refuse them as well.
+type GeoIPConfig struct {
+	Databases        []string
+	NewCountry       bool
+	ImpossibleTravel bool
+	MaxSpeed         int
+	Action           string
+}
+
+func (c *Config) validateGeoIP(v *validator) {
+	for _, db := range c.GeoIP.Databases {
+		err := checkReadable(db)
+		if err == nil {
+			_, err = geoip.Open([]string{db})
+		}
+		v.checkErr("GeoIP.Databases", err)
+	}
+	switch c.GeoIP.Action {
+	case GeoIPLog, GeoIPBlock:
+	case GeoIPSecondFactor:
+		v.check("GeoIP.Action", false, "`%s` needs a second factor, which better_auth does not support yet. Use `%s` or `%s`",
+			GeoIPSecondFactor, GeoIPLog, GeoIPBlock)
+	default:
+		v.check("GeoIP.Action", false, "must be `%s` or `%s`, got `%s`", GeoIPLog, GeoIPBlock, c.GeoIP.Action)
+	}
+	if c.GeoIP.ImpossibleTravel {
+		v.check("GeoIP.MaxSpeed", c.GeoIP.MaxSpeed > 0, "must be greater than 0 while ImpossibleTravel is enabled, got %d", c.GeoIP.MaxSpeed)
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"net/url"
+	"path/filepath"
+)
+
+/// InviteConfig is for invite links, which let new users choose their own
+/// password.
+///  File keeps pending invites, empty keeps them next to PasswdFile.
+///  URL is the address users open the login page at, eg https://example.com,
+///    that links printed by the invite subcommand start with.
+///  Lifetime is in seconds, after which an unused invite stops working.
+type InviteConfig struct {
+	File     string
+	URL      string
+	Lifetime int
+}
+
+/// Returns the file pending invites are kept in
+func (c *Config) InvitesFile() string {
+	if c.Invite.File != "" {
+		return c.Invite.File
+	}
+	return filepath.Join(filepath.Dir(c.PasswdFile), "invites")
+}
+
+func (c *Config) validateInvite(v *validator) {
+	i := c.Invite
+	v.check("Invite.Lifetime", i.Lifetime > 0, "must be greater than 0, got %d", i.Lifetime)
+	if i.URL != "" {
+		u, err := url.Parse(i.URL)
+		v.check("Invite.URL", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"must be the http or https url of the site, eg https://example.com, got `%s`", i.URL)
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+/// Most bytes of a password bcrypt uses, the rest would be ignored
+const MaxPasswordBytes = 72
+
+/// PasswordPolicyConfig are the rules new passwords must follow, whether set
+/// by adduser, a reset link or the user.
+///  MinLength and MaxLength count characters, not bytes. Passwords are never
+///    longer than MaxPasswordBytes either.
+///  DisallowUsername refuses passwords containing the username.
+///  MinEntropy is the least bits of entropy estimated from the character
+///    classes used and the length, not counting repeated or sequential
+///    characters. 0 skips the estimate.
+///  BreachedFile is a Have I Been Pwned SHA-1 download: either one file
+///    sorted by hash with HASH:COUNT lines, or a directory of files named after
+///    the first 5 characters of the hash with SUFFIX:COUNT lines.
+///  History is how many of the user's latest passwords, including the current
+///    one, cannot be reused. Older password hashes are kept in PasswdFile.
+///  MaxAge is the number of days after which a password expires and must be
+///    changed at the next login, 0 never expires passwords.
+type PasswordPolicyConfig struct {
+	MinLength        int
+	MaxLength        int
+	RequireUpper     bool
+	RequireLower     bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	DisallowUsername bool
+	MinEntropy       int
+	BreachedFile     string
+	History          int
+	MaxAge           int
+}
+
+func (c *Config) validatePasswordPolicy(v *validator) {
+	p := c.PasswordPolicy
+	v.check("PasswordPolicy.MinLength", p.MinLength >= 1, "must be at least 1, got %d", p.MinLength)
+	v.check("PasswordPolicy.MaxLength", p.MaxLength >= p.MinLength && p.MaxLength <= MaxPasswordBytes,
+		"must be between MinLength and %d, got %d", MaxPasswordBytes, p.MaxLength)
+	v.check("PasswordPolicy.MinEntropy", p.MinEntropy >= 0, "must not be negative, got %d", p.MinEntropy)
+	v.check("PasswordPolicy.History", p.History >= 0 && p.History <= 24, "must be between 0 and 24, got %d", p.History)
+	v.check("PasswordPolicy.MaxAge", p.MaxAge >= 0, "must not be negative, got %d", p.MaxAge)
+	if p.BreachedFile != "" {
+		v.checkErr("PasswordPolicy.BreachedFile", checkReadable(p.BreachedFile))
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"text/tabwriter"
+)
+
+/// Shown in place of secret values
+const redacted = "<redacted>"
+
+/// Returns whether the value of s must not be printed. Settings tagged
+/// `secret:"true"` are always secret, as is anything read through a _FILE
+/// environment variable since that is how secrets are usually mounted
+func (c *Config) isSecret(s setting) bool {
+	return s.Field.Tag.Get("secret") == "true" || c.Source(s.Path) == SourceEnvFile
+}
+
+/// Writes every effective setting with its value, where the value came from
+/// and the environment variable that sets it. Secrets are redacted
+func (c *Config) Show(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE\tENV")
+	for _, s := range fieldValues(c) {
+		val := redacted
+		if !c.isSecret(s) || s.Value.IsZero() {
+			data, err := json.Marshal(redactList(s.Value).Interface())
+			if err != nil {
+				return err
+			}
+			val = string(data)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Path, val, c.Source(s.Path), EnvName(s.Path))
+	}
+	return tw.Flush()
+}
+
+/// Returns a copy of a list of structs, eg Webhooks, with the secret fields
+/// of every element redacted. Anything else is returned as it is
+func redactList(v reflect.Value) reflect.Value {
+	if !isStructList(v.Type()) {
+		return v
+	}
+	list := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(list, v)
+	for i := 0; i < list.Len(); i++ {
+		elem := list.Index(i)
+		for j := 0; j < elem.NumField(); j++ {
+			f := elem.Field(j)
+			if elem.Type().Field(j).Tag.Get("secret") == "true" && !f.IsZero() && f.Kind() == reflect.String {
+				f.SetString(redacted)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"net/mail"
+	"net/url"
+)
+
+/// SMTP connection security
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+/// SMTPConfig is the mail server better_auth sends email through.
+///  Host is empty unless email is used.
+///  Security is starttls to upgrade the connection, tls for implicit TLS (usually
+///    port 465) or none for a local relay.
+///  Username and Password are only sent if Username is set.
+///  From is the sender, eg `better_auth <noreply@example.com>`.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Security string
+	Username string
+	Password string `secret:"true"`
+	From     string
+}
+
+/// PasswordResetConfig lets users who forgot their password email themselves
+/// a link to choose a new one. Users need an email address in PasswdFile.
+///  URL is the address users open the login page at, eg https://example.com.
+///    Links are built from it rather than the request so they cannot be made
+///    to point elsewhere.
+///  Lifetime is in seconds, after which an unused link stops working.
+///  Notify emails users whenever their password is changed.
+type PasswordResetConfig struct {
+	Enabled  bool
+	URL      string
+	Lifetime int
+	Notify   bool
+}
+
+func (c *Config) validateSMTP(v *validator) {
+	s := c.SMTP
+	v.check("SMTP.Port", s.Port >= 1 && s.Port <= 65535, "must be between 1 and 65535, got %d", s.Port)
+	v.check("SMTP.Security", s.Security == SMTPStartTLS || s.Security == SMTPTLS || s.Security == SMTPNone,
+		"must be `%s`, `%s` or `%s`, got `%s`", SMTPStartTLS, SMTPTLS, SMTPNone, s.Security)
+	_, err := mail.ParseAddress(s.From)
+	v.checkErr("SMTP.From", err)
+}
+
+func (c *Config) validatePasswordReset(v *validator) {
+	r := c.PasswordReset
+	v.check("PasswordReset.Enabled", c.SMTP.Host != "", "requires SMTP.Host")
+	u, err := url.Parse(r.URL)
+	v.check("PasswordReset.URL", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"must be the http or https url of the site, eg https://example.com, got `%s`", r.URL)
+	v.check("PasswordReset.Lifetime", r.Lifetime > 0, "must be greater than 0, got %d", r.Lifetime)
+}
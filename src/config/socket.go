@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+/// Prefix of an Address that is a unix socket path rather than an ip address
+const UnixPrefix = "unix:"
+
+/// SocketConfig sets the ownership and permissions of the socket created when
+/// Address is a unix socket, eg `unix:/run/better_auth/better_auth.sock`.
+///  Owner and Group are names or numeric ids, empty keeps the process's own.
+///  Mode is an octal permission, eg 0660 to let members of Group connect.
+type SocketConfig struct {
+	Owner string
+	Group string
+	Mode  string
+}
+
+/// Returns the socket path and true if Address is a unix socket
+func (c *Config) SocketPath() (string, bool) {
+	if !strings.HasPrefix(c.Address, UnixPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(c.Address, UnixPrefix), true
+}
+
+/// Parses Mode
+func (s SocketConfig) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("`%s` is not an octal file mode such as 0660", s.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+/// Resolves Owner and Group to ids for os.Chown, -1 when empty
+func (s SocketConfig) IDs() (int, int, error) {
+	uid, gid := -1, -1
+	var err error
+	if s.Owner != "" {
+		uid, err = lookupID(s.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+	if s.Group != "" {
+		gid, err = lookupID(s.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+	return uid, gid, nil
+}
+
+/// Returns name as a number if it is one, otherwise looks it up
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/// Source is where a config value came from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceEnvFile Source = "env_file"
+	SourceFlag    Source = "flag"
+)
+
+/// Returns where the value of field, eg "Port" or "Lockout.MaxAttempts", came from
+func (c *Config) Source(field string) Source {
+	src, exists := c.sources[field]
+	if !exists {
+		return SourceDefault
+	}
+	return src
+}
+
+func (c *Config) setSource(field string, src Source) {
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	c.sources[field] = src
+}
+
+/// setting is a single config value and the struct field holding it
+type setting struct {
+	Path  string
+	Field reflect.StructField
+	Value reflect.Value
+}
+
+/// Returns every setting in c in declaration order, with dotted paths for
+/// nested groups. Subcommands and unexported fields are not settings
+func fieldValues(c *Config) []setting {
+	return walkFields(reflect.ValueOf(c).Elem(), "", nil)
+}
+
+func walkFields(v reflect.Value, prefix string, settings []setting) []setting {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || strings.HasPrefix(f.Tag.Get("arg"), "subcommand") {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			settings = walkFields(v.Field(i), prefix+f.Name+".", settings)
+			continue
+		}
+		settings = append(settings, setting{Path: prefix + f.Name, Field: f, Value: v.Field(i)})
+	}
+	return settings
+}
+
+/// Returns the settings whose values differ between a and b, in declaration order
+func Diff(a *Config, b *Config) []string {
+	before := snapshot(a)
+	changed := []string{}
+	for _, s := range fieldValues(b) {
+		if !reflect.DeepEqual(before[s.Path], s.Value.Interface()) {
+			changed = append(changed, s.Path)
+		}
+	}
+	return changed
+}
+
+/// Returns a copy of every setting's current value
+func snapshot(c *Config) map[string]any {
+	snap := make(map[string]any)
+	for _, s := range fieldValues(c) {
+		v := s.Value
+		if v.Kind() == reflect.Slice && !v.IsNil() {
+			cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			reflect.Copy(cp, v)
+			v = cp
+		}
+		snap[s.Path] = v.Interface()
+	}
+	return snap
+}
+
+/// Marks every setting that differs from before as coming from src
+func (c *Config) recordChanges(before map[string]any, src Source) {
+	for _, s := range fieldValues(c) {
+		if !reflect.DeepEqual(before[s.Path], s.Value.Interface()) {
+			c.setSource(s.Path, src)
+		}
+	}
+}
+
+/// Marks every setting present in a json config file as coming from the file
+/// and records keys that do not match any setting
+func (c *Config) recordJsonKeys(data []byte) error {
+	raw := make(map[string]json.RawMessage)
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	c.recordJsonObject(raw, reflect.TypeOf(*c), "", &unknown)
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		c.loadProblem(k, SourceFile, "unknown setting")
+	}
+	return nil
+}
+
+func (c *Config) recordJsonObject(raw map[string]json.RawMessage, t reflect.Type, prefix string, unknown *[]string) {
+	for key, val := range raw {
+		f, found := jsonField(t, key)
+		if !found {
+			*unknown = append(*unknown, prefix+key)
+			continue
+		}
+
+		if f.Type.Kind() == reflect.Struct {
+			nested := make(map[string]json.RawMessage)
+			if json.Unmarshal(val, &nested) == nil {
+				c.recordJsonObject(nested, f.Type, prefix+f.Name+".", unknown)
+				continue
+			}
+		}
+
+		// check the keys of objects in lists, eg Rules, for typos too
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+			var list []map[string]json.RawMessage
+			if json.Unmarshal(val, &list) == nil {
+				for i, obj := range list {
+					c.recordJsonObject(obj, f.Type.Elem(), fmt.Sprintf("%s%s[%d].", prefix, f.Name, i), unknown)
+				}
+			}
+		}
+		c.setSource(prefix+f.Name, SourceFile)
+	}
+}
+
+/// Finds the field of t that encoding/json would decode key into
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("json") == "-" {
+			continue
+		}
+		if strings.EqualFold(f.Name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+/// Records a problem found while loading the config, reported by Validate
+func (c *Config) loadProblem(field string, src Source, format string, a ...any) {
+	c.loadProblems = append(c.loadProblems, Problem{Field: field, Source: src, Message: fmt.Sprintf(format, a...)})
+}
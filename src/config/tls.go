@@ -0,0 +1,84 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+/// TLSConfig enables https when CertFile and KeyFile are set, for when nginx
+/// and better_auth are on different hosts. Files are read again when the
+/// config is reloaded, eg after certbot renews the certificate.
+///  MinVersion is the lowest TLS version accepted, 1.2 or 1.3.
+///  ClientCAFile requires clients (nginx) to present a certificate signed by
+///    one of the CAs in this pem file before any request is handled.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   string
+	ClientCAFile string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+/// Returns whether the server listens with TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+/// Loads the certificate, key and client CAs into a tls.Config for the
+/// server. Returns nil if TLS is not enabled
+func (t TLSConfig) Load() (*tls.Config, error) {
+	if !t.Enabled() {
+		return nil, nil
+	}
+
+	version, exists := tlsVersions[t.MinVersion]
+	if !exists {
+		return nil, fmt.Errorf("unknown TLS version `%s`, must be 1.2 or 1.3", t.MinVersion)
+	}
+
+	cert, err := t.loadCert()
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+	}
+
+	if t.ClientCAFile != "" {
+		pool, err := t.loadClientCAs()
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+func (t TLSConfig) loadCert() (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return cert, fmt.Errorf("unable to load certificate: %s", err)
+	}
+	return cert, nil
+}
+
+func (t TLSConfig) loadClientCAs() (*x509.CertPool, error) {
+	pem, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in `%s`", t.ClientCAFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"better_auth/clientip"
+	"better_auth/logging"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/// Problem is a single invalid setting
+type Problem struct {
+	Field   string
+	Source  Source
+	Message string
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("%s (%s): %s", p.Field, p.Source, p.Message)
+}
+
+type validator struct {
+	conf     *Config
+	problems []Problem
+}
+
+/// Records a problem with field unless ok
+func (v *validator) check(field string, ok bool, format string, a ...any) {
+	if ok {
+		return
+	}
+	v.problems = append(v.problems, Problem{
+		Field:   field,
+		Source:  v.conf.Source(field),
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+/// Records a problem with field if err is not nil
+func (v *validator) checkErr(field string, err error) {
+	if err != nil {
+		v.check(field, false, "%s", err)
+	}
+}
+
+/// Checks every setting and returns all problems found. Paths are checked
+/// with the permissions of the current user
+func (c *Config) Validate() []Problem {
+	v := &validator{conf: c}
+	v.problems = append(v.problems, c.loadProblems...)
+
+	v.check("Address", c.Address != "", "may not be empty")
+	if sock, isSocket := c.SocketPath(); isSocket {
+		c.validateSocket(v, sock)
+	} else {
+		v.check("Port", c.Port >= 1 && c.Port <= 65535, "must be between 1 and 65535, got %d", c.Port)
+	}
+	v.check("SessionTimeout", c.SessionTimeout > 0, "must be greater than 0, got %d", c.SessionTimeout)
+
+	if c.PasswdFile == "" {
+		v.check("PasswdFile", false, "may not be empty")
+	} else {
+		v.checkErr("PasswdFile", checkReadableOrCreatable(c.PasswdFile))
+	}
+
+	_, err := logging.ParseLevel(c.LogLevel)
+	v.checkErr("LogLevel", err)
+	v.check("LogFormat", c.LogFormat == logging.FormatText || c.LogFormat == logging.FormatJSON,
+		"must be `%s` or `%s`, got `%s`", logging.FormatText, logging.FormatJSON, c.LogFormat)
+
+	switch c.LogOutput {
+	case logging.OutputFile:
+		v.checkErr("LogDir", checkWritableDir(c.LogDir))
+		v.check("LogSize", c.LogSize > 0, "must be greater than 0, got %d", c.LogSize)
+		v.check("LogBackups", c.LogBackups >= 0, "may not be negative, got %d", c.LogBackups)
+	case logging.OutputSyslog:
+		c.validateSyslog(v)
+	case logging.OutputStdout, logging.OutputJournald:
+	default:
+		v.check("LogOutput", false, "must be one of `%s`, `%s`, `%s` or `%s`, got `%s`",
+			logging.OutputFile, logging.OutputStdout, logging.OutputJournald, logging.OutputSyslog, c.LogOutput)
+	}
+
+	if c.AuditFile != "" {
+		v.checkErr("AuditFile", checkWritableFile(c.AuditFile))
+	}
+
+	for _, p := range c.TrustedProxies {
+		_, err := clientip.ParseCIDR(p)
+		v.checkErr("TrustedProxies", err)
+	}
+
+	for i, rule := range c.Rules {
+		c.validateRule(v, i, rule)
+	}
+	for i, hook := range c.Webhooks {
+		c.validateWebhook(v, i, hook)
+	}
+	c.validateWebhookDelivery(v)
+
+	v.check("Lockout.MaxAttempts", c.Lockout.MaxAttempts >= 0, "may not be negative, got %d", c.Lockout.MaxAttempts)
+	if c.Lockout.MaxAttempts > 0 {
+		v.check("Lockout.Window", c.Lockout.Window > 0, "must be greater than 0 while lockouts are enabled, got %d", c.Lockout.Window)
+		v.check("Lockout.Duration", c.Lockout.Duration > 0, "must be greater than 0 while lockouts are enabled, got %d", c.Lockout.Duration)
+	}
+
+	v.check("BasicAuth.CacheTTL", c.BasicAuth.CacheTTL >= 0, "may not be negative, got %d", c.BasicAuth.CacheTTL)
+	v.check("BasicAuth.Realm", !strings.ContainsAny(c.BasicAuth.Realm, "\"\\\r\n"), "may not contain quotes, backslashes or line breaks")
+	for _, loc := range c.BasicAuth.Locations {
+		v.check("BasicAuth.Locations", strings.HasPrefix(loc, "/"), "`%s` must start with /", loc)
+	}
+
+	if c.LoginPage.CustomCSS != "" {
+		v.checkErr("LoginPage.CustomCSS", checkReadable(c.LoginPage.CustomCSS))
+	}
+	if c.LoginPage.TemplateDir != "" {
+		v.checkErr("LoginPage.TemplateDir", checkDir(c.LoginPage.TemplateDir))
+	}
+	if c.Locale.CatalogDir != "" {
+		v.checkErr("Locale.CatalogDir", checkDir(c.Locale.CatalogDir))
+	}
+
+	if c.TLS.Enabled() {
+		c.validateTLS(v)
+	} else {
+		v.check("TLS.ClientCAFile", c.TLS.ClientCAFile == "", "requires TLS.CertFile and TLS.KeyFile")
+	}
+
+	c.validatePasswordPolicy(v)
+	c.validateGeoIP(v)
+	c.validateInvite(v)
+
+	if c.SMTP.Host != "" {
+		c.validateSMTP(v)
+	}
+	if c.PasswordReset.Enabled {
+		c.validatePasswordReset(v)
+	}
+	if c.Admin.Enabled {
+		c.validateAdmin(v)
+	}
+
+	return v.problems
+}
+
+/// Whether a user management subcommand (adduser, setemail, mustchange,
+/// setgroups or invite) was given
+func (c *Config) ManagementCommand() bool {
+	return c.AddUser != nil || c.SetEmail != nil || c.MustChange != nil || c.SetGroups != nil || c.InviteCmd != nil
+}
+
+/// Checks only the settings the user management subcommands use, so a
+/// server setting they never touch, eg an unwritable LogDir, does not stop
+/// them
+func (c *Config) ValidateManagement() []Problem {
+	v := &validator{conf: c}
+	v.problems = append(v.problems, c.loadProblems...)
+
+	if c.PasswdFile == "" {
+		v.check("PasswdFile", false, "may not be empty")
+	} else {
+		v.checkErr("PasswdFile", checkReadableOrCreatable(c.PasswdFile))
+	}
+	c.validatePasswordPolicy(v)
+	if c.InviteCmd != nil {
+		c.validateInvite(v)
+	}
+	return v.problems
+}
+
+func (c *Config) validateTLS(v *validator) {
+	v.check("TLS.CertFile", c.TLS.CertFile != "", "must be set with TLS.KeyFile")
+	v.check("TLS.KeyFile", c.TLS.KeyFile != "", "must be set with TLS.CertFile")
+	_, known := tlsVersions[c.TLS.MinVersion]
+	v.check("TLS.MinVersion", known, "must be `1.2` or `1.3`, got `%s`", c.TLS.MinVersion)
+	if c.TLS.CertFile != "" && c.TLS.KeyFile != "" {
+		_, err := c.TLS.loadCert()
+		v.checkErr("TLS.CertFile", err)
+	}
+	if c.TLS.ClientCAFile != "" {
+		_, err := c.TLS.loadClientCAs()
+		v.checkErr("TLS.ClientCAFile", err)
+	}
+}
+
+/// Problems with a rule are reported for Rules, where sources are recorded,
+/// with the rule's position in the message
+func (c *Config) validateRule(v *validator, i int, rule AccessRule) {
+	switch rule.Action {
+	case ActionAllow, ActionAuth, ActionDeny:
+	default:
+		v.check("Rules", false, "rule %d: Action must be `%s`, `%s` or `%s`, got `%s`", i+1, ActionAllow, ActionAuth, ActionDeny, rule.Action)
+	}
+	for _, n := range rule.Networks {
+		_, err := clientip.ParseCIDR(n)
+		if err != nil {
+			v.check("Rules", false, "rule %d: %s", i+1, err)
+		}
+	}
+	for _, h := range rule.Hosts {
+		name := strings.TrimPrefix(h, "*.")
+		v.check("Rules", name != "" && !strings.ContainsAny(name, "*/: "), "rule %d: `%s` is not a host name or *.domain", i+1, h)
+	}
+	for _, p := range rule.Paths {
+		v.check("Rules", strings.HasPrefix(p, "/"), "rule %d: path `%s` must start with /", i+1, p)
+	}
+}
+
+func (c *Config) validateSocket(v *validator, sock string) {
+	if !filepath.IsAbs(sock) {
+		v.check("Address", false, "socket path `%s` must be absolute", sock)
+	} else {
+		v.checkErr("Address", checkWritableDir(filepath.Dir(sock)))
+	}
+
+	_, err := c.Socket.FileMode()
+	v.checkErr("Socket.Mode", err)
+	if c.Socket.Owner != "" {
+		_, _, err = SocketConfig{Owner: c.Socket.Owner}.IDs()
+		v.checkErr("Socket.Owner", err)
+	}
+	if c.Socket.Group != "" {
+		_, _, err = SocketConfig{Group: c.Socket.Group}.IDs()
+		v.checkErr("Socket.Group", err)
+	}
+}
+
+func (c *Config) validateSyslog(v *validator) {
+	switch c.Syslog.Network {
+	case "unixgram", "udp", "tcp":
+	default:
+		v.check("Syslog.Network", false, "must be `unixgram`, `udp` or `tcp`, got `%s`", c.Syslog.Network)
+	}
+	v.check("Syslog.Address", c.Syslog.Address != "", "may not be empty")
+
+	_, err := logging.ParseFacility(c.Syslog.Facility)
+	v.checkErr("Syslog.Facility", err)
+	_, err = logging.ParseFacility(c.Syslog.AuditFacility)
+	v.checkErr("Syslog.AuditFacility", err)
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func checkDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("`%s` is not a directory", path)
+	}
+	return nil
+}
+
+/// Checks that path can be read, or created if it does not exist yet
+func checkReadableOrCreatable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return checkReadable(path)
+	}
+	return checkWritableDir(filepath.Dir(path))
+}
+
+/// Checks that path can be appended to, or created if it does not exist yet
+func checkWritableFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return checkWritableDir(filepath.Dir(path))
+}
+
+/// Checks that files can be created in dir. If dir does not exist yet, its
+/// nearest existing parent must be writable so dir can be created
+func checkWritableDir(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("`%s` is not a directory", existing)
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return err
+		}
+		existing = parent
+	}
+
+	f, err := os.CreateTemp(existing, ".better_auth_check")
+	if err != nil {
+		return fmt.Errorf("`%s` is not writable: %s", existing, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
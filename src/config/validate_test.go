@@ -0,0 +1,406 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+/// Returns a default config with every path inside a temporary dir
+func tempConfig(t *testing.T) *Config {
+	dir := t.TempDir()
+	c := Default()
+	c.ConfigFile = path.Join(dir, "better_auth.conf")
+	c.PasswdFile = path.Join(dir, "better_auth.pw")
+	c.LogDir = path.Join(dir, "logs")
+	c.AuditFile = path.Join(dir, "logs", "audit.log")
+	c.WebhookDelivery.DeadLetterFile = path.Join(dir, "logs", "webhook_dead_letters.log")
+	return c
+}
+
+func findProblem(problems []Problem, field string) *Problem {
+	for _, p := range problems {
+		if p.Field == field {
+			return &p
+		}
+	}
+	return nil
+}
+
+func TestValidateDefault(t *testing.T) {
+	c := tempConfig(t)
+	problems := c.Validate()
+	if len(problems) != 0 {
+		t.Fatalf("Default config has problems: %v", problems)
+	}
+}
+
+/// Tests that every problem is reported, not just the first
+func TestValidateProblems(t *testing.T) {
+	c := tempConfig(t)
+	c.Port = 70000
+	c.SessionTimeout = -1
+	c.LogLevel = "loud"
+	c.LogOutput = "carrier pigeon"
+	c.Lockout.MaxAttempts = 3
+	c.Lockout.Window = 0
+	c.BasicAuth.Realm = `say "hi"`
+	c.BasicAuth.Locations = []string{"dav/"}
+	c.LoginPage.CustomCSS = path.Join(t.TempDir(), "missing.css")
+
+	problems := c.Validate()
+	for _, field := range []string{
+		"Port",
+		"SessionTimeout",
+		"LogLevel",
+		"LogOutput",
+		"Lockout.Window",
+		"BasicAuth.Realm",
+		"BasicAuth.Locations",
+		"LoginPage.CustomCSS",
+	} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+	if len(problems) != 8 {
+		t.Fatalf("Unexpected problems %v", problems)
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	c := tempConfig(t)
+	notADir := path.Join(t.TempDir(), "file")
+	os.WriteFile(notADir, []byte(""), 0644)
+
+	c.LogDir = path.Join(notADir, "logs")
+	c.PasswdFile = path.Join(notADir, "better_auth.pw")
+	c.LoginPage.TemplateDir = notADir
+
+	problems := c.Validate()
+	for _, field := range []string{"LogDir", "PasswdFile", "LoginPage.TemplateDir"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+}
+
+/// Tests that the management subcommands only need the settings they use
+func TestValidateManagement(t *testing.T) {
+	c := tempConfig(t)
+	notADir := path.Join(t.TempDir(), "file")
+	os.WriteFile(notADir, []byte(""), 0644)
+
+	c.AddUser = &adduserCmd{}
+	c.LogDir = path.Join(notADir, "logs")
+	c.AuditFile = path.Join(notADir, "audit.log")
+	c.WebhookDelivery.DeadLetterFile = path.Join(notADir, "dead_letters.log")
+	c.Port = 0
+	c.Invite.Lifetime = 0
+	if !c.ManagementCommand() {
+		t.Fatal("adduser is not a management command")
+	}
+	if problems := c.ValidateManagement(); len(problems) != 0 {
+		t.Fatalf("Unused settings have problems: %v", problems)
+	}
+
+	c.PasswdFile = path.Join(notADir, "better_auth.pw")
+	c.PasswordPolicy.MinLength = 0
+	problems := c.ValidateManagement()
+	for _, field := range []string{"PasswdFile", "PasswordPolicy.MinLength"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+	if findProblem(problems, "Invite.Lifetime") != nil {
+		t.Fatalf("Invite checked for adduser: %v", problems)
+	}
+
+	c.AddUser = nil
+	c.InviteCmd = &inviteCmd{}
+	if findProblem(c.ValidateManagement(), "Invite.Lifetime") == nil {
+		t.Fatal("Invite not checked for invite")
+	}
+}
+
+func TestValidateSyslog(t *testing.T) {
+	c := tempConfig(t)
+	c.LogOutput = "syslog"
+	c.Syslog.Network = "smoke signals"
+	c.Syslog.AuditFacility = "local9"
+
+	problems := c.Validate()
+	for _, field := range []string{"Syslog.Network", "Syslog.AuditFacility"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+}
+
+/// Tests tracking where each setting came from and rejecting unknown keys
+func TestSources(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.conf")
+	os.WriteFile(f, []byte(`{
+		"port": 70000,
+		"Lockout": {"MaxAttempts": 3, "Tries": 4},
+		"ServerAddress": "localhost"
+	}`), 0644)
+
+	os.Args = []string{os.Args[0], "--config", f, "--address", ""}
+	c, err := Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for field, want := range map[string]Source{
+		"Port":                SourceFile,
+		"Lockout.MaxAttempts": SourceFile,
+		"Lockout.Window":      SourceDefault,
+		"Address":             SourceFlag,
+		"SessionTimeout":      SourceDefault,
+	} {
+		if c.Source(field) != want {
+			t.Fatalf("%s from %s, expected %s", field, c.Source(field), want)
+		}
+	}
+
+	problems := c.Validate()
+	for field, want := range map[string]Source{
+		"Port":          SourceFile,
+		"Address":       SourceFlag,
+		"ServerAddress": SourceFile,
+		"Lockout.Tries": SourceFile,
+	} {
+		p := findProblem(problems, field)
+		if p == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+		if p.Source != want {
+			t.Fatalf("Problem with %s reported from %s, expected %s", field, p.Source, want)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	c := tempConfig(t)
+	c.TLS.ClientCAFile = path.Join(t.TempDir(), "ca.pem")
+	if findProblem(c.Validate(), "TLS.ClientCAFile") == nil {
+		t.Fatal("Client CA accepted without TLS")
+	}
+
+	c = tempConfig(t)
+	c.TLS.CertFile = path.Join(t.TempDir(), "missing.pem")
+	c.TLS.MinVersion = "1.0"
+	problems := c.Validate()
+	for _, field := range []string{"TLS.KeyFile", "TLS.MinVersion"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+
+	c.TLS.KeyFile = path.Join(t.TempDir(), "missing.key")
+	c.TLS.MinVersion = "1.3"
+	if findProblem(c.Validate(), "TLS.CertFile") == nil {
+		t.Fatal("Missing certificate accepted")
+	}
+}
+
+func TestValidateSocket(t *testing.T) {
+	c := tempConfig(t)
+	c.Address = UnixPrefix + "relative.sock"
+	c.Port = 0
+	c.Socket = SocketConfig{Owner: "no such user 1", Group: "no such group 1", Mode: "rw-rw----"}
+
+	problems := c.Validate()
+	for _, field := range []string{"Address", "Socket.Owner", "Socket.Group", "Socket.Mode"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+	if findProblem(problems, "Port") != nil {
+		t.Fatal("Port checked for a unix socket")
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	c := tempConfig(t)
+	c.Rules = []AccessRule{
+		{Networks: []string{"10.0.0.0/8", "192.168.1.1"}, Action: ActionAllow},
+		{Paths: []string{"/admin/"}, Action: ActionDeny},
+	}
+	if problems := c.Validate(); len(problems) != 0 {
+		t.Fatalf("Valid rules have problems: %v", problems)
+	}
+
+	for _, rule := range []AccessRule{
+		{Networks: []string{"10.0.0.0/33"}, Action: ActionAllow},
+		{Paths: []string{"admin/"}, Action: ActionDeny},
+		{Hosts: []string{"*"}, Action: ActionDeny},
+		{Action: "block"},
+	} {
+		c.Rules = []AccessRule{rule}
+		if findProblem(c.Validate(), "Rules") == nil {
+			t.Fatalf("No problem reported for %+v", rule)
+		}
+	}
+}
+
+func TestValidateGeoIP(t *testing.T) {
+	c := tempConfig(t)
+	notDB := path.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	os.WriteFile(notDB, []byte("not a database"), 0644)
+	c.GeoIP.Databases = []string{notDB, path.Join(t.TempDir(), "missing.mmdb")}
+	c.GeoIP.MaxSpeed = 0
+	c.GeoIP.Action = "mfa"
+
+	problems := c.Validate()
+	for _, field := range []string{"GeoIP.Databases", "GeoIP.MaxSpeed", "GeoIP.Action"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+	if len(problems) != 4 {
+		t.Fatalf("Unexpected problems %v", problems)
+	}
+
+	// asking for a second factor is refused until there is one
+	c = tempConfig(t)
+	c.GeoIP.Action = GeoIPSecondFactor
+	p := findProblem(c.Validate(), "GeoIP.Action")
+	if p == nil || !strings.Contains(p.Message, "second factor") {
+		t.Fatalf("Unexpected problem %v for action %s", p, GeoIPSecondFactor)
+	}
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	c := tempConfig(t)
+	c.Webhooks = []WebhookTarget{
+		{URL: "https://chat.example.com/hooks/1", Events: []string{"lockout"}, Template: `{"text": {{json .User}}}`},
+		{URL: "https://chat.example.com/hooks/2", Template: `{"text": {{json (printf "%s: %s from %s" .Event .User .IP)}}}`},
+	}
+	if problems := c.Validate(); len(problems) != 0 {
+		t.Fatalf("Valid webhook has problems: %v", problems)
+	}
+
+	for _, hook := range []WebhookTarget{
+		{URL: "chat.example.com/hooks/1"},
+		{URL: "ftp://chat.example.com/"},
+		{URL: "https://chat.example.com/", Events: []string{"login"}},
+		{URL: "https://chat.example.com/", Template: `{"text": {{json .User}}`},
+		{URL: "https://chat.example.com/", Template: `{{upper .User}}`},
+		{URL: "https://chat.example.com/", Template: `{"text": {{json .Username}}}`},
+		// values are not escaped for json unless they go through json
+		{URL: "https://chat.example.com/", Template: `{"text": "{{.User}}"}`},
+		{URL: "https://chat.example.com/", Template: `{"text": {{printf "%q" .Detail}}}`},
+		{URL: "https://chat.example.com/", Template: `{"text": {{json .Event}}, "ip": "{{.IP}}"}`},
+	} {
+		c.Webhooks = []WebhookTarget{hook}
+		if findProblem(c.Validate(), "Webhooks") == nil {
+			t.Fatalf("No problem reported for %+v", hook)
+		}
+	}
+
+	c.Webhooks = nil
+	c.WebhookDelivery = WebhookDeliveryConfig{QueueSize: 0, Retries: -1, Timeout: 0}
+	problems := c.Validate()
+	for _, field := range []string{"WebhookDelivery.QueueSize", "WebhookDelivery.Retries", "WebhookDelivery.Timeout"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+}
+
+func TestValidatePasswordReset(t *testing.T) {
+	c := tempConfig(t)
+	c.PasswordReset.Enabled = true
+	c.PasswordReset.URL = "example.com"
+	c.PasswordReset.Lifetime = 0
+
+	problems := c.Validate()
+	for _, field := range []string{"PasswordReset.Enabled", "PasswordReset.URL", "PasswordReset.Lifetime"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+
+	c = tempConfig(t)
+	c.SMTP = SMTPConfig{Host: "mail.example.com", Port: 0, Security: "ssl", From: "better_auth"}
+	problems = c.Validate()
+	for _, field := range []string{"SMTP.Port", "SMTP.Security", "SMTP.From"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+
+	c = tempConfig(t)
+	c.SMTP = SMTPConfig{Host: "mail.example.com", Port: 465, Security: SMTPTLS, From: "better_auth <noreply@example.com>"}
+	c.PasswordReset = PasswordResetConfig{Enabled: true, URL: "https://example.com", Lifetime: 900}
+	if problems := c.Validate(); len(problems) != 0 {
+		t.Fatalf("Valid password reset config has problems: %v", problems)
+	}
+}
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	c := tempConfig(t)
+	c.PasswordPolicy = PasswordPolicyConfig{
+		MinLength:    0,
+		MaxLength:    100,
+		MinEntropy:   -1,
+		History:      25,
+		BreachedFile: path.Join(t.TempDir(), "missing.txt"),
+	}
+
+	problems := c.Validate()
+	for _, field := range []string{"PasswordPolicy.MinLength", "PasswordPolicy.MaxLength", "PasswordPolicy.MinEntropy",
+		"PasswordPolicy.History", "PasswordPolicy.BreachedFile"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+
+	c.PasswordPolicy = PasswordPolicyConfig{MinLength: 12, MaxLength: 8}
+	if findProblem(c.Validate(), "PasswordPolicy.MaxLength") == nil {
+		t.Fatal("No problem reported for MaxLength below MinLength")
+	}
+}
+
+func TestValidateAdmin(t *testing.T) {
+	for _, bad := range []AdminConfig{
+		{Enabled: true, Path: "admin", Group: "admin"},
+		{Enabled: true, Path: "/", Group: "admin"},
+		{Enabled: true, Path: "/admin/", Group: "admin"},
+		{Enabled: true, Path: "/login/admin", Group: "admin"},
+		{Enabled: true, Path: "/account", Group: "admin"},
+		{Enabled: true, Path: "/admin", Group: ""},
+		{Enabled: true, Path: "/admin", Group: "ad,min"},
+	} {
+		c := tempConfig(t)
+		c.Admin = bad
+		if len(c.Validate()) == 0 {
+			t.Fatalf("No problem reported for %+v", bad)
+		}
+	}
+
+	c := tempConfig(t)
+	c.Admin = AdminConfig{Enabled: true, Path: "/better_auth/admin", Group: "ops"}
+	if problems := c.Validate(); len(problems) != 0 {
+		t.Fatalf("Valid admin config has problems: %v", problems)
+	}
+}
+
+func TestValidateInvite(t *testing.T) {
+	c := tempConfig(t)
+	c.Invite = InviteConfig{URL: "example.com", Lifetime: 0}
+	problems := c.Validate()
+	for _, field := range []string{"Invite.URL", "Invite.Lifetime"} {
+		if findProblem(problems, field) == nil {
+			t.Fatalf("No problem reported for %s in %v", field, problems)
+		}
+	}
+
+	c = tempConfig(t)
+	if c.InvitesFile() != path.Join(path.Dir(c.PasswdFile), "invites") {
+		t.Fatalf("Unexpected invites file %s", c.InvitesFile())
+	}
+}
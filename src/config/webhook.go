@@ -0,0 +1,102 @@
+package config
+
+import (
+	"better_auth/logging"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"text/template"
+)
+
+/// WebhookTarget receives audit events, eg login_success or lockout, as they
+/// happen.
+///  Events are the audit events sent, empty sends every event.
+///  Secret signs each request with an HMAC-SHA256 X-Better-Auth-Signature header.
+///  Template is a text/template rendering the request body from the event,
+///    empty sends the event's json as written to the audit log.
+type WebhookTarget struct {
+	URL      string
+	Events   []string
+	Secret   string `secret:"true"`
+	Template string
+}
+
+/// WebhookDeliveryConfig controls how events are sent to every WebhookTarget.
+///  QueueSize is the number of events waiting for each target. Events for a
+///    target whose queue is full are not sent.
+///  Retries is the number of times a failed request is repeated, waiting twice
+///    as long before each one.
+///  Timeout is in seconds per request.
+///  DeadLetterFile collects events that could not be sent, one json object
+///    per line. Empty only logs them.
+type WebhookDeliveryConfig struct {
+	QueueSize      int
+	Retries        int
+	Timeout        int
+	DeadLetterFile string
+}
+
+/// Functions available to webhook templates
+var webhookFuncs = template.FuncMap{
+	// json encodes a value, eg {"text": {{json .Detail}}}
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+/// Event that templates are checked against. Every field holds the quotes,
+/// backslashes and line breaks a username or detail could, so only templates
+/// passing values through json produce json for it
+var hostileEvent = logging.AuditEvent{
+	Event:     logging.AuditLoginSuccess,
+	User:      hostileValue,
+	IP:        hostileValue,
+	UserAgent: hostileValue,
+	RequestID: hostileValue,
+	Detail:    hostileValue,
+}
+
+const hostileValue = "Mallory\", \"admin\": true, \"x\": \"'\\\n\x00</x>"
+
+/// Parses Template. Returns nil if there is none
+func (w WebhookTarget) ParseTemplate() (*template.Template, error) {
+	if w.Template == "" {
+		return nil, nil
+	}
+	return template.New(w.URL).Funcs(webhookFuncs).Parse(w.Template)
+}
+
+/// Problems with a webhook are reported for Webhooks with the webhook's
+/// position in the message, like access rules
+func (c *Config) validateWebhook(v *validator, i int, hook WebhookTarget) {
+	u, err := url.Parse(hook.URL)
+	v.check("Webhooks", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"webhook %d: `%s` is not an http or https url", i+1, hook.URL)
+	for _, e := range hook.Events {
+		v.check("Webhooks", logging.IsAuditEvent(e), "webhook %d: unknown event `%s`", i+1, e)
+	}
+	tmpl, err := hook.ParseTemplate()
+	if err == nil && tmpl != nil {
+		// fields that do not exist are only found by executing the template
+		var body bytes.Buffer
+		err = tmpl.Execute(&body, hostileEvent)
+		if err == nil && !json.Valid(body.Bytes()) {
+			err = fmt.Errorf("template does not produce json for every event, pass values through json, eg {{json .User}}: %s", body.String())
+		}
+	}
+	if err != nil {
+		v.check("Webhooks", false, "webhook %d: %s", i+1, err)
+	}
+}
+
+func (c *Config) validateWebhookDelivery(v *validator) {
+	d := c.WebhookDelivery
+	v.check("WebhookDelivery.QueueSize", d.QueueSize > 0, "must be greater than 0, got %d", d.QueueSize)
+	v.check("WebhookDelivery.Retries", d.Retries >= 0, "may not be negative, got %d", d.Retries)
+	v.check("WebhookDelivery.Timeout", d.Timeout > 0, "must be greater than 0, got %d", d.Timeout)
+	if d.DeadLetterFile != "" {
+		v.checkErr("WebhookDelivery.DeadLetterFile", checkWritableFile(d.DeadLetterFile))
+	}
+}
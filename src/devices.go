@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEVICE_TOKEN string = "better_auth_device"
+
+/// Lifetime of the device cookie, the longest browsers allow
+const deviceCookieAge = 400 * 24 * time.Hour
+
+/// Remembers the browsers each user has logged in with, told apart by a long
+/// lived cookie. Only logins since the server started are known
+type deviceTracker struct {
+	known map[string]map[string]bool // user: device ids
+	lock  sync.Mutex
+}
+
+func newDeviceTracker() *deviceTracker {
+	return &deviceTracker{
+		known: make(map[string]map[string]bool),
+		lock:  sync.Mutex{},
+	}
+}
+
+/// Records that user logged in with device id. Returns bool indicating if
+/// this is a new device for a user who has logged in with another before
+func (d *deviceTracker) add(user string, id string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	devices, exists := d.known[user]
+	if !exists {
+		devices = make(map[string]bool)
+		d.known[user] = devices
+	}
+	if devices[id] {
+		return false
+	}
+	devices[id] = true
+	return len(devices) > 1
+}
+
+/// Returns the device id of the browser sending r, giving it one if it has
+/// none. Returns bool indicating if user has not logged in with it before
+func (s *Server) loginDevice(w http.ResponseWriter, r *http.Request, user string) bool {
+	id := ""
+	if c, err := r.Cookie(DEVICE_TOKEN); err == nil && len(c.Value) == 32 {
+		id = c.Value
+	} else {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{
+			Name:     DEVICE_TOKEN,
+			Value:    id,
+			Expires:  time.Now().Add(deviceCookieAge),
+			SameSite: http.SameSiteStrictMode,
+			HttpOnly: true,
+			Path:     "/",
+		})
+	}
+	return s.devices.add(user, id)
+}
+
+/// Browsers and operating systems recognised in user agents, checked in order
+/// since most browsers also claim to be the ones before them
+var (
+	uaBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	uaSystems = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+/// Returns a short description of the device sending userAgent for people to
+/// recognise, eg "Firefox, Linux". Unknown user agents are returned as they
+/// are, cut short
+func describeDevice(userAgent string) string {
+	parts := []string{}
+	for _, list := range [][]struct{ token, name string }{uaBrowsers, uaSystems} {
+		for _, known := range list {
+			if strings.Contains(userAgent, known.token) {
+				parts = append(parts, known.name)
+				break
+			}
+		}
+	}
+	if len(parts) > 0 {
+		return strings.Join(parts, ", ")
+	}
+	if runes := []rune(userAgent); len(runes) > 40 {
+		return string(runes[:40]) + "…"
+	}
+	return userAgent
+}
@@ -0,0 +1,142 @@
+package email
+
+import (
+	"better_auth/config"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/// Longest a whole conversation with the mail server may take
+const timeout = 30 * time.Second
+
+/// Sender sends plain text email through the configured mail server. A new
+/// connection is made for every email, as few are sent
+type Sender struct {
+	conf config.SMTPConfig
+}
+
+func New(conf config.SMTPConfig) *Sender {
+	return &Sender{conf: conf}
+}
+
+/// Returns bool indicating if a mail server is configured
+func (s *Sender) Enabled() bool {
+	return s.conf.Host != ""
+}
+
+/// Sends an email with subject and body to the address to
+func (s *Sender) Send(to string, subject string, body string) error {
+	if !s.Enabled() {
+		return errors.New("no mail server configured")
+	}
+	from, err := mail.ParseAddress(s.conf.From)
+	if err != nil {
+		return err
+	}
+	msg, err := message(from, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.conf.Host, strconv.Itoa(s.conf.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if s.conf.Security == config.SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: s.conf.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, s.conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.conf.Security == config.SMTPStartTLS {
+		// never fall back to sending passwords and links in the clear
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		err = c.StartTLS(&tls.Config{ServerName: s.conf.Host})
+		if err != nil {
+			return err
+		}
+	}
+	if s.conf.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(to)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+/// Returns the headers and quoted-printable utf-8 body of an email
+func message(from *mail.Address, to string, subject string, body string) ([]byte, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	domain := "better_auth"
+	if at := strings.LastIndexByte(from.Address, '@'); at != -1 {
+		domain = from.Address[at+1:]
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	_, err = qp.Write([]byte(body))
+	if err != nil {
+		return nil, err
+	}
+	err = qp.Close()
+	return msg.Bytes(), err
+}
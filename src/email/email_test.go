@@ -0,0 +1,57 @@
+package email
+
+import (
+	"better_auth/config"
+	"better_auth/email/emailtest"
+	"strings"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	srv := emailtest.NewServer(t)
+	conf := srv.Config()
+	conf.Username = "better_auth"
+	conf.Password = "hunter2"
+
+	body := "Grüße from better_auth\nhttps://example.com/login/reset?token=abc123=="
+	err := New(conf).Send("sterling@example.com", "Passwort zurücksetzen", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := srv.Next(t)
+	if m.From != "noreply@example.com" {
+		t.Fatalf("Incorrect sender `%s`", m.From)
+	}
+	if len(m.To) != 1 || m.To[0] != "sterling@example.com" {
+		t.Fatalf("Incorrect recipients %v", m.To)
+	}
+	if m.Subject != "Passwort zurücksetzen" {
+		t.Fatalf("Incorrect subject `%s`", m.Subject)
+	}
+	if strings.TrimSpace(m.Body) != body {
+		t.Fatalf("Incorrect body `%s`", m.Body)
+	}
+}
+
+/// Tests that starttls is never silently skipped
+func TestRequireStartTLS(t *testing.T) {
+	srv := emailtest.NewServer(t)
+	conf := srv.Config()
+	conf.Security = config.SMTPStartTLS
+
+	err := New(conf).Send("sterling@example.com", "subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Expected STARTTLS error, got %v", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	s := New(config.SMTPConfig{})
+	if s.Enabled() {
+		t.Fatal("Sender without a host is enabled")
+	}
+	if s.Send("sterling@example.com", "subject", "body") == nil {
+		t.Fatal("Sent without a mail server")
+	}
+}
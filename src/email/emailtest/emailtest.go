@@ -0,0 +1,184 @@
+/*
+Emailtest is a stand-in mail server for tests. It speaks just enough SMTP for
+net/smtp, without TLS, and keeps every message it receives.
+*/
+
+package emailtest
+
+import (
+	"better_auth/config"
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+/// Message is an email received by Server
+type Message struct {
+	From    string // envelope sender
+	To      []string
+	Subject string
+	Body    string // decoded
+}
+
+type Server struct {
+	listener net.Listener
+	messages chan Message
+}
+
+/// Starts a Server on a local port, which is closed when the test ends
+func NewServer(t *testing.T) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{listener: l, messages: make(chan Message, 100)}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+/// Returns settings to send email to s
+func (s *Server) Config() config.SMTPConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return config.SMTPConfig{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Security: config.SMTPNone,
+		From:     "better_auth <noreply@example.com>",
+	}
+}
+
+/// Returns the next message received, failing the test if none arrives
+/// within a few seconds
+func (s *Server) Next(t *testing.T) Message {
+	t.Helper()
+	select {
+	case m := <-s.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("No email received")
+		return Message{}
+	}
+}
+
+/// Fails the test if a message arrives within d
+func (s *Server) None(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case m := <-s.messages:
+		t.Fatalf("Unexpected email to %s: %s", m.To, m.Subject)
+	case <-time.After(d):
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var msg Message
+	reply("220 emailtest ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-emailtest")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 accepted")
+		case "MAIL":
+			msg = Message{From: address(line)}
+			reply("250 ok")
+		case "RCPT":
+			msg.To = append(msg.To, address(line))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := readData(r)
+			if err != nil {
+				return
+			}
+			err = msg.parse(data)
+			if err != nil {
+				reply("554 %s", err)
+				continue
+			}
+			s.messages <- msg
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+/// Returns the address in `MAIL FROM:<address>` or `RCPT TO:<address>`
+func address(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if start == -1 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+/// Reads the message sent after DATA, up to the line holding only a dot
+func readData(r *bufio.Reader) (string, error) {
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == ".\r\n" {
+			return data.String(), nil
+		}
+		data.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+func (m *Message) parse(data string) error {
+	parsed, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		return err
+	}
+	m.Subject, err = new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		return err
+	}
+
+	body := parsed.Body
+	if parsed.Header.Get("Content-Transfer-Encoding") == "quoted-printable" {
+		body = quotedprintable.NewReader(body)
+	}
+	b, err := io.ReadAll(body)
+	m.Body = strings.ReplaceAll(string(b), "\r\n", "\n")
+	return err
+}
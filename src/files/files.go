@@ -27,3 +27,41 @@ func FileExists(filePath string) bool {
 	}
 	return !info.IsDir()
 }
+
+/// Replaces filePath with data in a single rename, so readers and crashes
+/// never see it half written. perm is used if the file does not exist yet,
+/// otherwise its mode is kept
+func WriteAtomic(filePath string, data []byte, perm fs.FileMode) error {
+	if info, err := os.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir, name := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
@@ -0,0 +1,112 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+/// Location is what the databases know about an ip address. Fields the
+/// databases do not have are left empty
+type Location struct {
+	Country   string // ISO 3166-1 code, eg DE
+	ASN       uint
+	Org       string // organization owning ASN
+	Latitude  float64
+	Longitude float64
+	Radius    uint16 // accuracy of Latitude and Longitude in km
+	HasCoords bool
+}
+
+func (l Location) String() string {
+	parts := []string{}
+	if l.Country != "" {
+		parts = append(parts, l.Country)
+	}
+	if l.ASN != 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", l.ASN, l.Org)))
+	}
+	if len(parts) == 0 {
+		return "unknown location"
+	}
+	return strings.Join(parts, ", ")
+}
+
+/// The parts of a GeoIP2/GeoLite2 City, Country or ASN record that are used
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude       *float64 `maxminddb:"latitude"`
+		Longitude      *float64 `maxminddb:"longitude"`
+		AccuracyRadius uint16   `maxminddb:"accuracy_radius"`
+	} `maxminddb:"location"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+/// DB looks up ip addresses in one or more MaxMind format databases, eg a
+/// City database for the country and coordinates and an ASN database
+type DB struct {
+	readers []*maxminddb.Reader
+}
+
+/// Opens the .mmdb files at paths. The files are read into memory rather
+/// than mapped, so they can be replaced by updates while the server runs.
+/// No paths gives a DB that knows nothing
+func Open(paths []string) (*DB, error) {
+	db := &DB{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		r, err := maxminddb.FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` is not a MaxMind database: %s", p, err)
+		}
+		db.readers = append(db.readers, r)
+	}
+	return db, nil
+}
+
+/// Returns whether any databases were opened
+func (db *DB) Enabled() bool {
+	return len(db.readers) > 0
+}
+
+/// Returns the Location of ip combined from every database. Addresses that
+/// cannot be found, such as private ones, have an empty Location
+func (db *DB) Lookup(ip string) Location {
+	loc := Location{}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return loc
+	}
+
+	for _, r := range db.readers {
+		var rec record
+		// an ipv6 address in an ipv4 only database is an error, not just missing
+		if r.Lookup(addr, &rec) != nil {
+			continue
+		}
+		if rec.Country.IsoCode != "" {
+			loc.Country = rec.Country.IsoCode
+		}
+		if rec.ASN != 0 {
+			loc.ASN = rec.ASN
+			loc.Org = rec.Org
+		}
+		if rec.Location.Latitude != nil && rec.Location.Longitude != nil {
+			loc.Latitude = *rec.Location.Latitude
+			loc.Longitude = *rec.Location.Longitude
+			loc.Radius = rec.Location.AccuracyRadius
+			loc.HasCoords = true
+		}
+	}
+	return loc
+}
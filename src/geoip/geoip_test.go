@@ -0,0 +1,104 @@
+package geoip
+
+import (
+	"better_auth/geoip/geoiptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	city := geoiptest.WriteDB(t, map[string]map[string]any{
+		"91.0.0.0/10": {
+			"country":  map[string]any{"iso_code": "DE"},
+			"location": map[string]any{"latitude": 52.5, "longitude": 13.4, "accuracy_radius": geoiptest.Uint16(20)},
+		},
+		"130.56.0.0/16": {"country": map[string]any{"iso_code": "AU"}},
+	})
+	asn := geoiptest.WriteDB(t, map[string]map[string]any{
+		"91.0.0.0/10": {
+			"autonomous_system_number":       geoiptest.Uint32(3320),
+			"autonomous_system_organization": "Deutsche Telekom AG",
+		},
+	})
+
+	db, err := Open([]string{city, asn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		ip   string
+		want Location
+	}{
+		{"91.0.0.1", Location{Country: "DE", ASN: 3320, Org: "Deutsche Telekom AG", Latitude: 52.5, Longitude: 13.4, Radius: 20, HasCoords: true}},
+		{"130.56.0.1", Location{Country: "AU"}},
+		{"203.0.113.7", Location{}},
+		{"2001:db8::1", Location{}},
+		{"garbage", Location{}},
+	} {
+		if got := db.Lookup(c.ip); got != c.want {
+			t.Fatalf("%s is %+v, expected %+v", c.ip, got, c.want)
+		}
+	}
+
+	if db.Lookup("91.0.0.1").String() != "DE, AS3320 Deutsche Telekom AG" {
+		t.Fatalf("Unexpected description %s", db.Lookup("91.0.0.1"))
+	}
+
+	none, err := Open(nil)
+	if err != nil || none.Enabled() || none.Lookup("91.0.0.1") != (Location{}) {
+		t.Fatal("Empty DB knows something")
+	}
+	_, err = Open([]string{path.Join(t.TempDir(), "missing.mmdb")})
+	if err == nil {
+		t.Fatal("Missing database opened")
+	}
+	notDB := path.Join(t.TempDir(), "not.mmdb")
+	os.WriteFile(notDB, []byte("hello"), 0644)
+	_, err = Open([]string{notDB})
+	if err == nil {
+		t.Fatal("Invalid database opened")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	berlin := Location{Country: "DE", Latitude: 52.5, Longitude: 13.4, Radius: 20, HasCoords: true}
+	munich := Location{Country: "DE", Latitude: 48.1, Longitude: 11.6, Radius: 20, HasCoords: true}
+	sydney := Location{Country: "AU", Latitude: -33.9, Longitude: 151.2, Radius: 20, HasCoords: true}
+	limits := Limits{NewCountry: true, ImpossibleTravel: true, MaxSpeed: 1000}
+	start := time.Now()
+
+	h := NewHistory()
+	if r := h.Check("Lana", sydney, start, limits); len(r) != 0 {
+		t.Fatalf("First login flagged: %v", r)
+	}
+	h.Record("Lana", berlin, start)
+
+	// berlin to munich by train
+	if r := h.Check("Lana", munich, start.Add(4*time.Hour), limits); len(r) != 0 {
+		t.Fatalf("Usual login flagged: %v", r)
+	}
+	// berlin to munich in 10 minutes
+	if r := h.Check("Lana", munich, start.Add(10*time.Minute), limits); len(r) != 1 {
+		t.Fatalf("Impossible travel not flagged: %v", r)
+	}
+	// berlin to sydney in a day is possible, but a new country
+	if r := h.Check("Lana", sydney, start.Add(24*time.Hour), limits); len(r) != 1 {
+		t.Fatalf("New country not flagged: %v", r)
+	}
+	if r := h.Check("Lana", sydney, start.Add(time.Hour), limits); len(r) != 2 {
+		t.Fatalf("Expected both flags, got %v", r)
+	}
+	if r := h.Check("Lana", sydney, start.Add(time.Hour), Limits{}); len(r) != 0 {
+		t.Fatalf("Disabled checks flagged %v", r)
+	}
+	if r := h.Check("Lana", Location{}, start, limits); len(r) != 0 {
+		t.Fatalf("Unknown location flagged: %v", r)
+	}
+
+	h.Record("Lana", sydney, start.Add(24*time.Hour))
+	if r := h.Check("Lana", berlin, start.Add(48*time.Hour), limits); len(r) != 0 {
+		t.Fatalf("Known country flagged: %v", r)
+	}
+}
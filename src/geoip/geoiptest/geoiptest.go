@@ -0,0 +1,132 @@
+/// Package geoiptest writes small MaxMind format databases for tests
+package geoiptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+/// Values in a database are strings, float64s, maps of them, or these
+type Uint16 uint16
+type Uint32 uint32
+
+const recordSize = 24
+
+/// Writes an ipv4 database mapping each cidr to its record, eg
+///  "91.0.0.0/10": {"country": map[string]any{"iso_code": "DE"}}
+/// and returns its path. The cidrs may not overlap, and addresses in none of
+/// them are not found
+func WriteDB(t *testing.T, records map[string]map[string]any) string {
+	type node [2]int // >= 0 is the next node, < -1 is data -2-index, -1 is empty
+	nodes := []node{{-1, -1}}
+	var data [][]byte
+
+	cidrs := []string{}
+	for c := range records {
+		cidrs = append(cidrs, c)
+	}
+	sort.Strings(cidrs)
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil || n.IP.To4() == nil {
+			t.Fatalf("`%s` is not an ipv4 cidr", c)
+		}
+		ones, _ := n.Mask.Size()
+		data = append(data, encode(records[c]))
+
+		cur := 0
+		for i := 0; i < ones; i++ {
+			bit := int(n.IP.To4()[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[cur][bit] = -2 - (len(data) - 1)
+				break
+			}
+			if nodes[cur][bit] < 0 {
+				nodes = append(nodes, node{-1, -1})
+				nodes[cur][bit] = len(nodes) - 1
+			}
+			cur = nodes[cur][bit]
+		}
+	}
+
+	offsets := []int{}
+	var dataSection bytes.Buffer
+	for _, d := range data {
+		offsets = append(offsets, dataSection.Len())
+		dataSection.Write(d)
+	}
+
+	var db bytes.Buffer
+	for _, n := range nodes {
+		for _, r := range n {
+			switch {
+			case r == -1:
+				r = len(nodes)
+			case r < -1:
+				r = len(nodes) + 16 + offsets[-2-r]
+			}
+			db.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(dataSection.Bytes())
+	db.WriteString("\xAB\xCD\xEFMaxMind.com")
+	db.Write(encode(map[string]any{
+		"binary_format_major_version": Uint16(2),
+		"database_type":               "Test",
+		"ip_version":                  Uint16(4),
+		"node_count":                  Uint32(len(nodes)),
+		"record_size":                 Uint16(recordSize),
+	}))
+
+	f := filepath.Join(t.TempDir(), "test.mmdb")
+	err := os.WriteFile(f, db.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+/// Encodes v in the MaxMind DB data format
+func encode(v any) []byte {
+	var b bytes.Buffer
+	ctrl := func(typ int, size int) {
+		if size < 29 {
+			b.WriteByte(byte(typ<<5 | size))
+		} else {
+			b.Write([]byte{byte(typ<<5 | 29), byte(size - 29)})
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		ctrl(2, len(v))
+		b.WriteString(v)
+	case float64:
+		ctrl(3, 8)
+		binary.Write(&b, binary.BigEndian, math.Float64bits(v))
+	case Uint16:
+		ctrl(5, 2)
+		binary.Write(&b, binary.BigEndian, uint16(v))
+	case Uint32:
+		ctrl(6, 4)
+		binary.Write(&b, binary.BigEndian, uint32(v))
+	case map[string]any:
+		ctrl(7, len(v))
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.Write(encode(k))
+			b.Write(encode(v[k]))
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,92 @@
+package geoip
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const earthRadius = 6371 // km
+
+type login struct {
+	loc  Location
+	time time.Time
+}
+
+/// History remembers where each user has logged in from, to spot logins that
+/// are unlikely to be theirs. It is kept in memory, so after a restart every
+/// user's next login is accepted as their first
+type History struct {
+	countries map[string]map[string]bool // user: countries logged in from
+	last      map[string]login
+	lock      sync.Mutex
+}
+
+/// Limits chooses which checks History.Check makes.
+///  MaxSpeed is in km/h, faster travel between two logins is impossible travel
+type Limits struct {
+	NewCountry       bool
+	ImpossibleTravel bool
+	MaxSpeed         int
+}
+
+func NewHistory() *History {
+	return &History{
+		countries: make(map[string]map[string]bool),
+		last:      make(map[string]login),
+		lock:      sync.Mutex{},
+	}
+}
+
+/// Returns why a login by user from loc at t is unusual, or nothing if it is
+/// not. A user's first login is never unusual
+func (h *History) Check(user string, loc Location, t time.Time, limits Limits) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	reasons := []string{}
+	prev, seen := h.last[user]
+	if !seen {
+		return reasons
+	}
+
+	if limits.NewCountry && loc.Country != "" && !h.countries[user][loc.Country] {
+		reasons = append(reasons, fmt.Sprintf("new country %s", loc.Country))
+	}
+
+	if limits.ImpossibleTravel && loc.HasCoords && prev.loc.HasCoords {
+		// only the distance the locations are certainly apart counts
+		km := distance(prev.loc, loc) - float64(prev.loc.Radius) - float64(loc.Radius)
+		hours := t.Sub(prev.time).Hours()
+		if km > 0 && (hours <= 0 || km/hours > float64(limits.MaxSpeed)) {
+			reasons = append(reasons, fmt.Sprintf("impossible travel of %.0f km from %s in %s",
+				km, prev.loc, t.Sub(prev.time).Round(time.Second)))
+		}
+	}
+	return reasons
+}
+
+/// Records a login by user from loc at t
+func (h *History) Record(user string, loc Location, t time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if loc.Country != "" {
+		if h.countries[user] == nil {
+			h.countries[user] = make(map[string]bool)
+		}
+		h.countries[user][loc.Country] = true
+	}
+	h.last[user] = login{loc: loc, time: t}
+}
+
+/// Great-circle distance between a and b in km
+func distance(a Location, b Location) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(b.Latitude - a.Latitude)
+	dLon := rad(b.Longitude - a.Longitude)
+	x := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Latitude))*math.Cos(rad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(x))
+}
@@ -3,12 +3,17 @@ module better_auth
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/alexflint/go-arg v1.4.3
 	github.com/jbrodriguez/mlog v0.0.0-20180805173533-cbd5ae8e9c53
+	github.com/oschwald/maxminddb-golang v1.3.1
 	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+require (
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+)
 
 require (
 	github.com/alexflint/go-scalar v1.1.0 // indirect
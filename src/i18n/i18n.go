@@ -0,0 +1,180 @@
+/*
+I18n holds the translated messages shown to users. Catalogs are json files
+named after a language tag, eg `de.json` or `pt-BR.json`, mapping message keys
+to text:
+
+{
+	"login.username": "Benutzername",
+	"login.password": "Passwort"
+}
+
+Built-in catalogs are embedded in the binary. Catalogs in the locale dir are
+loaded over them, so operators can add languages or reword single messages.
+Keys missing from a catalog fall back to its base language, then to English.
+*/
+
+package i18n
+
+import (
+	"better_auth/logging"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+const DefaultLanguage string = "en"
+
+/// Catalog maps message keys to translated text
+type Catalog map[string]string
+
+type Bundle struct {
+	catalogs map[string]Catalog // lower case language tag: merged catalog
+	forced   string
+}
+
+/// Loads the built-in catalogs, then any in dir. If language is not empty it
+/// is used for every request instead of negotiating with Accept-Language
+func New(language string, dir string) (*Bundle, error) {
+	raw := make(map[string]Catalog)
+
+	err := loadCatalogs(embedded, "locales", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			logging.Info("Loading message catalogs from `%s`", dir)
+			err = loadCatalogs(os.DirFS(dir), ".", raw)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b := &Bundle{catalogs: make(map[string]Catalog)}
+	for tag := range raw {
+		b.catalogs[tag] = merge(raw, tag)
+	}
+
+	if language != "" {
+		tag := strings.ToLower(language)
+		if _, exists := b.catalogs[tag]; !exists {
+			return nil, fmt.Errorf("no message catalog for language `%s`", language)
+		}
+		b.forced = tag
+	}
+	return b, nil
+}
+
+/// Reads every *.json in dir of fsys into catalogs, adding to any messages
+/// already loaded for the same language
+func loadCatalogs(fsys fs.FS, dir string, catalogs map[string]Catalog) error {
+	matches, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(dir, "*.json")))
+	if err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		data, err := fs.ReadFile(fsys, m)
+		if err != nil {
+			return err
+		}
+
+		c := make(Catalog)
+		err = json.Unmarshal(data, &c)
+		if err != nil {
+			return fmt.Errorf("invalid message catalog `%s`: %s", m, err)
+		}
+
+		tag := strings.TrimSuffix(filepath.Base(m), ".json")
+		tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+		if catalogs[tag] == nil {
+			catalogs[tag] = make(Catalog)
+		}
+		for k, v := range c {
+			catalogs[tag][k] = v
+		}
+	}
+	return nil
+}
+
+/// Returns the messages for tag layered over its base language and English
+func merge(raw map[string]Catalog, tag string) Catalog {
+	layers := []string{DefaultLanguage}
+	if base := baseLanguage(tag); base != tag && base != DefaultLanguage {
+		layers = append(layers, base)
+	}
+	if tag != DefaultLanguage {
+		layers = append(layers, tag)
+	}
+
+	c := make(Catalog)
+	for _, l := range layers {
+		for k, v := range raw[l] {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func baseLanguage(tag string) string {
+	base, _, _ := strings.Cut(tag, "-")
+	return base
+}
+
+/// Returns the language to use for r and its catalog
+func (b *Bundle) ForRequest(r *http.Request) (string, Catalog) {
+	tag := b.forced
+	if tag == "" {
+		tag = b.Negotiate(r.Header.Get("Accept-Language"))
+	}
+	return tag, b.catalogs[tag]
+}
+
+/// Returns the best available language for an Accept-Language header value,
+/// or DefaultLanguage if none of the requested languages are available
+func (b *Bundle) Negotiate(acceptLanguage string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+
+	prefs := []pref{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		prefs = append(prefs, pref{tag: strings.ToLower(tag), q: q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if _, exists := b.catalogs[p.tag]; exists {
+			return p.tag
+		}
+		if _, exists := b.catalogs[baseLanguage(p.tag)]; exists {
+			return baseLanguage(p.tag)
+		}
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,119 @@
+package i18n
+
+import (
+	"better_auth/logging"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.LevelError)
+	m.Run()
+}
+
+/// Tests that every built-in catalog has every English message
+func TestCatalogsComplete(t *testing.T) {
+	b, err := New("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := make(map[string]Catalog)
+	err = loadCatalogs(embedded, "locales", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tag, c := range raw {
+		for k := range b.catalogs[DefaultLanguage] {
+			if c[k] == "" {
+				t.Fatalf("Catalog `%s` is missing message `%s`", tag, k)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	b, err := New("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for header, want := range map[string]string{
+		"":                            "en",
+		"de":                          "de",
+		"de-AT,de;q=0.9,en;q=0.8":     "de",
+		"fr-CH, fr;q=0.9, en;q=0.8":   "fr",
+		"en;q=0.5, es":                "es",
+		"ja, *;q=0.5":                 "en",
+		"de;q=0, fr;q=0.1":            "fr",
+		"not a language;q=abc, fr-FR": "fr",
+	} {
+		got := b.Negotiate(header)
+		if got != want {
+			t.Fatalf("Accept-Language `%s` negotiated `%s`, expected `%s`", header, got, want)
+		}
+	}
+}
+
+func TestForcedLanguage(t *testing.T) {
+	b, err := New("FR", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.Header.Set("Accept-Language", "de")
+	lang, c := b.ForRequest(r)
+	if lang != "fr" {
+		t.Fatalf("Forced language ignored, got `%s`", lang)
+	}
+	if c["login.password"] != "mot de passe" {
+		t.Fatalf("Unexpected message `%s`", c["login.password"])
+	}
+
+	_, err = New("tlh", "")
+	if err == nil {
+		t.Fatal("Forcing a language without a catalog did not return an error")
+	}
+}
+
+/// Tests adding a language and rewording a message from the locale dir
+func TestCatalogDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "pt_BR.json"), []byte(`{"login.password": "senha"}`), 0644)
+	os.WriteFile(path.Join(dir, "de.json"), []byte(`{"login.submit": "Los"}`), 0644)
+
+	b, err := New("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Negotiate("pt-BR") != "pt-br" {
+		t.Fatal("Added catalog not negotiated")
+	}
+	pt := b.catalogs["pt-br"]
+	if pt["login.password"] != "senha" {
+		t.Fatalf("Unexpected message `%s`", pt["login.password"])
+	}
+	if pt["login.username"] != "username" {
+		t.Fatal("Missing message did not fall back to English")
+	}
+
+	de := b.catalogs["de"]
+	if de["login.submit"] != "Los" || de["login.password"] != "Passwort" {
+		t.Fatal("Catalog override did not merge with built-in catalog")
+	}
+}
+
+func TestBadCatalog(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "de.json"), []byte(`{"login.submit": `), 0644)
+
+	_, err := New("", dir)
+	if err == nil {
+		t.Fatal("Invalid catalog did not return an error")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"better_auth/logging"
+	"better_auth/pages"
+	"fmt"
+	"net/http"
+)
+
+const inviteErrFailed = "failed"
+
+/// Lets the holder of an invite link, created by the invite subcommand,
+/// choose the password of their new account
+/// GET returns the form for the password
+/// POST adds the user with the password and the invite's groups and uses up
+///   the link. Returns 400 and the form again if the passwords do not match
+///   or are not allowed
+///  Returns 400 for both methods if the link is invalid, has expired or its
+///    user was added some other way, and 403 if the csrf token isn't valid.
+///  The link is only used up once the user is added with their groups and
+///    enrolled by enrollInvited. Returns 500 and the form again if that
+///    fails, leaving the link usable
+func (s *Server) acceptInvite(w http.ResponseWriter, r *http.Request) {
+	// keep the token out of the Referer of anything the page links to
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	token := r.FormValue("token")
+	inv, ok := s.invites.Get(token)
+	if !ok || s.pwManager.Exists(inv.User) {
+		s.renderInvite(w, r, 400, pages.InviteData{Error: resetErrInvalid})
+		return
+	}
+	data := pages.InviteData{Token: token, User: inv.User}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderInvite(w, r, 200, data)
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			data.Error = loginErrExpired
+			s.renderInvite(w, r, 403, data)
+			return
+		}
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			data.Error = resetErrMismatch
+			s.renderInvite(w, r, 400, data)
+			return
+		}
+		err := s.pwManager.CheckPassword(inv.User, password)
+		if err != nil {
+			data.Error = resetErrWeak
+			data.Problems = s.policyProblems(w, r, err)
+			s.renderInvite(w, r, 400, data)
+			return
+		}
+
+		// AddUser refuses a user that exists, so only one request gets past it
+		err = s.pwManager.AddUser(inv.User, password)
+		if err != nil {
+			if s.pwManager.Exists(inv.User) {
+				s.renderInvite(w, r, 400, pages.InviteData{Error: resetErrInvalid})
+				return
+			}
+			s.inviteFailed(w, r, data, err)
+			return
+		}
+		if len(inv.Groups) > 0 {
+			err = s.pwManager.SetGroups(inv.User, inv.Groups)
+			if err != nil {
+				s.removeInvitedUser(inv.User)
+				s.inviteFailed(w, r, data, err)
+				return
+			}
+		}
+		err = s.enrollInvited(w, r, inv.User)
+		if err != nil {
+			s.removeInvitedUser(inv.User)
+			s.inviteFailed(w, r, data, err)
+			return
+		}
+		// the invite is only used up once the user is complete
+		_, ok, err = s.invites.Use(token)
+		if err != nil || !ok {
+			s.removeInvitedUser(inv.User)
+			if err != nil {
+				s.inviteFailed(w, r, data, err)
+				return
+			}
+			s.renderInvite(w, r, 400, pages.InviteData{Error: resetErrInvalid})
+			return
+		}
+		s.audit(r, logging.AuditUserAdded, inv.User, "invite link")
+		s.renderInvite(w, r, 200, pages.InviteData{User: inv.User, Done: true})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Logs err and renders the invite form again with 500, so the invitee can
+/// retry with the link that was not used up
+func (s *Server) inviteFailed(w http.ResponseWriter, r *http.Request, data pages.InviteData, err error) {
+	logging.Error(fmt.Errorf("unable to add invited user %s: %s", data.User, err))
+	data.Error = inviteErrFailed
+	s.renderInvite(w, r, 500, data)
+}
+
+/// Enrolls the second factors of a newly invited user before their invite is
+/// used up, so an error undoes the user and leaves the link usable.
+/// better_auth does not have a second factor yet, so there is nothing to
+/// enroll and invitees only choose a password
+func (s *Server) enrollInvited(w http.ResponseWriter, r *http.Request, user string) error {
+	return nil
+}
+
+/// Removes a user added for an invite that could not be completed
+func (s *Server) removeInvitedUser(user string) {
+	err := s.pwManager.RemoveUser(user)
+	if err != nil {
+		logging.Error(fmt.Errorf("unable to remove incomplete invited user %s: %s", user, err))
+	}
+}
+
+/// Renders invite.html with status
+func (s *Server) renderInvite(w http.ResponseWriter, r *http.Request, status int, data pages.InviteData) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	live := s.live()
+	data.Branding = live.pages.Branding()
+	data.Locale = s.locale(w, r)
+	data.CSRFToken = csrf
+	err = live.pages.Render(w, status, "invite.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
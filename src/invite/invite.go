@@ -0,0 +1,196 @@
+/*
+Invite keeps the invitations that let new users choose their own password.
+Invites are created by the invite subcommand and accepted on the server, so
+they are kept in a file both can read, one per line like:
+
+9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08:rooster_cogburn:expires=1667260800:groups=marshals
+
+The first field is the SHA-256 of the invite's token, which is an invite_v1_
+id from token_store. The token itself is only in the link given to the user,
+so the file cannot be used to accept invites.
+*/
+
+package invite
+
+import (
+	"better_auth/files"
+	"better_auth/token_store"
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/// Invite lets User choose a password until Expires, and puts them in Groups
+type Invite struct {
+	User    string
+	Groups  []string
+	Expires time.Time
+}
+
+type entry struct {
+	hash string
+	Invite
+}
+
+/// Returns the line of the invites file for e
+func (e entry) line() string {
+	fields := []string{e.hash, e.User, "expires=" + strconv.FormatInt(e.Expires.Unix(), 10)}
+	if len(e.Groups) > 0 {
+		fields = append(fields, "groups="+strings.Join(e.Groups, ","))
+	}
+	return strings.Join(fields, ":") + "\n"
+}
+
+type Store struct {
+	file string
+	lock sync.Mutex
+}
+
+func New(file string) *Store {
+	return &Store{file: file, lock: sync.Mutex{}}
+}
+
+/// Creates an invite for user, replacing any they had before, that expires
+/// after lifetime. Returns the token for the invite link
+func (s *Store) Create(user string, groups []string, lifetime time.Duration) (string, error) {
+	token, err := token_store.NewID(token_store.KindInvite)
+	if err != nil {
+		return "", err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	kept := []entry{}
+	for _, e := range entries {
+		if e.User != user {
+			kept = append(kept, e)
+		}
+	}
+	kept = append(kept, entry{
+		hash:   token_store.Key(token_store.KindInvite, token),
+		Invite: Invite{User: user, Groups: groups, Expires: time.Now().Add(lifetime)},
+	})
+	return token, s.write(kept)
+}
+
+/// Returns the invite for token, and bool indicating if it exists and has not
+/// expired
+func (s *Store) Get(token string) (Invite, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return Invite{}, false
+	}
+	e, found := find(entries, token)
+	return e.Invite, found
+}
+
+/// Removes the invite for token so it cannot be used again. Returns the
+/// invite, and bool indicating if it existed and had not expired. Expired
+/// invites are removed too
+func (s *Store) Use(token string) (Invite, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return Invite{}, false, err
+	}
+	used, found := find(entries, token)
+
+	now := time.Now()
+	kept := []entry{}
+	for _, e := range entries {
+		if e.hash != used.hash && e.Expires.After(now) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) < len(entries) {
+		err = s.write(kept)
+	}
+	return used.Invite, found && err == nil, err
+}
+
+/// Returns the unexpired entry for token
+func find(entries []entry, token string) (entry, bool) {
+	hash := token_store.Key(token_store.KindInvite, token)
+	if hash == "" {
+		return entry{}, false
+	}
+	for _, e := range entries {
+		if subtle.ConstantTimeCompare([]byte(e.hash), []byte(hash)) == 1 && e.Expires.After(time.Now()) {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+/// Reads every entry of the invites file, which may not exist yet. Must be
+/// called with the lock held
+func (s *Store) read() ([]entry, error) {
+	f, err := os.Open(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []entry{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		e, err := parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry on line %d of %s: %s", line, s.file, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func parseLine(text string) (entry, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) < 3 {
+		return entry{}, errors.New("expected hash:username:expires=timestamp")
+	}
+
+	e := entry{hash: parts[0], Invite: Invite{User: parts[1]}}
+	for _, field := range parts[2:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "expires":
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return entry{}, fmt.Errorf("`expires` must be a unix timestamp, got `%s`", value)
+			}
+			e.Expires = time.Unix(secs, 0)
+		case "groups":
+			e.Groups = strings.Split(value, ",")
+		default:
+			return entry{}, fmt.Errorf("unknown field `%s`, was the file written by a newer version?", key)
+		}
+	}
+	return e, nil
+}
+
+/// Replaces the invites file with entries. Must be called with the lock held
+func (s *Store) write(entries []entry) error {
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.line())
+	}
+	return files.WriteAtomic(s.file, []byte(b.String()), 0600)
+}
@@ -0,0 +1,79 @@
+package invite
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvite(t *testing.T) {
+	f := path.Join(t.TempDir(), "invites")
+	s := New(f)
+
+	token, err := s.Create("Mattie", []string{"posse", "ranchers"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := s.Create("LaBoeuf", nil, time.Hour)
+
+	data, _ := os.ReadFile(f)
+	if strings.Contains(string(data), token) {
+		t.Fatal("token stored in the invites file")
+	}
+	if !strings.HasPrefix(token, "invite_v1_") {
+		t.Fatalf("token `%s` does not start with its kind and version", token)
+	}
+
+	// a second store, as the server is to the invite subcommand
+	server := New(f)
+	inv, ok := server.Get(token)
+	if !ok || inv.User != "Mattie" || strings.Join(inv.Groups, ",") != "posse,ranchers" {
+		t.Fatalf("unexpected invite %+v", inv)
+	}
+	for _, bad := range []string{"not a token", strings.Replace(token, "invite_", "sess_", 1)} {
+		if _, ok := server.Get(bad); ok {
+			t.Fatalf("invite found for token `%s`", bad)
+		}
+	}
+
+	inv, ok, err = server.Use(token)
+	if err != nil || !ok || inv.User != "Mattie" {
+		t.Fatalf("unable to use invite: %v", err)
+	}
+	if _, ok, _ := server.Use(token); ok {
+		t.Fatal("invite used twice")
+	}
+	if _, ok := server.Get(other); !ok {
+		t.Fatal("other invite removed")
+	}
+
+	// a new invite replaces the old one
+	replaced, _ := s.Create("LaBoeuf", nil, time.Hour)
+	if _, ok := s.Get(other); ok {
+		t.Fatal("replaced invite still valid")
+	}
+	if _, ok := s.Get(replaced); !ok {
+		t.Fatal("new invite not valid")
+	}
+
+	expired, _ := s.Create("Rooster", nil, -time.Second)
+	if _, ok := s.Get(expired); ok {
+		t.Fatal("expired invite valid")
+	}
+	if _, ok, _ := s.Use(expired); ok {
+		t.Fatal("expired invite used")
+	}
+	if data, _ := os.ReadFile(f); strings.Contains(string(data), "Rooster") {
+		t.Fatal("expired invite kept")
+	}
+}
+
+func TestBadInvitesFile(t *testing.T) {
+	f := path.Join(t.TempDir(), "invites")
+	os.WriteFile(f, []byte("abc:Mattie:color=red\n"), 0600)
+	if _, err := New(f).Create("Rooster", nil, time.Hour); err == nil {
+		t.Fatal("invalid invites file read")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"better_auth/config"
+	"better_auth/logging"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+/// First file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+/// Returns the listener the server accepts connections on. This is the
+/// socket passed by systemd if started by a .socket unit, otherwise a unix
+/// socket or tcp port as configured
+func listen(cfg *config.Config) (net.Listener, error) {
+	l, err := activationListener()
+	if err != nil || l != nil {
+		return l, err
+	}
+
+	sock, isSocket := cfg.SocketPath()
+	if !isSocket {
+		return net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Address, cfg.Port))
+	}
+	return listenUnix(sock, cfg.Socket)
+}
+
+/// Returns the first socket passed by systemd (see sd_listen_fds(3)), or nil
+/// if the process was not socket activated
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// not for any child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		logging.Warning("systemd passed %d sockets, only the first is used", n)
+	}
+
+	f := os.NewFile(listenFdsStart, "systemd socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use socket passed by systemd: %s", err)
+	}
+	logging.Info("Using socket %s passed by systemd, ignoring Address and Port", l.Addr())
+	return l, nil
+}
+
+/// Creates a unix socket at path with the ownership and mode of opts.
+/// A socket left behind by a previous run is removed, but not one that
+/// another process is still accepting connections on
+func listenUnix(path string, opts config.SocketConfig) (net.Listener, error) {
+	mode, err := opts.FileMode()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := opts.IDs()
+	if err != nil {
+		return nil, err
+	}
+
+	err = removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err == nil && (uid != -1 || gid != -1) {
+		err = os.Chown(path, uid, gid)
+	}
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("unable to set permissions of `%s`: %s", path, err)
+	}
+	return l, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("`%s` exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("`%s` is in use by another process", path)
+	}
+	logging.Info("Removing stale socket `%s`", path)
+	return os.Remove(path)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"better_auth/config"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	cfg := reloadableConfig(t)
+	sock := path.Join(t.TempDir(), "run", "better_auth.sock")
+	cfg.Address = config.UnixPrefix + sock
+	cfg.Socket.Mode = "0600"
+	if p := cfg.Validate(); len(p) > 0 {
+		t.Fatalf("Unexpected problems %v", p)
+	}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := listen(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: srv.handler()}
+	go hs.Serve(l)
+	defer hs.Close()
+
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Socket created with mode %o", info.Mode().Perm())
+	}
+
+	// as used by adduser
+	client, baseURL, err := localClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(baseURL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	if _, err = listen(cfg); err == nil {
+		t.Fatal("Socket in use replaced")
+	}
+}
+
+func TestStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	sock := path.Join(dir, "better_auth.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %s", err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+
+	opts := config.SocketConfig{Mode: "0660"}
+	l2, err := listenUnix(sock, opts)
+	if err != nil {
+		t.Fatalf("Stale socket not removed: %s", err)
+	}
+	l2.Close()
+
+	notASocket := path.Join(dir, "better_auth.conf")
+	os.WriteFile(notASocket, []byte("{}"), 0644)
+	if _, err = listenUnix(notASocket, opts); err == nil {
+		t.Fatal("Regular file replaced by socket")
+	}
+	if _, err = os.Stat(notASocket); err != nil {
+		t.Fatal("Regular file removed")
+	}
+}
+
+func TestActivationListener(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	l, err := activationListener()
+	if l != nil || err != nil {
+		t.Fatal("Socket meant for another process used")
+	}
+}
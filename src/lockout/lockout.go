@@ -0,0 +1,98 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures    int
+	firstFail   time.Time
+	lockedUntil time.Time
+}
+
+/// Tracker counts failed login attempts per key (usually a username) and
+/// locks the key out once too many failures happen within a window.
+type Tracker struct {
+	maxAttempts int
+	window      time.Duration
+	duration    time.Duration
+	entries     map[string]*entry
+	lock        sync.Mutex
+}
+
+/// Creates a new Tracker. window and duration are in seconds. A maxAttempts
+/// of 0 or less disables lockouts entirely.
+func New(maxAttempts int, window int, duration int) *Tracker {
+	return &Tracker{
+		maxAttempts: maxAttempts,
+		window:      time.Second * time.Duration(window),
+		duration:    time.Second * time.Duration(duration),
+		entries:     make(map[string]*entry),
+		lock:        sync.Mutex{},
+	}
+}
+
+/// Changes the limits of New. Failures already counted are kept, and users
+/// already locked out stay locked out until their lockout ends
+func (t *Tracker) SetLimits(maxAttempts int, window int, duration int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.maxAttempts = maxAttempts
+	t.window = time.Second * time.Duration(window)
+	t.duration = time.Second * time.Duration(duration)
+}
+
+/// Returns bool indicating if key is currently locked out
+func (t *Tracker) IsLocked(key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	e, exists := t.entries[key]
+	if !exists {
+		return false
+	}
+	return e.lockedUntil.After(time.Now())
+}
+
+/// Records a failed attempt for key.
+/// Returns bool indicating if this failure caused key to be locked out
+func (t *Tracker) Fail(key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.maxAttempts <= 0 {
+		return false
+	}
+	t.cleanExpired()
+
+	now := time.Now()
+	e, exists := t.entries[key]
+	if !exists || now.Sub(e.firstFail) > t.window {
+		e = &entry{firstFail: now}
+		t.entries[key] = e
+	}
+	e.failures++
+
+	if e.failures >= t.maxAttempts && !e.lockedUntil.After(now) {
+		e.lockedUntil = now.Add(t.duration)
+		return true
+	}
+	return false
+}
+
+/// Clears all failures for key, eg after a successful login
+func (t *Tracker) Reset(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.entries, key)
+}
+
+/// Removes entries whose window and lockout have both passed. Caller must hold lock.
+func (t *Tracker) cleanExpired() {
+	now := time.Now()
+	for k, e := range t.entries {
+		if now.Sub(e.firstFail) > t.window && !e.lockedUntil.After(now) {
+			delete(t.entries, k)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockAfterMaxAttempts(t *testing.T) {
+	tr := New(3, 60, 60)
+
+	for i := 0; i < 2; i++ {
+		if tr.Fail("Wayne") {
+			t.Fatalf("Locked out after %d failures", i+1)
+		}
+		if tr.IsLocked("Wayne") {
+			t.Fatal("Key locked before max attempts")
+		}
+	}
+
+	if !tr.Fail("Wayne") {
+		t.Fatal("Final failure did not report lockout")
+	}
+	if !tr.IsLocked("Wayne") {
+		t.Fatal("Key not locked after max attempts")
+	}
+	if tr.IsLocked("Eastwood") {
+		t.Fatal("Unrelated key is locked")
+	}
+}
+
+func TestReset(t *testing.T) {
+	tr := New(2, 60, 60)
+
+	tr.Fail("Wayne")
+	tr.Reset("Wayne")
+	tr.Fail("Wayne")
+	if tr.IsLocked("Wayne") {
+		t.Fatal("Failures were not cleared by reset")
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	tr := New(1, 1, 1)
+
+	tr.Fail("Wayne")
+	if !tr.IsLocked("Wayne") {
+		t.Fatal("Key not locked after max attempts")
+	}
+
+	time.Sleep(time.Millisecond * 1100)
+	if tr.IsLocked("Wayne") {
+		t.Fatal("Lockout did not expire")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	tr := New(0, 60, 60)
+
+	for i := 0; i < 10; i++ {
+		tr.Fail("Wayne")
+	}
+	if tr.IsLocked("Wayne") {
+		t.Fatal("Key locked while lockouts are disabled")
+	}
+}
@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+/// Audit event types
+const (
+	AuditLoginSuccess   string = "login_success"
+	AuditLoginFailure   string = "login_failure"
+	AuditLogout         string = "logout"
+	AuditLockout        string = "lockout"
+	AuditUserAdded      string = "user_added"
+	AuditSessionRevoked string = "session_revoked"
+	AuditConfigReloaded string = "config_reloaded"
+	AuditAccessAllowed  string = "access_allowed"
+	AuditAccessDenied   string = "access_denied"
+	AuditLoginFlagged   string = "login_flagged"
+	AuditNewDevice      string = "new_device"
+	AuditResetRequested string = "password_reset_requested"
+	AuditPasswordChange string = "password_changed"
+	AuditUserChanged    string = "user_changed"
+	AuditUserDeleted    string = "user_deleted"
+	AuditLockoutCleared string = "lockout_cleared"
+	AuditUserInvited    string = "user_invited"
+)
+
+/// Every audit event type
+var AuditEvents = []string{
+	AuditLoginSuccess,
+	AuditLoginFailure,
+	AuditLogout,
+	AuditLockout,
+	AuditUserAdded,
+	AuditSessionRevoked,
+	AuditConfigReloaded,
+	AuditAccessAllowed,
+	AuditAccessDenied,
+	AuditLoginFlagged,
+	AuditNewDevice,
+	AuditResetRequested,
+	AuditPasswordChange,
+	AuditUserChanged,
+	AuditUserDeleted,
+	AuditLockoutCleared,
+	AuditUserInvited,
+}
+
+/// Returns whether event is one of AuditEvents
+func IsAuditEvent(event string) bool {
+	for _, e := range AuditEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+/// AuditEvent is a single line of the audit log
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	User      string    `json:"user,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+/// Writes e to the audit log. Time is set to now if it is zero
+func Audit(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		Error(err)
+		return
+	}
+
+	std.lock.Lock()
+	if std.audit != nil {
+		_, err = std.audit.Write(append(line, '\n'))
+	}
+	sw := std.syslog
+	std.lock.Unlock()
+
+	if err != nil {
+		Error(err)
+	}
+
+	if sw != nil {
+		severity, exists := auditSeverities[e.Event]
+		if !exists {
+			severity = 6
+		}
+		sw.send(sw.auditFacility, severity, "audit", string(line))
+	}
+}
+
+/// How much of the end of the audit log RecentAudit reads
+const auditTail = 1024 * 1024
+
+/// Returns up to limit of the latest audit events keep returns true for,
+/// newest first. Only the end of the audit log is read, so older events are
+/// not found in a large log. Returns nothing if there is no audit log
+func RecentAudit(limit int, keep func(AuditEvent) bool) ([]AuditEvent, error) {
+	std.lock.Lock()
+	path := std.auditPath
+	std.lock.Unlock()
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := info.Size() - auditTail
+	if offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, info.Size()-offset)
+	_, err = f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	lines := bytes.Split(buf, []byte{'\n'})
+	if offset > 0 {
+		// the first line is most likely cut off
+		lines = lines[1:]
+	}
+
+	found := []AuditEvent{}
+	for i := len(lines) - 1; i >= 0 && len(found) < limit; i-- {
+		var e AuditEvent
+		if json.Unmarshal(lines[i], &e) != nil {
+			continue
+		}
+		if keep(e) {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
@@ -1,24 +1,233 @@
+/*
+Logging writes better_auth's operational log and its audit log.
+
+The operational log holds startup messages, errors and debugging output, and
+can be written to a rotating file, stdout or syslog as plain text or json lines.
+
+The audit log only holds security events (see AuditEvent) as json lines. It is
+opened append-only and never rotated by better_auth. When logging to syslog
+audit events are also sent there on their own facility.
+*/
+
 package logging
 
 import (
-	"better_auth/config"
+	"better_auth/files"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jbrodriguez/mlog"
 )
 
-func init() {
-	mlog.Start(mlog.LevelInfo, "")
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+/// syslog severities understood by journald on stdout
+var levelPriorities = map[Level]int{
+	LevelDebug:   7,
+	LevelInfo:    6,
+	LevelWarning: 4,
+	LevelError:   3,
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level `%s`", s)
+}
+
+const (
+	FormatText string = "text"
+	FormatJSON string = "json"
+
+	OutputFile     string = "file"
+	OutputStdout   string = "stdout"
+	OutputJournald string = "journald"
+	OutputSyslog   string = "syslog"
+)
+
+/// Options for Start
+///  Level is the lowest level written to the operational log
+///  Format is FormatText or FormatJSON
+///  Output is OutputFile, OutputStdout, OutputJournald which is stdout
+///    without timestamps and with priority prefixes for journald, or OutputSyslog
+///  Dir, MaxSize (megabytes) and Backups control the log file for OutputFile
+///  AuditFile is the audit log path. Empty disables the audit log
+///  Syslog configures OutputSyslog
+type Options struct {
+	Level     string
+	Format    string
+	Output    string
+	Dir       string
+	MaxSize   int
+	Backups   int
+	AuditFile string
+	Syslog    SyslogOptions
+}
+
+type logger struct {
+	level  Level
+	format string
+	output string
+	out    io.Writer
+	audit  io.Writer
+	syslog *syslogWriter
+
+	auditPath string
+	lock      sync.Mutex
+}
+
+var std = &logger{
+	level:  LevelInfo,
+	format: FormatText,
+	output: OutputStdout,
+	out:    os.Stdout,
+}
+
+/// Sets the lowest level written to the operational log
+func SetLevel(l Level) {
+	std.lock.Lock()
+	defer std.lock.Unlock()
+	std.level = l
 }
 
-func Start(conf *config.Config) error {
-	err := os.MkdirAll(conf.LogDir, 0644)
+/// Configures logging. Until Start is called messages are written to stdout
+/// as text at LevelInfo and audit events are discarded
+func Start(opts Options) error {
+	level, err := ParseLevel(opts.Level)
 	if err != nil {
 		return err
 	}
 
-	mlog.StartEx(mlog.LevelInfo, "better_auth.log", conf.LogSize*1024*1024, conf.LogBackups)
-	fmt.Printf("Logging started at %s \n", conf.LogDir)
+	if opts.Format != FormatText && opts.Format != FormatJSON {
+		return fmt.Errorf("unknown log format `%s`", opts.Format)
+	}
+
+	var out io.Writer
+	var sw *syslogWriter
+	switch opts.Output {
+	case OutputStdout, OutputJournald:
+		out = os.Stdout
+	case OutputFile:
+		err = os.MkdirAll(opts.Dir, 0755)
+		if err != nil {
+			return err
+		}
+		out, err = mlog.NewRotatingFileHandler(filepath.Join(opts.Dir, "better_auth.log"), opts.MaxSize*1024*1024, opts.Backups)
+		if err != nil {
+			return fmt.Errorf("unable to open log file in `%s`: %s", opts.Dir, err)
+		}
+	case OutputSyslog:
+		sw, err = newSyslogWriter(opts.Syslog)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown log output `%s`", opts.Output)
+	}
+
+	var audit io.Writer
+	if opts.AuditFile != "" {
+		audit, err = files.MkDirsAndOpen(opts.AuditFile, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to open audit log `%s`: %s", opts.AuditFile, err)
+		}
+	}
+
+	std.lock.Lock()
+	if std.syslog != nil {
+		std.syslog.close()
+	}
+	std.level = level
+	std.format = opts.Format
+	std.output = opts.Output
+	std.out = out
+	std.audit = audit
+	std.auditPath = opts.AuditFile
+	std.syslog = sw
+	std.lock.Unlock()
+
+	if opts.Output == OutputFile {
+		fmt.Printf("Logging started at %s \n", opts.Dir)
+	}
 	return nil
 }
+
+func (l *logger) write(level Level, msg string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if level < l.level {
+		return
+	}
+
+	msg = strings.TrimRight(msg, "\n ")
+	now := time.Now()
+
+	var line []byte
+	switch {
+	case l.syslog != nil:
+		if l.format == FormatJSON {
+			line, _ = json.Marshal(struct {
+				Level string `json:"level"`
+				Msg   string `json:"msg"`
+			}{level.String(), msg})
+			msg = string(line)
+		}
+		l.syslog.send(l.syslog.facility, levelPriorities[level], "", msg)
+		return
+	case l.format == FormatJSON:
+		line, _ = json.Marshal(struct {
+			Time  time.Time `json:"time"`
+			Level string    `json:"level"`
+			Msg   string    `json:"msg"`
+		}{now, level.String(), msg})
+	case l.output == OutputJournald:
+		line = []byte(fmt.Sprintf("<%d>%s", levelPriorities[level], msg))
+	default:
+		line = []byte(fmt.Sprintf("%s %-7s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg))
+	}
+	l.out.Write(append(line, '\n'))
+}
+
+func Debug(format string, a ...any) {
+	std.write(LevelDebug, fmt.Sprintf(format, a...))
+}
+
+func Info(format string, a ...any) {
+	std.write(LevelInfo, fmt.Sprintf(format, a...))
+}
+
+func Warning(format string, a ...any) {
+	std.write(LevelWarning, fmt.Sprintf(format, a...))
+}
+
+func Error(err error) {
+	std.write(LevelError, err.Error())
+}
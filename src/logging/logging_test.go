@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+/// Redirects the operational log to a buffer for one test
+func capture(t *testing.T, level Level, format string, output string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	old := std
+	std = &logger{level: level, format: format, output: output, out: buf}
+	t.Cleanup(func() {
+		std = old
+	})
+	return buf
+}
+
+func TestLevels(t *testing.T) {
+	buf := capture(t, LevelWarning, FormatText, OutputStdout)
+
+	Debug("debug message")
+	Info("info message")
+	Warning("warning message")
+	Error(os.ErrNotExist)
+
+	out := buf.String()
+	if strings.Contains(out, "debug message") || strings.Contains(out, "info message") {
+		t.Fatalf("Messages below level written: %s", out)
+	}
+	if !strings.Contains(out, "WARNING warning message") || !strings.Contains(out, "ERROR   file does not exist") {
+		t.Fatalf("Messages at or above level missing: %s", out)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	buf := capture(t, LevelInfo, FormatJSON, OutputStdout)
+
+	Info("Serving at %s\n", "localhost:8675")
+
+	var line struct {
+		Time  string
+		Level string
+		Msg   string
+	}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line.Level != "info" || line.Msg != "Serving at localhost:8675" || line.Time == "" {
+		t.Fatalf("Unexpected json log line %s", buf.String())
+	}
+}
+
+func TestJournaldFormat(t *testing.T) {
+	buf := capture(t, LevelInfo, FormatText, OutputJournald)
+
+	Warning("something odd")
+	if buf.String() != "<4>something odd\n" {
+		t.Fatalf("Unexpected journald log line `%s`", buf.String())
+	}
+}
+
+/// Tests that Start honors Dir and writes the audit log as json lines
+func TestStart(t *testing.T) {
+	capture(t, LevelInfo, FormatText, OutputStdout)
+	dir := t.TempDir()
+	auditFile := path.Join(dir, "audit", "audit.log")
+
+	err := Start(Options{
+		Level:     "debug",
+		Format:    FormatJSON,
+		Output:    OutputFile,
+		Dir:       dir,
+		MaxSize:   1,
+		Backups:   1,
+		AuditFile: auditFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Debug("written to the log dir")
+	Audit(AuditEvent{Event: AuditLoginFailure, User: "Wayne", IP: "10.0.0.1", RequestID: "abc"})
+	Audit(AuditEvent{Event: AuditLockout, User: "Wayne"})
+
+	log, err := os.ReadFile(path.Join(dir, "better_auth.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), "written to the log dir") {
+		t.Fatal("Message missing from log file")
+	}
+	if strings.Contains(string(log), AuditLoginFailure) {
+		t.Fatal("Audit event written to operational log")
+	}
+
+	audit, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(audit)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit lines, got %d", len(lines))
+	}
+
+	var e AuditEvent
+	err = json.Unmarshal([]byte(lines[0]), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Event != AuditLoginFailure || e.User != "Wayne" || e.IP != "10.0.0.1" || e.RequestID != "abc" || e.Time.IsZero() {
+		t.Fatalf("Unexpected audit event %s", lines[0])
+	}
+}
+
+/// Tests reading the latest matching events back from the audit log
+func TestRecentAudit(t *testing.T) {
+	capture(t, LevelInfo, FormatText, OutputStdout)
+	if events, err := RecentAudit(5, func(AuditEvent) bool { return true }); events != nil || err != nil {
+		t.Fatalf("Read %v, %v without an audit log", events, err)
+	}
+
+	err := Start(Options{Level: "info", Format: FormatText, Output: OutputStdout, AuditFile: path.Join(t.TempDir(), "audit.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"Wayne", "Pam", "Wayne", "Wayne", "Pam"} {
+		Audit(AuditEvent{Event: AuditLoginSuccess, User: user, Detail: strings.Repeat("x", auditTail/4)})
+	}
+	Audit(AuditEvent{Event: AuditLogout, User: "Wayne", Detail: "latest"})
+
+	// the first event is past the part of the log that is read
+	events, err := RecentAudit(5, func(e AuditEvent) bool { return e.User == "Wayne" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].Detail != "latest" || events[1].Event != AuditLoginSuccess {
+		t.Fatalf("Unexpected events %d", len(events))
+	}
+}
+
+func TestBadOptions(t *testing.T) {
+	capture(t, LevelInfo, FormatText, OutputStdout)
+	good := Options{Level: "info", Format: FormatText, Output: OutputStdout}
+
+	bad := good
+	bad.Level = "loud"
+	if Start(bad) == nil {
+		t.Fatal("Unknown level passed")
+	}
+
+	bad = good
+	bad.Format = "xml"
+	if Start(bad) == nil {
+		t.Fatal("Unknown format passed")
+	}
+
+	bad = good
+	bad.Output = "carrier pigeon"
+	if Start(bad) == nil {
+		t.Fatal("Unknown output passed")
+	}
+}
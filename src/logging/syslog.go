@@ -0,0 +1,223 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/// SyslogOptions configure OutputSyslog
+///  Network is unixgram, udp or tcp and Address the socket path or host:port
+///  Facility is used for the operational log and AuditFacility for audit
+///    events, so they can be routed to different files by the syslog daemon
+///  AppName is the APP-NAME of every message
+type SyslogOptions struct {
+	Network       string
+	Address       string
+	Facility      string
+	AuditFacility string
+	AppName       string
+}
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+func ParseFacility(s string) (int, error) {
+	f, exists := facilities[s]
+	if !exists {
+		return 0, fmt.Errorf("unknown syslog facility `%s`", s)
+	}
+	return f, nil
+}
+
+/// Severity of audit events, anything not listed is informational
+var auditSeverities = map[string]int{
+	AuditLoginFailure:   5,
+	AuditLockout:        4,
+	AuditConfigReloaded: 5,
+	AuditAccessDenied:   5,
+	AuditLoginFlagged:   4,
+	AuditNewDevice:      5,
+	AuditPasswordChange: 5,
+	AuditUserChanged:    5,
+	AuditUserDeleted:    5,
+	AuditLockoutCleared: 5,
+}
+
+/// How many messages may wait for a slow syslog daemon before new ones are
+/// dropped
+const syslogQueueSize = 1024
+
+/// How long a write to the syslog daemon may take before it is given up
+const syslogTimeout = 5 * time.Second
+
+/// syslogWriter sends RFC 5424 messages to a syslog daemon. Messages sent over
+/// tcp are framed by octet counting (RFC 6587), otherwise each message is one
+/// datagram. Messages are queued and written by a worker, so a slow or
+/// unreachable daemon never blocks the caller
+type syslogWriter struct {
+	network       string
+	address       string
+	hostname      string
+	appName       string
+	facility      int
+	auditFacility int
+	conn          net.Conn // only used by the worker once started
+	queue         chan []byte
+	closed        bool
+	lock          sync.Mutex // held to queue or close
+}
+
+func newSyslogWriter(opts SyslogOptions) (*syslogWriter, error) {
+	switch opts.Network {
+	case "unixgram", "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unknown syslog network `%s`", opts.Network)
+	}
+
+	facility, err := ParseFacility(opts.Facility)
+	if err != nil {
+		return nil, err
+	}
+	auditFacility, err := ParseFacility(opts.AuditFacility)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	w := &syslogWriter{
+		network:       opts.Network,
+		address:       opts.Address,
+		hostname:      hostname,
+		appName:       opts.AppName,
+		facility:      facility,
+		auditFacility: auditFacility,
+	}
+	if w.appName == "" {
+		w.appName = "-"
+	}
+
+	err = w.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to syslog at %s `%s`: %s", opts.Network, opts.Address, err)
+	}
+	w.start()
+	return w, nil
+}
+
+/// Starts the worker writing queued messages
+func (w *syslogWriter) start() {
+	w.queue = make(chan []byte, syslogQueueSize)
+	go w.work()
+}
+
+/// Only called by the worker, or before it is started
+func (w *syslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+	}
+	conn, err := net.DialTimeout(w.network, w.address, syslogTimeout)
+	if err != nil {
+		w.conn = nil
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+/// Queues msg with the given facility and severity. msgID may be empty.
+/// Messages are dropped, with a note on stderr, while the queue is full
+func (w *syslogWriter) send(facility int, severity int, msgID string, msg string) {
+	if msgID == "" {
+		msgID = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		facility*8+severity,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, os.Getpid(), msgID, msg)
+	if w.network == "tcp" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- []byte(line):
+	default:
+		fmt.Fprintf(os.Stderr, "syslog queue full, dropped message: %s\n", msg)
+	}
+}
+
+/// Writes queued messages until the queue is closed
+func (w *syslogWriter) work() {
+	for line := range w.queue {
+		err := w.write(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write to syslog: %s\n", err)
+		}
+	}
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+/// Writes line, retrying once on a new connection
+func (w *syslogWriter) write(line []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			err = w.connect()
+			if err != nil {
+				continue
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		_, err = w.conn.Write(line)
+		if err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+/// Stops accepting messages. Those already queued are still written
+func (w *syslogWriter) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
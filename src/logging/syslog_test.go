@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 \S+ \S+ better_auth \d+ (\S+) - (.*)$`)
+
+/// Parses a syslog message into its priority, msgid and message
+func parseSyslog(t *testing.T, line string) (int, string, string) {
+	m := rfc5424.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("Not an RFC 5424 message: `%s`", line)
+	}
+	pri, _ := strconv.Atoi(m[1])
+	return pri, m[2], m[3]
+}
+
+func startSyslog(t *testing.T, opts SyslogOptions) {
+	capture(t, LevelInfo, FormatText, OutputStdout)
+	opts.AppName = "better_auth"
+	opts.Facility = "local3"
+	opts.AuditFacility = "authpriv"
+	err := Start(Options{Level: "info", Format: FormatText, Output: OutputSyslog, Syslog: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	startSyslog(t, SyslogOptions{Network: "udp", Address: conn.LocalAddr().String()})
+
+	Warning("disk is %s", "full")
+	pri, msgID, msg := parseSyslog(t, readPacket(t, conn))
+	if pri != 19*8+4 || msgID != "-" || msg != "disk is full" {
+		t.Fatalf("Unexpected message <%d> %s %s", pri, msgID, msg)
+	}
+
+	Audit(AuditEvent{Event: AuditLockout, User: "Wayne"})
+	pri, msgID, msg = parseSyslog(t, readPacket(t, conn))
+	if pri != 10*8+4 || msgID != "audit" || !strings.Contains(msg, `"event":"lockout"`) {
+		t.Fatalf("Unexpected audit message <%d> %s %s", pri, msgID, msg)
+	}
+}
+
+func TestSyslogUnixgram(t *testing.T) {
+	sock := path.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Skipf("unix datagram sockets unavailable: %s", err)
+	}
+	defer conn.Close()
+
+	startSyslog(t, SyslogOptions{Network: "unixgram", Address: sock})
+
+	Info("hello")
+	pri, _, msg := parseSyslog(t, readPacket(t, conn))
+	if pri != 19*8+6 || msg != "hello" {
+		t.Fatalf("Unexpected message <%d> %s", pri, msg)
+	}
+}
+
+/// Tests octet-counted framing over tcp
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			var n int
+			_, err := fmt.Fscanf(r, "%d ", &n)
+			if err != nil {
+				return
+			}
+			buf := make([]byte, n)
+			_, err = io.ReadFull(r, buf)
+			if err != nil {
+				return
+			}
+			lines <- string(buf)
+		}
+	}()
+
+	startSyslog(t, SyslogOptions{Network: "tcp", Address: l.Addr().String()})
+
+	Info("first")
+	Audit(AuditEvent{Event: AuditLoginSuccess, User: "Wayne"})
+
+	for _, want := range []string{"first", `"event":"login_success"`} {
+		select {
+		case line := <-lines:
+			_, _, msg := parseSyslog(t, line)
+			if !strings.Contains(msg, want) {
+				t.Fatalf("Expected `%s` in `%s`", want, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for syslog message")
+		}
+	}
+}
+
+/// Tests that a syslog daemon that does not read never blocks logging
+func TestSyslogSlow(t *testing.T) {
+	capture(t, LevelInfo, FormatText, OutputStdout)
+	conn, stalled := net.Pipe()
+	defer stalled.Close()
+	w := &syslogWriter{network: "tcp", hostname: "-", appName: "better_auth", facility: 19, auditFacility: 10, conn: conn}
+	w.start()
+	defer w.close()
+	std.syslog = w
+
+	start := time.Now()
+	for i := 0; i < syslogQueueSize+10; i++ {
+		Info("message %d", i)
+		Audit(AuditEvent{Event: AuditLoginSuccess, User: "Wayne"})
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Logging blocked on a stalled syslog daemon")
+	}
+}
+
+func TestSyslogBadOptions(t *testing.T) {
+	capture(t, LevelInfo, FormatText, OutputStdout)
+	good := SyslogOptions{Network: "udp", Address: "127.0.0.1:514", Facility: "daemon", AuditFacility: "authpriv"}
+
+	bad := good
+	bad.Network = "carrier pigeon"
+	if Start(Options{Level: "info", Format: FormatText, Output: OutputSyslog, Syslog: bad}) == nil {
+		t.Fatal("Unknown network passed")
+	}
+
+	bad = good
+	bad.Facility = "local9"
+	if Start(Options{Level: "info", Format: FormatText, Output: OutputSyslog, Syslog: bad}) == nil {
+		t.Fatal("Unknown facility passed")
+	}
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"better_auth/config"
+	"better_auth/i18n"
+	"better_auth/invite"
 	"better_auth/logging"
+	"better_auth/pages"
 	"better_auth/pw"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/jbrodriguez/mlog"
 	"golang.org/x/term"
 )
 
@@ -18,73 +27,390 @@ func main() {
 
 	conf, err := config.Build()
 	if err != nil {
-		mlog.Error(err)
+		logging.Error(err)
 		os.Exit(1)
 	}
 
-	err = logging.Start(conf)
-	if err != nil {
-		mlog.Error(err)
+	if conf.CheckConfig != nil {
+		os.Exit(subCommandCheckConfig(conf))
+	}
+	if conf.ConfigCmd != nil {
+		os.Exit(subCommandConfig(conf))
+	}
+
+	manage := conf.ManagementCommand()
+	var problems []config.Problem
+	if manage {
+		problems = conf.ValidateManagement()
+	} else {
+		problems = conf.Validate()
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			logging.Error(p)
+		}
+		logging.Info("Run `better_auth checkconfig` after fixing `%s`", conf.ConfigFile)
 		os.Exit(1)
 	}
 
+	err = logging.Start(conf.LogOptions())
+	if err != nil {
+		if !manage {
+			logging.Error(err)
+			os.Exit(1)
+		}
+		// the log settings were not checked, which should not stop a user
+		// being managed
+		logging.Warning("Logging to stdout: %s", err)
+	}
+
 	switch {
 	case conf.AddUser != nil:
 		subCommandAddUser(conf)
+	case conf.SetEmail != nil:
+		subCommandSetEmail(conf)
+	case conf.MustChange != nil:
+		subCommandMustChange(conf)
+	case conf.SetGroups != nil:
+		subCommandSetGroups(conf)
+	case conf.InviteCmd != nil:
+		subCommandInvite(conf)
 	default:
 		s, err := NewServer(conf)
 		if err != nil {
-			mlog.Error(err)
+			logging.Error(err)
 			os.Exit(1)
 		}
 		s.StartAndBlock()
 	}
 }
 
+/// Prints every problem with the config and returns the exit code, which is
+/// non-zero if there were any
+func subCommandCheckConfig(conf *config.Config) int {
+	problems := []error{}
+	for _, p := range conf.Validate() {
+		problems = append(problems, p)
+	}
+
+	// templates, css and catalogs are only fully checked by loading them
+	if _, err := pages.New(conf.LoginPage); err != nil {
+		problems = append(problems, fmt.Errorf("LoginPage: %s", err))
+	}
+	if _, err := i18n.New(conf.Locale.Language, conf.LocaleDir()); err != nil {
+		problems = append(problems, fmt.Errorf("Locale: %s", err))
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Config `%s` is valid\n", conf.ConfigFile)
+		return 0
+	}
+
+	fmt.Printf("Config `%s` has %d problem(s):\n", conf.ConfigFile, len(problems))
+	for _, p := range problems {
+		fmt.Printf("  %s\n", p)
+	}
+	return 1
+}
+
+/// Runs `config show` or `config migrate` and returns the exit code
+func subCommandConfig(conf *config.Config) int {
+	if m := conf.ConfigCmd.Migrate; m != nil {
+		err := config.Migrate(conf.ConfigFile, m.Dest, m.Force)
+		if err != nil {
+			logging.Error(err)
+			return 1
+		}
+		fmt.Printf("Wrote `%s`. Start better_auth with `--config %s` or BETTER_AUTH_CONFIG_FILE to use it\n", m.Dest, m.Dest)
+		return 0
+	}
+	if conf.ConfigCmd.Show == nil {
+		fmt.Println("Usage: better_auth config show|migrate")
+		return 2
+	}
+
+	fmt.Printf("Config file `%s`\n\n", conf.ConfigFile)
+	err := conf.Show(os.Stdout)
+	if err != nil {
+		logging.Error(err)
+		return 1
+	}
+
+	problems := conf.Validate()
+	if len(problems) == 0 {
+		return 0
+	}
+	fmt.Printf("\n%d problem(s):\n", len(problems))
+	for _, p := range problems {
+		fmt.Printf("  %s\n", p)
+	}
+	return 1
+}
+
 func subCommandAddUser(conf *config.Config) {
 	fmt.Printf("Adding new user `%s`\n", conf.AddUser.Username)
 	pw_man, err := pw.New(conf.PasswdFile)
 	if err != nil {
-		mlog.Error(err)
+		logging.Error(err)
 		return
 	}
 
+	pw_man.SetPolicy(pw.NewPolicy(conf.PasswordPolicy))
+
+	if conf.AddUser.Email != "" {
+		err = pw.ValidateEmail(conf.AddUser.Email)
+		if err != nil {
+			logging.Error(err)
+			return
+		}
+	}
+	for _, g := range conf.AddUser.Groups {
+		err = pw.ValidateGroup(g)
+		if err != nil {
+			logging.Error(err)
+			return
+		}
+	}
+
 	if conf.AddUser.Password == "" {
 		for {
 			fmt.Printf("Enter Password for %s:", conf.AddUser.Username)
 			bytepw, err := term.ReadPassword(int(syscall.Stdin))
 			if err != nil {
-				mlog.Error(err)
+				logging.Error(err)
 				return
 			}
-			err = pw.ValidatePassword(string(bytepw))
+			fmt.Println()
+			err = pw_man.CheckPassword(conf.AddUser.Username, string(bytepw))
 			if err == nil {
 				conf.AddUser.Password = string(bytepw)
 				break
 			}
-			fmt.Println(err)
+			printPolicyError(err)
 		}
 	}
-	fmt.Println()
 
 	err = pw_man.AddUser(conf.AddUser.Username, conf.AddUser.Password)
 	if err != nil {
-		mlog.Error(err)
+		printPolicyError(err)
+		return
+	}
+	if conf.AddUser.Email != "" {
+		err = pw_man.SetEmail(conf.AddUser.Username, conf.AddUser.Email)
+		if err != nil {
+			logging.Error(err)
+			return
+		}
+	}
+	if len(conf.AddUser.Groups) > 0 {
+		err = pw_man.SetGroups(conf.AddUser.Username, conf.AddUser.Groups)
+		if err != nil {
+			logging.Error(err)
+			return
+		}
+	}
+	if conf.AddUser.Change {
+		err = pw_man.SetMustChange(conf.AddUser.Username, true)
+		if err != nil {
+			logging.Error(err)
+			return
+		}
+	}
+
+	logging.Info("User %s added to %s \n", conf.AddUser.Username, conf.PasswdFile)
+	logging.Audit(logging.AuditEvent{
+		Event:  logging.AuditUserAdded,
+		User:   conf.AddUser.Username,
+		Detail: "adduser command",
+	})
+
+	if reloadServerPasswd(conf) {
+		fmt.Printf("better_auth server updated with new user `%s`\n", conf.AddUser.Username)
+	}
+}
+
+/// Prints every rule of the password policy broken if err is a
+/// pw.PolicyError, otherwise logs err
+func printPolicyError(err error) {
+	violations, ok := err.(pw.PolicyError)
+	if !ok {
+		logging.Error(err)
+		return
+	}
+	fmt.Println("Password not allowed, it:")
+	for _, v := range violations {
+		fmt.Printf("  - %s\n", v)
+	}
+}
+
+func subCommandSetEmail(conf *config.Config) {
+	pw_man, err := pw.New(conf.PasswdFile)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+
+	err = pw_man.SetEmail(conf.SetEmail.Username, conf.SetEmail.Email)
+	if err != nil {
+		logging.Error(err)
 		return
 	}
+	if conf.SetEmail.Email == "" {
+		logging.Info("Removed email address of user %s", conf.SetEmail.Username)
+	} else {
+		logging.Info("Set email address of user %s to %s", conf.SetEmail.Username, conf.SetEmail.Email)
+	}
 
-	mlog.Info("User %s added to %s \n", conf.AddUser.Username, conf.PasswdFile)
+	if reloadServerPasswd(conf) {
+		fmt.Println("better_auth server updated")
+	}
+}
+
+func subCommandMustChange(conf *config.Config) {
+	pw_man, err := pw.New(conf.PasswdFile)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+
+	err = pw_man.SetMustChange(conf.MustChange.Username, !conf.MustChange.Clear)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+	if conf.MustChange.Clear {
+		logging.Info("User %s no longer needs to change their password", conf.MustChange.Username)
+	} else {
+		logging.Info("User %s must change their password at their next login", conf.MustChange.Username)
+	}
+
+	if reloadServerPasswd(conf) {
+		fmt.Println("better_auth server updated")
+	}
+}
+
+func subCommandSetGroups(conf *config.Config) {
+	pw_man, err := pw.New(conf.PasswdFile)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+
+	err = pw_man.SetGroups(conf.SetGroups.Username, conf.SetGroups.Groups)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+	if len(conf.SetGroups.Groups) == 0 {
+		logging.Info("Removed user %s from every group", conf.SetGroups.Username)
+	} else {
+		logging.Info("Set groups of user %s to %s", conf.SetGroups.Username, strings.Join(conf.SetGroups.Groups, ", "))
+	}
+
+	if reloadServerPasswd(conf) {
+		fmt.Println("better_auth server updated")
+	}
+}
+
+/// Prints a link for a new user to choose their own password
+func subCommandInvite(conf *config.Config) {
+	pw_man, err := pw.New(conf.PasswdFile)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+	err = pw_man.CheckUsername(conf.InviteCmd.Username)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+	for _, g := range conf.InviteCmd.Groups {
+		err = pw.ValidateGroup(g)
+		if err != nil {
+			logging.Error(err)
+			return
+		}
+	}
+
+	lifetime := time.Duration(conf.Invite.Lifetime) * time.Second
+	token, err := invite.New(conf.InvitesFile()).Create(conf.InviteCmd.Username, conf.InviteCmd.Groups, lifetime)
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+	logging.Audit(logging.AuditEvent{
+		Event:  logging.AuditUserInvited,
+		User:   conf.InviteCmd.Username,
+		Detail: "invite command",
+	})
+
+	link := strings.TrimSuffix(conf.Invite.URL, "/") + "/login/invite?token=" + token
+	fmt.Printf("Send this link to %s, it works once until %s:\n\n%s\n\n",
+		conf.InviteCmd.Username, time.Now().Add(lifetime).Format(accountTimeFormat), link)
+	if conf.Invite.URL == "" {
+		fmt.Println("Set Invite.URL to the url of the site to get a full link")
+	}
+}
+
+/// Asks the running server to reload the password file.
+/// Returns bool indicating if it did
+func reloadServerPasswd(conf *config.Config) bool {
+	if conf.TLS.ClientCAFile != "" {
+		fmt.Println("Run `systemctl reload better_auth` or send it SIGHUP to load the changes")
+		return false
+	}
 
-	addr := fmt.Sprintf("http://%s:%d/reloadpasswd", conf.Address, conf.Port)
 	fmt.Println("Attempting to update better_auth server...")
-	resp, err := http.Get(addr)
+	client, baseURL, err := localClient(conf)
+	if err != nil {
+		logging.Error(err)
+		return false
+	}
+	resp, err := client.Get(baseURL + "/reloadpasswd")
 	if err != nil {
 		fmt.Println("Could not reach better_auth server")
-		return
+		return false
 	}
 	if resp.StatusCode != 200 {
 		fmt.Printf("better_auth server responded with status %d\n", resp.StatusCode)
 	}
+	return true
+}
+
+/// Returns a client for requests to the local server and the url requests
+/// should start with. Requests go over the unix socket if Address is one.
+/// With TLS the server must present the configured certificate, which may
+/// not be valid for conf.Address
+func localClient(conf *config.Config) (*http.Client, string, error) {
+	transport := &http.Transport{}
+	scheme := "http"
+	host := fmt.Sprintf("%s:%d", conf.Address, conf.Port)
+
+	if sock, isSocket := conf.SocketPath(); isSocket {
+		host = "better_auth"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		}
+	}
+
+	if conf.TLS.Enabled() {
+		tlsConf, err := conf.TLS.Load()
+		if err != nil {
+			return nil, "", err
+		}
+		want := tlsConf.Certificates[0].Certificate[0]
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], want) {
+					return fmt.Errorf("server did not present the configured TLS certificate")
+				}
+				return nil
+			},
+		}
+	}
 
-	fmt.Printf("better_auth server updated with new user `%s`\n", conf.AddUser.Username)
+	return &http.Client{Transport: transport}, scheme + "://" + host, nil
 }
@@ -0,0 +1,287 @@
+/*
+Pages renders the html served to users. Templates are embedded in the binary
+so better_auth does not depend on its working directory, and any template may
+be replaced by a file of the same name in LoginPageConfig.TemplateDir.
+*/
+
+package pages
+
+import (
+	"better_auth/config"
+	"better_auth/logging"
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed templates/*.html
+var embedded embed.FS
+
+/// Branding holds the operator-configured look of every page
+type Branding struct {
+	Title  string
+	Logo   template.URL
+	CSS    template.CSS
+	Footer string
+	Banner string
+}
+
+/// Locale is the language a page is rendered in and its messages, which
+/// templates look up with {{index .T "message.key"}}
+type Locale struct {
+	Lang string
+	T    map[string]string
+}
+
+/// LoginData is passed to login.html
+///  Next is the path the user is sent to after logging in.
+///  Error is empty or one of the error codes the login handler responds with,
+///    and Username is refilled after a failed attempt.
+///  ForgotPassword shows a link to /login/forgot.
+type LoginData struct {
+	Branding
+	Locale
+	CSRFToken      string
+	Next           string
+	Error          string
+	Username       string
+	ForgotPassword bool
+}
+
+/// ResetData is passed to forgot.html and reset.html
+///  Token is the reset link's token, posted back with the new password.
+///  Error is empty or one of the error codes of the reset handlers.
+///  Problems are the translated rules of the password policy a new password
+///    broke.
+///  Done is set once the link is sent, or the password changed.
+type ResetData struct {
+	Branding
+	Locale
+	CSRFToken string
+	Token     string
+	Error     string
+	Problems  []string
+	Done      bool
+}
+
+/// InviteData is passed to invite.html
+///  Token is the invite link's token, posted back with the password.
+///  User is the name of the account the invite is for.
+///  Error, Problems and Done are as for ResetData.
+type InviteData struct {
+	Branding
+	Locale
+	CSRFToken string
+	Token     string
+	User      string
+	Error     string
+	Problems  []string
+	Done      bool
+}
+
+/// ChangeData is passed to change.html
+///  Next is the path the user is sent to once their password is changed.
+///  Reason is why it must be changed, pw.ChangeExpired or pw.ChangeFlagged.
+///  Error and Problems are as for ResetData.
+type ChangeData struct {
+	Branding
+	Locale
+	CSRFToken string
+	Next      string
+	Reason    string
+	Error     string
+	Problems  []string
+}
+
+/// AccountData is passed to account_password.html
+///  User is the logged in user.
+///  Error and Problems are as for ResetData, Error may also be
+///    "wrong_password" or "locked_out".
+///  Done is set once the password is changed.
+type AccountData struct {
+	Branding
+	Locale
+	CSRFToken string
+	User      string
+	Error     string
+	Problems  []string
+	Done      bool
+}
+
+/// SessionsData is passed to account.html
+///  Sessions are the user's sessions, most recently used first.
+///  Logins are the user's latest logins and failed attempts, newest first.
+///    AuditLog is false if there is no audit log to read them from.
+type SessionsData struct {
+	Branding
+	Locale
+	CSRFToken string
+	User      string
+	Sessions  []SessionInfo
+	Logins    []LoginInfo
+	AuditLog  bool
+}
+
+/// SessionInfo describes one of the user's sessions. Handle is posted back to
+/// sign it out, and Current is set for the session viewing the page
+type SessionInfo struct {
+	Handle   string
+	Device   string
+	IP       string
+	Location string
+	LastSeen string
+	Current  bool
+}
+
+/// LoginInfo is an event from the audit log. Event is its type, eg
+/// login_success
+type LoginInfo struct {
+	Time   string
+	Event  string
+	Device string
+	IP     string
+}
+
+/// AdminData is passed to admin.html
+///  Path is where the admin console is served and Admin the user viewing it.
+///  Users lists every user in PasswdFile, Audit the latest audit events.
+///    AuditLog is false if there is no audit log to read them from.
+///  Error and Problems are as for ResetData, about the form adding a user.
+type AdminData struct {
+	Branding
+	Locale
+	CSRFToken string
+	Path      string
+	Admin     string
+	Users     []AdminUser
+	Audit     []AuditInfo
+	AuditLog  bool
+	Error     string
+	Problems  []string
+}
+
+/// AdminUserData is passed to admin_user.html
+///  Sessions are the user's sessions, most recently used first.
+///  Error and Problems are as for AdminData, Done is the change last made.
+type AdminUserData struct {
+	Branding
+	Locale
+	CSRFToken string
+	Path      string
+	Admin     string
+	User      AdminUser
+	Sessions  []SessionInfo
+	Error     string
+	Problems  []string
+	Done      string
+}
+
+/// AdminUser is a user as shown in the admin console. ChangeRequired is empty
+/// or why they must change their password
+type AdminUser struct {
+	Name           string
+	Email          string
+	Groups         string
+	PasswordSet    string
+	ChangeRequired string
+	Disabled       bool
+	Locked         bool
+	Sessions       int
+}
+
+/// AuditInfo is an event from the audit log
+type AuditInfo struct {
+	Time   string
+	Event  string
+	User   string
+	IP     string
+	Detail string
+}
+
+type Pages struct {
+	templates *template.Template
+	branding  Branding
+}
+
+/// Parses the embedded templates, then any overrides in cfg.TemplateDir, and
+/// loads cfg.CustomCSS
+func New(cfg config.LoginPageConfig) (*Pages, error) {
+	tmpl, err := template.ParseFS(embedded, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TemplateDir != "" {
+		overrides, err := filepath.Glob(filepath.Join(cfg.TemplateDir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(overrides) > 0 {
+			logging.Info("Loading page templates from `%s`", cfg.TemplateDir)
+			tmpl, err = tmpl.ParseFiles(overrides...)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse templates in `%s`: %s", cfg.TemplateDir, err)
+			}
+		}
+	}
+
+	branding := Branding{
+		Title:  cfg.Title,
+		Footer: cfg.Footer,
+		Banner: cfg.Banner,
+	}
+
+	if cfg.Logo != "" {
+		if !isImageURL(cfg.Logo) {
+			return nil, fmt.Errorf("logo `%.32s` is not an http(s) url or data:image uri", cfg.Logo)
+		}
+		// html/template would otherwise reject data: uris as unsafe
+		branding.Logo = template.URL(cfg.Logo)
+	}
+
+	if cfg.CustomCSS != "" {
+		css, err := os.ReadFile(cfg.CustomCSS)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read custom css `%s`: %s", cfg.CustomCSS, err)
+		}
+		branding.CSS = template.CSS(css)
+	}
+
+	return &Pages{templates: tmpl, branding: branding}, nil
+}
+
+func isImageURL(s string) bool {
+	if strings.HasPrefix(s, "data:image/") {
+		return true
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https"
+}
+
+func (p *Pages) Branding() Branding {
+	return p.branding
+}
+
+/// Renders template name with data and writes it to w with the given status.
+/// Nothing is written if rendering fails.
+func (p *Pages) Render(w http.ResponseWriter, status int, name string, data any) error {
+	var buf bytes.Buffer
+	err := p.templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
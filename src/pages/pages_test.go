@@ -0,0 +1,103 @@
+package pages
+
+import (
+	"better_auth/config"
+	"better_auth/logging"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.LevelError)
+	m.Run()
+}
+
+func render(t *testing.T, p *Pages) string {
+	w := httptest.NewRecorder()
+	err := p.Render(w, 200, "login.html", LoginData{Branding: p.Branding(), CSRFToken: "abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Body.String()
+}
+
+/// Tests rendering the embedded login page with branding from config
+func TestBranding(t *testing.T) {
+	dir := t.TempDir()
+	css := path.Join(dir, "custom.css")
+	os.WriteFile(css, []byte("#box { background-color: #BADA55; }"), 0644)
+
+	p, err := New(config.LoginPageConfig{
+		Title:     "Duchess Login",
+		Logo:      "data:image/png;base64,iVBORw0KGgo=",
+		CustomCSS: css,
+		Footer:    "Property of ISIS",
+		Banner:    "Authorized agents only",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := render(t, p)
+	for _, want := range []string{
+		"<title>Duchess Login</title>",
+		`src="data:image/png;base64,iVBORw0KGgo="`,
+		"#BADA55",
+		"Property of ISIS",
+		"Authorized agents only",
+		`value="abc123"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("`%s` missing from rendered login page", want)
+		}
+	}
+}
+
+/// Tests that unset branding leaves out optional elements
+func TestNoBranding(t *testing.T) {
+	p, err := New(config.LoginPageConfig{Title: "Login Page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := render(t, p)
+	for _, unwanted := range []string{`id="logo"`, `id="footer"`, `id="noticeBanner"`} {
+		if strings.Contains(body, unwanted) {
+			t.Fatalf("`%s` rendered without being configured", unwanted)
+		}
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "login.html"), []byte("<p>{{.Title}} {{.CSRFToken}}</p>"), 0644)
+
+	p, err := New(config.LoginPageConfig{Title: "Custom", TemplateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := render(t, p)
+	if body != "<p>Custom abc123</p>" {
+		t.Fatalf("Unexpected override output `%s`", body)
+	}
+}
+
+func TestBadLogo(t *testing.T) {
+	for _, logo := range []string{"javascript:alert(1)", "data:text/html,<script>"} {
+		_, err := New(config.LoginPageConfig{Logo: logo})
+		if err == nil {
+			t.Fatalf("Bad logo `%s` passed validation", logo)
+		}
+	}
+}
+
+func TestMissingCSS(t *testing.T) {
+	_, err := New(config.LoginPageConfig{CustomCSS: path.Join(t.TempDir(), "missing.css")})
+	if err == nil {
+		t.Fatal("Missing custom css did not return an error")
+	}
+}
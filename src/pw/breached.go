@@ -0,0 +1,127 @@
+package pw
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/// Length of the hash prefix naming the files of a split download
+const prefixLen = 5
+
+/// Returns bool indicating if the SHA-1 hash of password is in the Have I
+/// Been Pwned download at path. Nothing is read into memory, so even the
+/// complete list can be searched
+func isBreached(path string, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return prefixFileContains(filepath.Join(path, hash[:prefixLen]+".txt"), hash[prefixLen:])
+	}
+	return sortedFileContains(path, hash)
+}
+
+/// Searches a file of SUFFIX:COUNT lines, as returned by the range api
+func prefixFileContains(path string, suffix string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// every prefix has a file, but the download may be partial
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if hashOf(scanner.Text()) == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+/// Binary searches a file of HASH:COUNT lines sorted by hash
+func sortedFileContains(path string, hash string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// lo is always the start of a line, the line starting at or after hi is
+	// known to sort after hash
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineAtOrAfter(f, mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		switch got := hashOf(line); {
+		case got == hash:
+			return true, nil
+		case got < hash:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+/// Returns the first line of f starting at or after offset, with its
+/// newline, and where it starts. The line is empty at the end of the file
+func lineAtOrAfter(f *os.File, offset int64) (int64, string, error) {
+	start := offset
+	if offset > 0 {
+		// the line starts at offset if the byte before it ends a line
+		start--
+	}
+	_, err := f.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, "", err
+	}
+	r := bufio.NewReaderSize(f, 256)
+
+	if offset > 0 {
+		skipped, err := r.ReadString('\n')
+		if err == io.EOF {
+			return start + int64(len(skipped)), "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	return start, line, nil
+}
+
+/// Returns the upper case hash of a HASH:COUNT line
+func hashOf(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
@@ -0,0 +1,195 @@
+package pw
+
+import (
+	"better_auth/config"
+	"better_auth/logging"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/// Rules of the password policy
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleMaxBytes  = "max_bytes"
+	RuleUpper     = "upper"
+	RuleLower     = "lower"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleUsername  = "username"
+	RuleEntropy   = "entropy"
+	RuleBreached  = "breached"
+	RuleReused    = "reused"
+)
+
+var ruleMessages = map[string]string{
+	RuleMinLength: "must be at least %d characters",
+	RuleMaxLength: "must be at most %d characters",
+	RuleMaxBytes:  "must be at most %d bytes",
+	RuleUpper:     "must contain an upper case letter",
+	RuleLower:     "must contain a lower case letter",
+	RuleDigit:     "must contain a digit",
+	RuleSymbol:    "must contain a symbol",
+	RuleUsername:  "must not contain the username",
+	RuleEntropy:   "is too easy to guess",
+	RuleBreached:  "has appeared in a data breach",
+	RuleReused:    "must not be one of your last %d passwords",
+}
+
+/// Violation is a rule of the policy a password breaks. N is the number in
+/// the rule, eg the minimum length, if it has one
+type Violation struct {
+	Rule string
+	N    int
+}
+
+func (v Violation) String() string {
+	msg := ruleMessages[v.Rule]
+	if strings.Contains(msg, "%d") {
+		return fmt.Sprintf(msg, v.N)
+	}
+	return msg
+}
+
+/// PolicyError lists every rule a password breaks
+type PolicyError []Violation
+
+func (e PolicyError) Error() string {
+	msgs := []string{}
+	for _, v := range e {
+		msgs = append(msgs, v.String())
+	}
+	return "password " + strings.Join(msgs, ", ")
+}
+
+/// Policy checks new passwords against PasswordPolicyConfig
+type Policy struct {
+	conf config.PasswordPolicyConfig
+}
+
+func NewPolicy(conf config.PasswordPolicyConfig) *Policy {
+	return &Policy{conf: conf}
+}
+
+/// Returns the rules password breaks as a new password for username. Reuse
+/// of earlier passwords is checked by PWManager.CheckPassword
+func (p *Policy) Check(username string, password string) []Violation {
+	c := p.conf
+	found := []Violation{}
+
+	length := utf8.RuneCountInString(password)
+	if length < c.MinLength {
+		found = append(found, Violation{Rule: RuleMinLength, N: c.MinLength})
+	}
+	if length > c.MaxLength {
+		found = append(found, Violation{Rule: RuleMaxLength, N: c.MaxLength})
+	} else if len(password) > config.MaxPasswordBytes {
+		found = append(found, Violation{Rule: RuleMaxBytes, N: config.MaxPasswordBytes})
+	}
+
+	classes := characterClasses(password)
+	for _, req := range []struct {
+		required bool
+		class    int
+		rule     string
+	}{
+		{c.RequireUpper, classUpper, RuleUpper},
+		{c.RequireLower, classLower, RuleLower},
+		{c.RequireDigit, classDigit, RuleDigit},
+		{c.RequireSymbol, classSymbol, RuleSymbol},
+	} {
+		if req.required && classes&req.class == 0 {
+			found = append(found, Violation{Rule: req.rule})
+		}
+	}
+
+	if c.DisallowUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		found = append(found, Violation{Rule: RuleUsername})
+	}
+	if c.MinEntropy > 0 && Entropy(password) < float64(c.MinEntropy) {
+		found = append(found, Violation{Rule: RuleEntropy, N: c.MinEntropy})
+	}
+
+	if c.BreachedFile != "" {
+		breached, err := isBreached(c.BreachedFile, password)
+		if err != nil {
+			logging.Error(fmt.Errorf("unable to check breached passwords: %s", err))
+		}
+		if breached {
+			found = append(found, Violation{Rule: RuleBreached})
+		}
+	}
+	return found
+}
+
+const (
+	classLower = 1 << iota
+	classUpper
+	classDigit
+	classSymbol
+	classOther // letters and digits outside ascii
+)
+
+/// Characters in each class, for estimating entropy
+var classSizes = map[int]float64{
+	classLower:  26,
+	classUpper:  26,
+	classDigit:  10,
+	classSymbol: 33,
+	classOther:  100,
+}
+
+func characterClasses(password string) int {
+	classes := 0
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			classes |= classLower
+		case r >= 'A' && r <= 'Z':
+			classes |= classUpper
+		case r >= '0' && r <= '9':
+			classes |= classDigit
+		case r > unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			classes |= classOther
+			if unicode.IsUpper(r) {
+				classes |= classUpper
+			} else if unicode.IsLower(r) {
+				classes |= classLower
+			}
+		default:
+			classes |= classSymbol
+		}
+	}
+	return classes
+}
+
+/// Returns a rough estimate of the bits of entropy of password: its length
+/// times the bits per character of the classes it uses. Characters repeating
+/// or following on from the previous one, as in `aaaa` or `1234`, are not
+/// counted
+func Entropy(password string) float64 {
+	pool := 0.0
+	classes := characterClasses(password)
+	for class, size := range classSizes {
+		if classes&class != 0 {
+			pool += size
+		}
+	}
+
+	length := 0
+	prev := rune(-10)
+	for _, r := range password {
+		if r != prev && r != prev+1 && r != prev-1 {
+			length++
+		}
+		prev = r
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(pool)
+}
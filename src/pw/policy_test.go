@@ -0,0 +1,164 @@
+package pw
+
+import (
+	"better_auth/config"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func rules(violations []Violation) string {
+	names := []string{}
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestPolicy(t *testing.T) {
+	p := NewPolicy(config.PasswordPolicyConfig{
+		MinLength:        8,
+		MaxLength:        12,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowUsername: true,
+		MinEntropy:       40,
+	})
+
+	for _, c := range []struct {
+		password string
+		want     string
+	}{
+		{"Tr0mb0n3!", ""},
+		{"Ünïcødé9!", ""},
+		{"short", "min_length,upper,digit,symbol,entropy"},
+		{"much_too_long_password", "max_length,upper,digit"},
+		{"ALL-CAPS-42", "lower"},
+		{"xXJohnWayneXx1!", "max_length,username"},
+		{"Aa1!aaaaaaaa", "entropy"},
+		{"Ab1!bcdefgh", "entropy"},
+	} {
+		got := rules(p.Check("JohnWayne", c.password))
+		if got != c.want {
+			t.Fatalf("`%s` broke rules [%s], expected [%s]", c.password, got, c.want)
+		}
+	}
+
+	// bcrypt ignores anything after 72 bytes
+	p = NewPolicy(config.PasswordPolicyConfig{MinLength: 1, MaxLength: 72})
+	if got := rules(p.Check("", strings.Repeat("ü", 40))); got != RuleMaxBytes {
+		t.Fatalf("80 byte password broke rules [%s]", got)
+	}
+}
+
+func TestPolicyError(t *testing.T) {
+	err := PolicyError{{Rule: RuleMinLength, N: 8}, {Rule: RuleDigit}}
+	if err.Error() != "password must be at least 8 characters, must contain a digit" {
+		t.Fatalf("unexpected message `%s`", err)
+	}
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestBreached(t *testing.T) {
+	breached := []string{"password1", "letmein", "hunter22", "trustno1", "correct horse battery staple"}
+	hashes := []string{}
+	for i := 0; i < 500; i++ {
+		hashes = append(hashes, sha1Hex(strings.Repeat("x", i)))
+	}
+	for _, pw := range breached {
+		hashes = append(hashes, sha1Hex(pw))
+	}
+	sort.Strings(hashes)
+
+	dir := t.TempDir()
+	sorted := path.Join(dir, "pwned-passwords-sha1-ordered-by-hash.txt")
+	lines := []string{}
+	for i, h := range hashes {
+		lines = append(lines, h+":"+strings.Repeat("9", i%7+1))
+	}
+	os.WriteFile(sorted, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0644)
+
+	prefixes := path.Join(dir, "ranges")
+	os.Mkdir(prefixes, 0755)
+	for _, h := range hashes {
+		f, _ := os.OpenFile(path.Join(prefixes, h[:5]+".txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f.WriteString(h[5:] + ":3\r\n")
+		f.Close()
+	}
+
+	for _, file := range []string{sorted, prefixes} {
+		p := NewPolicy(config.PasswordPolicyConfig{MinLength: 1, MaxLength: 72, BreachedFile: file})
+		for _, pw := range breached {
+			if rules(p.Check("", pw)) != RuleBreached {
+				t.Fatalf("breached password `%s` not found in %s", pw, file)
+			}
+		}
+		for _, pw := range []string{"Tr0mb0n3!", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx!"} {
+			if got := rules(p.Check("", pw)); got != "" {
+				t.Fatalf("password `%s` broke rules [%s] with %s", pw, got, file)
+			}
+		}
+	}
+
+	// the first and last lines are found too
+	for _, h := range []string{hashes[0], hashes[len(hashes)-1]} {
+		found, err := sortedFileContains(sorted, h)
+		if err != nil || !found {
+			t.Fatalf("hash %s not found: %v", h, err)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.pw")
+	c, _ := New(f)
+	c.SetPolicy(NewPolicy(config.PasswordPolicyConfig{MinLength: 8, MaxLength: 64, History: 3}))
+	c.AddUser("JohnWayne", "first_password")
+
+	for _, pw := range []string{"second_password", "third_password"} {
+		err := c.SetPassword("JohnWayne", pw)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// history survives reading the file again
+	c, _ = New(f)
+	c.SetPolicy(NewPolicy(config.PasswordPolicyConfig{MinLength: 8, MaxLength: 64, History: 3}))
+	for _, pw := range []string{"first_password", "second_password", "third_password"} {
+		err := c.SetPassword("JohnWayne", pw)
+		if rules(err.(PolicyError)) != RuleReused {
+			t.Fatalf("reused password `%s` gave %v", pw, err)
+		}
+	}
+	err := c.SetPassword("JohnWayne", "fourth_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the last 3 are remembered
+	err = c.SetPassword("JohnWayne", "first_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// history is forgotten once the policy no longer needs it
+	c.SetPolicy(NewPolicy(config.PasswordPolicyConfig{MinLength: 8, MaxLength: 64}))
+	err = c.SetPassword("JohnWayne", "fifth_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(f)
+	if strings.Contains(string(data), "history=") {
+		t.Fatalf("history kept without a policy needing it: %s", data)
+	}
+}
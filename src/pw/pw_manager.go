@@ -2,37 +2,100 @@
 PW files are stored on disk with each user/password on its own line like:
 
 clint_eastwood:some_bcrypted_pass
-john_wayne:another_bcrypted_pass
+john_wayne:another_bcrypted_pass:email=duke@example.com:history=old_pass,older_pass
+ethan_edwards:a_bcrypted_pass:set=1651406400:expires=1667260800:change=true
+rooster_cogburn:a_bcrypted_pass:groups=admin,marshals:disabled=true
+
+Optional fields follow the password as key=value, so files written before a
+field existed are still read and older versions can be pointed at the error.
+Times are unix timestamps: `set` is when the password was set, `expires` when
+it must be changed and `change` requires a change at the next login. `groups`
+lists the groups a user is in and `disabled` stops them logging in.
 */
 
 package pw
 
 import (
+	"better_auth/config"
 	"better_auth/files"
+	"better_auth/logging"
 	"bufio"
 	"errors"
 	"fmt"
+	"net/mail"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/jbrodriguez/mlog"
 	"golang.org/x/crypto/bcrypt"
 )
 
+type user struct {
+	hash     []byte
+	email    string
+	history  [][]byte  // earlier hashes, newest first
+	set      time.Time // zero if unknown
+	expires  time.Time // zero unless set for this user
+	change   bool
+	groups   []string
+	disabled bool
+}
+
+/// Returns the line of the pw file for user name
+func (u *user) line(name string) string {
+	fields := []string{name, string(u.hash)}
+	if u.email != "" {
+		fields = append(fields, "email="+u.email)
+	}
+	if len(u.history) > 0 {
+		hashes := []string{}
+		for _, h := range u.history {
+			hashes = append(hashes, string(h))
+		}
+		fields = append(fields, "history="+strings.Join(hashes, ","))
+	}
+	if !u.set.IsZero() {
+		fields = append(fields, "set="+strconv.FormatInt(u.set.Unix(), 10))
+	}
+	if !u.expires.IsZero() {
+		fields = append(fields, "expires="+strconv.FormatInt(u.expires.Unix(), 10))
+	}
+	if u.change {
+		fields = append(fields, "change=true")
+	}
+	if len(u.groups) > 0 {
+		fields = append(fields, "groups="+strings.Join(u.groups, ","))
+	}
+	if u.disabled {
+		fields = append(fields, "disabled=true")
+	}
+	return strings.Join(fields, ":") + "\n"
+}
+
 type PWManager struct {
-	users map[string][]byte // username: hashed pw
-	file  string
-	lock  sync.Mutex
+	users  map[string]*user
+	names  []string // in file order
+	file   string
+	policy *Policy
+	lock   sync.Mutex
 }
 
 /// Creates new PWManager from data in filePath. If filePath does not exist a
 /// new empty better_auth.pw will be created.
 func New(filePath string) (*PWManager, error) {
-	pwMan := &PWManager{users: make(map[string][]byte), file: filePath, lock: sync.Mutex{}}
+	pwMan := &PWManager{
+		users:  make(map[string]*user),
+		file:   filePath,
+		policy: NewPolicy(config.Default().PasswordPolicy),
+		lock:   sync.Mutex{},
+	}
 
 	if !files.FileExists(filePath) {
-		mlog.Info("Creating new password file `%s`", filePath)
+		logging.Info("Creating new password file `%s`", filePath)
 		f, err := os.Create(filePath)
 		if err != nil {
 			return nil, fmt.Errorf("unable to create password file `%s`: %s", filePath, err)
@@ -44,50 +107,109 @@ func New(filePath string) (*PWManager, error) {
 }
 
 func (a *PWManager) parseAuthFile(filePath string) error {
-	mlog.Info("Reading password file from %s", filePath)
+	logging.Info("Reading password file from %s", filePath)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.load()
+}
 
-	file, err := os.OpenFile(filePath, os.O_RDONLY, 0000)
+/// Replaces the users in memory with those in the pw file, so changes are
+/// made to what is on disk rather than what was last read. Otherwise a
+/// change would undo edits made to the file since, such as users removed by
+/// deleting their line. Must be called with the lock held
+func (a *PWManager) load() error {
+	file, err := os.Open(a.file)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	users := make(map[string]*user)
+	names := []string{}
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
-
-	a.lock.Lock()
-	defer a.lock.Unlock()
 	line := 0
 	for scanner.Scan() {
 		line++
-		parts := strings.Split(scanner.Text(), ":")
-		if len(parts) != 2 {
-			err = fmt.Errorf("invalid entry on line %d of %s", line, filePath)
-			return err
+		name, u, err := parseLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("invalid entry on line %d of %s: %s", line, a.file, err)
 		}
-		a.users[parts[0]] = []byte(parts[1])
+		if _, exists := users[name]; !exists {
+			names = append(names, name)
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
+	a.users = users
+	a.names = names
 	return nil
 }
 
-func (a *PWManager) Reload() error {
-	a.lock.Lock()
-	for k := range a.users {
-		delete(a.users, k)
+func parseLine(text string) (string, *user, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) < 2 {
+		return "", nil, errors.New("expected username:password")
 	}
-	a.lock.Unlock()
+
+	u := &user{hash: []byte(parts[1])}
+	for _, field := range parts[2:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return "", nil, fmt.Errorf("expected key=value, got `%s`", field)
+		}
+		switch key {
+		case "email":
+			u.email = value
+		case "history":
+			for _, h := range strings.Split(value, ",") {
+				u.history = append(u.history, []byte(h))
+			}
+		case "set", "expires":
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("`%s` must be a unix timestamp, got `%s`", key, value)
+			}
+			if key == "set" {
+				u.set = time.Unix(secs, 0)
+			} else {
+				u.expires = time.Unix(secs, 0)
+			}
+		case "change", "disabled":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("`%s` must be true or false, got `%s`", key, value)
+			}
+			if key == "change" {
+				u.change = b
+			} else {
+				u.disabled = b
+			}
+		case "groups":
+			u.groups = strings.Split(value, ",")
+		default:
+			return "", nil, fmt.Errorf("unknown field `%s`, was the file written by a newer version?", key)
+		}
+	}
+	return parts[0], u, nil
+}
+
+func (a *PWManager) Reload() error {
 	return a.parseAuthFile(a.file)
 }
 
 /// Adds user to file and in-memory cache
 func (a *PWManager) AddUser(username string, password string) error {
-	mlog.Info("Adding user `%s` to password file `%s`", username, a.file)
-	err := a.validateUsername(username)
+	logging.Info("Adding user `%s` to password file `%s`", username, a.file)
+	err := a.CheckUsername(username)
 	if err != nil {
 		return err
 	}
 
-	err = ValidatePassword(password)
+	err = a.CheckPassword(username, password)
 	if err != nil {
 		return err
 	}
@@ -97,47 +219,320 @@ func (a *PWManager) AddUser(username string, password string) error {
 		return err
 	}
 
-	err = a.writeUserToFile(username, hashedPassword)
+	return a.modify(func() error {
+		// the user may have been added to the file while hashing
+		err := a.checkUsername(username)
+		if err != nil {
+			return err
+		}
+		a.users[username] = &user{hash: hashedPassword, set: time.Now()}
+		a.names = append(a.names, username)
+		return nil
+	})
+}
+
+/// Rereads the pw file, applies change to it and saves it. If change fails
+/// or the file cannot be saved, memory is left as the file was read
+func (a *PWManager) modify(change func() error) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	err := a.load()
 	if err != nil {
 		return err
 	}
+
+	err = change()
+	if err == nil {
+		err = a.save()
+	}
+	if err != nil {
+		if loadErr := a.load(); loadErr != nil {
+			logging.Error(loadErr)
+		}
+	}
+	return err
+}
+
+/// Rewrites the whole pw file from memory. Must be called with the lock held
+func (a *PWManager) save() error {
+	var b strings.Builder
+	for _, name := range a.names {
+		b.WriteString(a.users[name].line(name))
+	}
+	return files.WriteAtomic(a.file, []byte(b.String()), 0644)
+}
+
+/// Verifies that the username exists, is not disabled and the password
+/// matches the loaded password file
+func (a *PWManager) Verify(username string, password string) bool {
+	a.lock.Lock()
+	u, userExists := a.users[username]
+	a.lock.Unlock()
+	if !userExists || u.disabled {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(u.hash, []byte(password)) == nil
+}
+
+/// Returns bool indicating if username is in the password file
+func (a *PWManager) Exists(username string) bool {
 	a.lock.Lock()
 	defer a.lock.Unlock()
-	a.users[username] = hashedPassword
-	return nil
+	_, exists := a.users[username]
+	return exists
+}
+
+/// Sets the policy new passwords are checked against
+func (a *PWManager) SetPolicy(policy *Policy) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.policy = policy
 }
 
-/// Adds user and password to pw file on disk
-func (a *PWManager) writeUserToFile(username string, hashedPassword []byte) error {
+/// Checks password against the policy as a new password for username,
+/// including whether they used it recently.
+/// Returns a PolicyError listing every rule broken, or nil
+func (a *PWManager) CheckPassword(username string, password string) error {
+	a.lock.Lock()
+	policy := a.policy
+	recent := [][]byte{}
+	if u, exists := a.users[username]; exists && policy.conf.History > 0 {
+		recent = append(recent, u.hash)
+		recent = append(recent, u.history[:min(len(u.history), policy.conf.History-1)]...)
+	}
+	a.lock.Unlock()
 
-	f, err := files.MkDirsAndOpen(a.file, os.O_APPEND|os.O_WRONLY, 0644)
+	found := policy.Check(username, password)
+	for _, hash := range recent {
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			found = append(found, Violation{Rule: RuleReused, N: policy.conf.History})
+			break
+		}
+	}
+	if len(found) > 0 {
+		return PolicyError(found)
+	}
+	return nil
+}
+
+/// Checks password against the policy, then hashes and saves it as username's
+/// new password
+func (a *PWManager) SetPassword(username string, password string) error {
+	if !a.Exists(username) {
+		return fmt.Errorf("user `%s` does not exist", username)
+	}
+	err := a.CheckPassword(username, password)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	_, err = f.Write([]byte(username + ":" + string(hashedPassword) + "\n"))
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return a.update(username, func(u *user) {
+		// only as many earlier hashes as the policy checks are kept
+		keep := a.policy.conf.History - 1
+		history := u.history
+		u.history = nil
+		if keep > 0 {
+			u.history = append([][]byte{u.hash}, history...)
+			u.history = u.history[:min(len(u.history), keep)]
+		}
+		u.hash = hashedPassword
+		u.set = time.Now()
+		u.expires = time.Time{}
+		u.change = false
+	})
 }
 
-/// Verifies that the username exists and the password matches the loaded password file
-func (a *PWManager) Verify(username string, password string) bool {
-	hashedPass, userExists := a.users[username]
-	if !userExists {
+/// Reasons a user must change their password before logging in
+const (
+	ChangeFlagged = "must_change"
+	ChangeExpired = "expired"
+)
+
+/// Returns why username must change their password before they may log in,
+/// ChangeFlagged or ChangeExpired, or an empty string if they need not.
+/// Passwords set before their date was recorded only expire if the user has
+/// an expiry of their own
+func (a *PWManager) ChangeRequired(username string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u, exists := a.users[username]
+	if !exists {
+		return ""
+	}
+	if u.change {
+		return ChangeFlagged
+	}
+
+	expires := u.expires
+	if maxAge := a.policy.conf.MaxAge; expires.IsZero() && maxAge > 0 && !u.set.IsZero() {
+		expires = u.set.AddDate(0, 0, maxAge)
+	}
+	if !expires.IsZero() && !time.Now().Before(expires) {
+		return ChangeExpired
+	}
+	return ""
+}
+
+/// Sets whether username must change their password at their next login
+func (a *PWManager) SetMustChange(username string, change bool) error {
+	return a.update(username, func(u *user) { u.change = change })
+}
+
+/// Sets when the password of username expires, overriding
+/// PasswordPolicy.MaxAge until it is changed. A zero time removes the expiry
+func (a *PWManager) SetExpiry(username string, expires time.Time) error {
+	return a.update(username, func(u *user) { u.expires = expires })
+}
+
+/// Applies change to username and saves the pw file
+func (a *PWManager) update(username string, change func(u *user)) error {
+	return a.modify(func() error {
+		u, exists := a.users[username]
+		if !exists {
+			return fmt.Errorf("user `%s` does not exist", username)
+		}
+		change(u)
+		return nil
+	})
+}
+
+/// Returns the email address of username, or an empty string if they have none
+func (a *PWManager) Email(username string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u, exists := a.users[username]
+	if !exists {
+		return ""
+	}
+	return u.email
+}
+
+/// Returns the user whose email address is email, ignoring case, or an empty
+/// string if there is none
+func (a *PWManager) FindByEmail(email string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, name := range a.names {
+		if a.users[name].email != "" && strings.EqualFold(a.users[name].email, email) {
+			return name
+		}
+	}
+	return ""
+}
+
+/// Sets the email address of username. An empty email removes it
+func (a *PWManager) SetEmail(username string, email string) error {
+	if email != "" {
+		err := ValidateEmail(email)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.update(username, func(u *user) { u.email = email })
+}
+
+/// Returns the users in the password file, in file order
+func (a *PWManager) Users() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]string{}, a.names...)
+}
+
+/// UserInfo is what the password file records about a user, other than their
+/// password. PasswordSet is zero if unknown
+type UserInfo struct {
+	Email       string
+	Groups      []string
+	Disabled    bool
+	PasswordSet time.Time
+}
+
+/// Returns what is known about username, and bool indicating if they exist
+func (a *PWManager) Info(username string) (UserInfo, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u, exists := a.users[username]
+	if !exists {
+		return UserInfo{}, false
+	}
+	return UserInfo{
+		Email:       u.email,
+		Groups:      append([]string{}, u.groups...),
+		Disabled:    u.disabled,
+		PasswordSet: u.set,
+	}, true
+}
+
+/// Returns bool indicating if username is in group
+func (a *PWManager) InGroup(username string, group string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u, exists := a.users[username]
+	if !exists {
 		return false
 	}
-	return bcrypt.CompareHashAndPassword(hashedPass, []byte(password)) == nil
+	for _, g := range u.groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+/// Sets the groups username is in, replacing any they were in before
+func (a *PWManager) SetGroups(username string, groups []string) error {
+	for _, g := range groups {
+		err := ValidateGroup(g)
+		if err != nil {
+			return err
+		}
+	}
+	return a.update(username, func(u *user) { u.groups = append([]string{}, groups...) })
+}
+
+/// Sets whether username is disabled. Disabled users keep their password but
+/// Verify refuses it
+func (a *PWManager) SetDisabled(username string, disabled bool) error {
+	return a.update(username, func(u *user) { u.disabled = disabled })
+}
+
+/// Removes username from the pw file
+func (a *PWManager) RemoveUser(username string) error {
+	return a.modify(func() error {
+		if _, exists := a.users[username]; !exists {
+			return fmt.Errorf("user `%s` does not exist", username)
+		}
+		names := []string{}
+		for _, name := range a.names {
+			if name != username {
+				names = append(names, name)
+			}
+		}
+		a.names = names
+		delete(a.users, username)
+		return nil
+	})
 }
 
 func hashPassword(password string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 }
 
-func (a *PWManager) validateUsername(username string) error {
+/// Checks that username could be added, so it is not taken and can be stored
+/// in the pw file
+func (a *PWManager) CheckUsername(username string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.checkUsername(username)
+}
+
+/// CheckUsername without the lock. Must be called with the lock held
+func (a *PWManager) checkUsername(username string) error {
 	_, found := a.users[username]
 	if found {
 		return fmt.Errorf("username `%s` already exists in password file `%s`", username, a.file)
@@ -154,12 +549,39 @@ func (a *PWManager) validateUsername(username string) error {
 	if strings.Contains(username, ":") {
 		return errors.New("illegal character `:` in username")
 	}
+
+	// each user is one line of the pw file, so line breaks could add fields
+	// or whole users to it
+	if !utf8.ValidString(username) || strings.IndexFunc(username, isSpaceOrControl) != -1 {
+		return fmt.Errorf("username %q may not contain spaces, line breaks or control characters", username)
+	}
 	return nil
 }
 
-func ValidatePassword(password string) error {
-	if len(password) < 8 {
-		return errors.New("password too short (min 8 characters)")
+func isSpaceOrControl(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+/// Checks that email is a plain address such as name@example.com
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || strings.ContainsAny(email, ":") {
+		return fmt.Errorf("`%s` is not an email address such as name@example.com", email)
 	}
 	return nil
 }
+
+/// Checks that group is a name that can be stored in the pw file
+func ValidateGroup(group string) error {
+	if group == "" || strings.ContainsAny(group, ":,=") || strings.IndexFunc(group, isSpaceOrControl) != -1 {
+		return fmt.Errorf("%q is not a group name, which may not be empty or contain `:`, `,`, `=`, spaces or control characters", group)
+	}
+	return nil
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
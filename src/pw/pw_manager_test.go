@@ -1,15 +1,18 @@
 package pw
 
 import (
+	"better_auth/config"
+	"better_auth/logging"
+	"fmt"
 	"os"
 	"path"
+	"strings"
 	"testing"
-
-	"github.com/jbrodriguez/mlog"
+	"time"
 )
 
 func TestMain(m *testing.M) {
-	mlog.Start(mlog.LevelError, "")
+	logging.SetLevel(logging.LevelError)
 	m.Run()
 }
 
@@ -94,7 +97,7 @@ func TestBadUsernames(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	for _, name := range []string{"an_invalid:user_name", "", "JohnWayne", "1234567890_1234567890_1234567890_1234567890_1234567890_1234567890_123456789"} {
+	for _, name := range []string{"an_invalid:user_name", "", "JohnWayne", "1234567890_1234567890_1234567890_1234567890_1234567890_1234567890_123456789", "two\nlines", "Mallory\nEve:$2a$10$hash", "car\rriage", "tab\tbed", "with space", "nul\x00"} {
 		err = c.AddUser(name, "a_valid_password")
 		if err == nil {
 			t.Fatalf("Bad username `%s` passed validation", name)
@@ -137,4 +140,214 @@ func TestBadPWFile(t *testing.T) {
 	if err == nil {
 		t.Fatal("invalid entry [2] passed pw parser")
 	}
+
+	os.WriteFile(f, []byte("not_a:valid:color=blue"), 0644)
+
+	_, err = New(f)
+	if err == nil {
+		t.Fatal("unknown field passed pw parser")
+	}
+}
+
+/// Tests that email addresses are saved with users and survive other changes
+func TestEmail(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.pw")
+	c, _ := New(f)
+	c.AddUser("JohnWayne", "19IwoJima49")
+	c.AddUser("ClintEastwood", "make_my_day")
+
+	err := c.SetEmail("JohnWayne", "duke@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"not an email", "Duke <duke@example.com>", "duke:@example.com"} {
+		if c.SetEmail("JohnWayne", bad) == nil {
+			t.Fatalf("invalid email `%s` accepted", bad)
+		}
+	}
+	if c.SetEmail("JamesStewart", "jimmy@example.com") == nil {
+		t.Fatal("set email of user that does not exist")
+	}
+
+	err = c.SetPassword("JohnWayne", "true_grit_69")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Email("JohnWayne") != "duke@example.com" {
+		t.Fatalf("incorrect email `%s` read from pw file", c.Email("JohnWayne"))
+	}
+	if c.FindByEmail("Duke@Example.com") != "JohnWayne" {
+		t.Fatal("user not found by email")
+	}
+	if c.Email("ClintEastwood") != "" || c.FindByEmail("") != "" {
+		t.Fatal("user without email has one")
+	}
+	if !c.Verify("JohnWayne", "true_grit_69") || c.Verify("JohnWayne", "19IwoJima49") {
+		t.Fatal("new password not saved")
+	}
+	if !c.Verify("ClintEastwood", "make_my_day") {
+		t.Fatal("other user's password lost when rewriting pw file")
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.pw")
+	os.WriteFile(f, nil, 0600)
+	c, _ := New(f)
+	c.AddUser("JohnWayne", "19IwoJima49")
+
+	if c.SetPassword("JohnWayne", "short") == nil {
+		t.Fatal("invalid password accepted")
+	}
+	if c.SetPassword("JamesStewart", "its_a_wonderful_life") == nil {
+		t.Fatal("set password of user that does not exist")
+	}
+	err := c.SetPassword("JohnWayne", "true_grit_69")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("pw file mode changed to %s", info.Mode())
+	}
+}
+
+/// Tests the must change flag and password expiry, and reading files
+/// written before passwords had dates
+func TestChangeRequired(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.pw")
+	hash, _ := hashPassword("19IwoJima49")
+	old := time.Now().AddDate(0, 0, -100).Unix()
+	os.WriteFile(f, []byte(fmt.Sprintf("JohnWayne:%s\nClintEastwood:%s:set=%d\n", hash, hash, old)), 0644)
+
+	c, err := New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ChangeRequired("JohnWayne") != "" || c.ChangeRequired("ClintEastwood") != "" {
+		t.Fatal("password expired without MaxAge")
+	}
+
+	c.SetPolicy(NewPolicy(config.PasswordPolicyConfig{MinLength: 8, MaxLength: 64, MaxAge: 90}))
+	if c.ChangeRequired("JohnWayne") != "" {
+		t.Fatal("password without a date expired")
+	}
+	if c.ChangeRequired("ClintEastwood") != ChangeExpired {
+		t.Fatal("100 day old password did not expire")
+	}
+
+	c.SetExpiry("JohnWayne", time.Now().Add(-time.Minute))
+	c.SetMustChange("ClintEastwood", true)
+	c, _ = New(f)
+	if c.ChangeRequired("JohnWayne") != ChangeExpired {
+		t.Fatal("password past its expiry did not expire")
+	}
+	if c.ChangeRequired("ClintEastwood") != ChangeFlagged {
+		t.Fatal("flagged password need not change")
+	}
+
+	for _, user := range []string{"JohnWayne", "ClintEastwood"} {
+		err = c.SetPassword(user, "a_new_password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ChangeRequired(user) != "" {
+			t.Fatalf("%s must still change their new password", user)
+		}
+	}
+}
+
+/// Tests groups, disabling and removing users
+func TestManageUsers(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.pw")
+	c, _ := New(f)
+	c.AddUser("JohnWayne", "19IwoJima49")
+	c.AddUser("ClintEastwood", "make_my_day")
+	c.AddUser("JamesStewart", "its_a_wonderful_life")
+
+	err := c.SetGroups("JohnWayne", []string{"admin", "marshals"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"", "a,b", "a:b", "a b", "a\nb", "a\x7fb"} {
+		if c.SetGroups("JohnWayne", []string{bad}) == nil {
+			t.Fatalf("invalid group `%s` accepted", bad)
+		}
+	}
+	err = c.SetDisabled("ClintEastwood", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.RemoveUser("JamesStewart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoveUser("JamesStewart") == nil {
+		t.Fatal("removed user that does not exist")
+	}
+
+	c, err = New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := strings.Join(c.Users(), ","); users != "JohnWayne,ClintEastwood" {
+		t.Fatalf("unexpected users %s", users)
+	}
+	if !c.InGroup("JohnWayne", "admin") || c.InGroup("ClintEastwood", "admin") {
+		t.Fatal("groups not saved")
+	}
+	if c.Verify("ClintEastwood", "make_my_day") {
+		t.Fatal("disabled user verified")
+	}
+	if info, _ := c.Info("ClintEastwood"); !info.Disabled || info.PasswordSet.IsZero() {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	c.SetDisabled("ClintEastwood", false)
+	if !c.Verify("ClintEastwood", "make_my_day") {
+		t.Fatal("enabled user not verified")
+	}
+}
+
+/// Tests that changes are made to the pw file as it is on disk, keeping edits
+/// made by others since it was read
+func TestConcurrentEdits(t *testing.T) {
+	f := path.Join(t.TempDir(), "better_auth.pw")
+	server, _ := New(f)
+	server.AddUser("JohnWayne", "19IwoJima49")
+	server.AddUser("ClintEastwood", "make_my_day")
+
+	// a user removed by deleting their line, and one added by the cli
+	lines, _ := os.ReadFile(f)
+	os.WriteFile(f, []byte(strings.SplitAfter(string(lines), "\n")[0]), 0644)
+	cli, _ := New(f)
+	err := cli.AddUser("JamesStewart", "its_a_wonderful_life")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.SetEmail("JohnWayne", "duke@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.AddUser("JamesStewart", "another_password") == nil {
+		t.Fatal("added a user that was added to the file since it was read")
+	}
+
+	c, _ := New(f)
+	if users := strings.Join(c.Users(), ","); users != "JohnWayne,JamesStewart" {
+		t.Fatalf("unexpected users %s", users)
+	}
+	if c.Email("JohnWayne") != "duke@example.com" || !c.Verify("JamesStewart", "its_a_wonderful_life") {
+		t.Fatal("change not saved")
+	}
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"better_auth/access"
+	"better_auth/clientip"
+	"better_auth/config"
+	"better_auth/email"
+	"better_auth/geoip"
+	"better_auth/i18n"
+	"better_auth/logging"
+	"better_auth/pages"
+	"better_auth/pw"
+	"better_auth/webhook"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/// Settings, or groups of settings ending in ".", that a config reload applies
+/// to the running server. Changes to anything else need a restart
+var liveSettings = []string{
+	"SessionTimeout",
+	"TrustedProxies",
+	"Rules",
+	"Webhooks",
+	"LogLevel",
+	"Lockout.",
+	"BasicAuth.",
+	"LoginPage.",
+	"Locale.",
+	"TLS.",
+	"GeoIP.",
+	"WebhookDelivery.",
+	"SMTP.",
+	"PasswordReset.",
+	"PasswordPolicy.",
+	"Invite.URL",
+	"Invite.Lifetime",
+	"Admin.Group",
+}
+
+func isLiveSetting(field string) bool {
+	for _, s := range liveSettings {
+		if field == s || (strings.HasSuffix(s, ".") && strings.HasPrefix(field, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+/// liveConfig holds the parts of the server that are replaced as a whole when
+/// the config is reloaded
+type liveConfig struct {
+	conf  *config.Config
+	pages *pages.Pages
+	i18n  *i18n.Bundle
+	tls   *tls.Config // nil unless TLS is enabled
+	ips   *clientip.Resolver
+	rules *access.Rules
+	geo   *geoip.DB
+	mail  *email.Sender
+
+	webhooks *webhook.Dispatcher
+}
+
+func newLiveConfig(cfg *config.Config) (*liveConfig, error) {
+	pg, err := pages.New(cfg.LoginPage)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := i18n.New(cfg.Locale.Language, cfg.LocaleDir())
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+	ips, err := clientip.New(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := access.New(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	geo, err := geoip.Open(cfg.GeoIP.Databases)
+	if err != nil {
+		return nil, err
+	}
+	// started last so nothing else can fail with its workers running
+	hooks, err := webhook.New(cfg.Webhooks, cfg.WebhookDelivery)
+	if err != nil {
+		return nil, err
+	}
+	return &liveConfig{conf: cfg, pages: pg, i18n: bundle, tls: tlsConf, ips: ips, rules: rules, geo: geo,
+		mail: email.New(cfg.SMTP), webhooks: hooks}, nil
+}
+
+/// Returns the current live config. Handlers should call this once and use
+/// the result throughout so a reload cannot change settings mid-request
+func (s *Server) live() *liveConfig {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.current
+}
+
+/// Returns the listener's tls.Config, which hands every handshake the TLS
+/// settings of the live config so reloaded certificates are used immediately
+func (s *Server) serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.live().tls, nil
+		},
+		// only checked for, as GetConfigForClient's config is used instead
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.live().tls.Certificates[0], nil
+		},
+	}
+}
+
+/// reloadResult lists the settings changed by a reload
+type reloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+/// Re-builds the config from the config file, environment and flags and
+/// applies it. Nothing is applied if the new config has problems
+func (s *Server) reloadConfig() (*reloadResult, error) {
+	build := s.build
+	if build == nil {
+		build = config.Build
+	}
+	conf, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	problems := conf.Validate()
+	if len(problems) > 0 {
+		msgs := []string{}
+		for _, p := range problems {
+			msgs = append(msgs, p.Error())
+		}
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return s.applyConfig(conf)
+}
+
+/// Applies the live settings of conf. Login page templates, css, catalogs, TLS
+/// certificates and GeoIP databases are re-read even if their settings did not change.
+/// Settings that need a restart are compared to the config the server started
+/// with, so they are reported by every reload until the server is restarted.
+/// Turning TLS on or off needs a restart too, until then the listener keeps
+/// its current TLS settings
+func (s *Server) applyConfig(conf *config.Config) (*reloadResult, error) {
+	live, err := newLiveConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tlsToggled := conf.TLS.Enabled() != s.startConf.TLS.Enabled()
+	needsRestart := func(field string) bool {
+		return !isLiveSetting(field) || (tlsToggled && strings.HasPrefix(field, "TLS."))
+	}
+	if tlsToggled {
+		live.tls = s.current.tls
+	}
+
+	result := &reloadResult{Applied: []string{}, RestartRequired: []string{}}
+	for _, field := range config.Diff(s.current.conf, conf) {
+		if !needsRestart(field) {
+			result.Applied = append(result.Applied, field)
+		}
+	}
+	for _, field := range config.Diff(s.startConf, conf) {
+		if needsRestart(field) {
+			result.RestartRequired = append(result.RestartRequired, field)
+		}
+	}
+
+	// events queued for the old webhooks are still sent, ones requests still
+	// holding the old live config send after this are dead lettered
+	s.current.webhooks.Close()
+	s.current = live
+	s.sessionStore.SetLifetime(conf.SessionTimeout)
+	s.resetStore.SetLifetime(conf.PasswordReset.Lifetime)
+	s.pwManager.SetPolicy(pw.NewPolicy(conf.PasswordPolicy))
+	s.lockout.SetLimits(conf.Lockout.MaxAttempts, conf.Lockout.Window, conf.Lockout.Duration)
+	for _, field := range result.Applied {
+		if strings.HasPrefix(field, "BasicAuth.") {
+			s.basicCache.setLifetime(conf.BasicAuth.CacheTTL)
+			break
+		}
+	}
+	level, _ := logging.ParseLevel(conf.LogLevel)
+	logging.SetLevel(level)
+
+	return result, nil
+}
+
+/// Reloads the config and the password file and logs the outcome
+func (s *Server) reloadAndLog() (*reloadResult, error) {
+	s.basicCache.clear()
+	err := s.pwManager.Reload()
+	if err != nil {
+		logging.Error(fmt.Errorf("password file not reloaded: %s", err))
+	}
+
+	result, err := s.reloadConfig()
+	if err != nil {
+		logging.Error(fmt.Errorf("config not reloaded, keeping the running config: %s", err))
+		return nil, err
+	}
+
+	detail := fmt.Sprintf("applied: %s", strings.Join(result.Applied, ", "))
+	logging.Info("Config reloaded, %s", detail)
+	if len(result.RestartRequired) > 0 {
+		logging.Warning("Restart better_auth to apply changes to: %s", strings.Join(result.RestartRequired, ", "))
+		detail += fmt.Sprintf("; restart required: %s", strings.Join(result.RestartRequired, ", "))
+	}
+	s.auditEvent(logging.AuditEvent{Time: time.Now(), Event: logging.AuditConfigReloaded, Detail: detail})
+	return result, nil
+}
+
+func (s *Server) reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		logging.Info("SIGHUP received, reloading config")
+		s.reloadAndLog()
+	}
+}
+
+/// POST reloads the config, like SIGHUP
+///  Returns {"applied": [...], "restart_required": [...]} listing changed
+///    settings, or 400 and {"error": "..."} if the config was not reloaded
+func (s *Server) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(405)
+		return
+	}
+
+	result, err := s.reloadAndLog()
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, result)
+}
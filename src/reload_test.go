@@ -0,0 +1,146 @@
+package main
+
+import (
+	"better_auth/config"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/// Returns a valid config with every path inside a temporary dir
+func reloadableConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.ConfigFile = path.Join(dir, "better_auth.conf")
+	cfg.PasswdFile = path.Join(dir, "better_auth.pw")
+	cfg.LogDir = path.Join(dir, "logs")
+	cfg.AuditFile = ""
+	cfg.WebhookDelivery.DeadLetterFile = ""
+	cfg.LogLevel = "error"
+	return cfg
+}
+
+func postReload(t *testing.T, addr string) (int, map[string]any) {
+	resp, err := http.Post(addr+"reloadconfig", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := make(map[string]any)
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+/// Tests that reloading applies live settings without ending sessions, and
+/// reports settings that need a restart
+func TestReloadConfig(t *testing.T) {
+	const TESTUSER string = "Pam"
+	const TESTPASS string = "snowball_fight_9"
+	cfg := reloadableConfig(t)
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	srv, addr := newTestServer(t, cfg)
+	next := *cfg
+	srv.build = func() (*config.Config, error) {
+		c := next
+		return &c, nil
+	}
+
+	client, session, _ := loginAs(t, addr, TESTUSER, TESTPASS, nil)
+	if session == nil {
+		t.Fatal("Login failed")
+	}
+
+	next.SessionTimeout = 7200
+	next.LoginPage.Banner = "Authorized use only"
+	next.BasicAuth.Enabled = true
+	next.Admin.Group = "supervisors"
+	next.Port = 9000
+
+	status, body := postReload(t, addr)
+	if status != 200 {
+		t.Fatalf("Unexpected status code %d, %v", status, body)
+	}
+	applied := []any{"SessionTimeout", "BasicAuth.Enabled", "LoginPage.Banner", "Admin.Group"}
+	if !reflect.DeepEqual(body["applied"], applied) || !reflect.DeepEqual(body["restart_required"], []any{"Port"}) {
+		t.Fatalf("Unexpected reload result %v", body)
+	}
+
+	resp, err := client.Get(addr + "authrequest")
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal("Session ended by reload")
+	}
+
+	resp, _ = client.Get(addr + "login")
+	page, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), "Authorized use only") {
+		t.Fatal("Banner not applied")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, addr+"authrequest", nil)
+	req.SetBasicAuth(TESTUSER, TESTPASS)
+	resp, _ = http.DefaultClient.Do(req)
+	if resp.StatusCode != 200 {
+		t.Fatal("Basic auth not enabled by reload")
+	}
+
+	// unchanged settings are not reported again, but the port still needs a restart
+	status, body = postReload(t, addr)
+	if status != 200 || len(body["applied"].([]any)) != 0 || !reflect.DeepEqual(body["restart_required"], []any{"Port"}) {
+		t.Fatalf("Unexpected reload result %d %v", status, body)
+	}
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	cfg := reloadableConfig(t)
+	srv, addr := newTestServer(t, cfg)
+	srv.build = func() (*config.Config, error) {
+		c := *cfg
+		c.SessionTimeout = -1
+		c.LoginPage.Banner = "Authorized use only"
+		return &c, nil
+	}
+
+	status, body := postReload(t, addr)
+	if status != 400 || !strings.Contains(body["error"].(string), "SessionTimeout") {
+		t.Fatalf("Unexpected reload result %d %v", status, body)
+	}
+	if srv.live().conf != cfg {
+		t.Fatal("Invalid config partially applied")
+	}
+
+	resp, _ := http.Get(addr + "reloadconfig")
+	if resp.StatusCode != 405 {
+		t.Fatalf("Unexpected status code %d for GET", resp.StatusCode)
+	}
+}
+
+/// Tests that reloads are refused to clients that are not on this host
+func TestReloadLocalOnly(t *testing.T) {
+	srv, err := NewServer(reloadableConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path, remote string
+		want         int
+	}{
+		{"/reloadconfig", "203.0.113.5:40000", 403},
+		{"/reloadpasswd", "[2001:db8::1]:40000", 403},
+		{"/reloadpasswd", "127.0.0.1:40000", 200},
+		{"/reloadpasswd", "[::1]:40000", 200},
+		{"/reloadpasswd", "@", 200},
+	} {
+		req := httptest.NewRequest(http.MethodPost, c.path, nil)
+		req.RemoteAddr = c.remote
+		rec := httptest.NewRecorder()
+		srv.handler().ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Fatalf("Unexpected status code %d for %s from %s", rec.Code, c.path, c.remote)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"better_auth/logging"
+	"better_auth/pages"
+	"better_auth/pw"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/// Name of the store holding password reset tokens. They are sent by email,
+/// never set as cookies
+const RESET_TOKEN string = "better_auth_reset_token"
+
+const (
+	resetErrInvalid  = "invalid_token"
+	resetErrMismatch = "mismatch"
+	resetErrWeak     = "weak_password"
+)
+
+/// Asks for a password reset link to be emailed, if PasswordReset is enabled
+/// GET returns the form asking for a username or email address
+/// POST emails a link to /login/reset to the user, if they exist and have an
+///   email address. The response is the same either way, so it cannot be
+///   used to find out who has an account. Requests for a user are limited
+///   like failed logins, and each new link replaces the previous one
+///  If the csrf token isn't valid returns 403
+func (s *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !s.live().conf.PasswordReset.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderReset(w, r, "forgot.html", 200, pages.ResetData{})
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			s.renderReset(w, r, "forgot.html", 403, pages.ResetData{Error: loginErrExpired})
+			return
+		}
+
+		name := strings.TrimSpace(r.FormValue("username"))
+		user := name
+		if !s.pwManager.Exists(user) {
+			user = s.pwManager.FindByEmail(name)
+		}
+		s.sendResetLink(w, r, name, user)
+		s.renderReset(w, r, "forgot.html", 200, pages.ResetData{Done: true})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Emails a reset link to user, who was asked for as name
+func (s *Server) sendResetLink(w http.ResponseWriter, r *http.Request, name string, user string) {
+	addr := s.pwManager.Email(user)
+	if user == "" || addr == "" {
+		s.audit(r, logging.AuditResetRequested, name, "no such user or no email address")
+		return
+	}
+	if s.lockout.IsLocked("reset:" + user) {
+		s.audit(r, logging.AuditResetRequested, user, "too many requests")
+		return
+	}
+	s.lockout.Fail("reset:" + user)
+
+	s.resetStore.RemoveUser(user)
+	token, err := s.resetStore.NewUserToken(user, s.clientIP(r))
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+	s.audit(r, logging.AuditResetRequested, user, "")
+
+	conf := s.live().conf.PasswordReset
+	t := s.locale(w, r).T
+	link := strings.TrimSuffix(conf.URL, "/") + "/login/reset?token=" + token.ID()
+	body := strings.NewReplacer(
+		"{user}", user,
+		"{link}", link,
+		"{minutes}", strconv.Itoa((conf.Lifetime+59)/60),
+	).Replace(t["reset.mail_body"])
+	s.sendMail(addr, t["reset.mail_subject"], body)
+}
+
+/// Lets the holder of a reset link choose a new password
+/// GET returns the form for the new password
+/// POST sets the new password, ends every session of the user and uses up
+///   the link. Returns 400 and the form again if the passwords do not match
+///   or are not allowed
+///  Returns 400 for both methods if the link is invalid or has expired, and
+///    403 if the csrf token isn't valid
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	if !s.live().conf.PasswordReset.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	// keep the token out of the Referer of anything the page links to
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	token := r.FormValue("token")
+	user := s.resetStore.User(token)
+	if user == "" {
+		s.renderReset(w, r, "reset.html", 400, pages.ResetData{Error: resetErrInvalid})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.renderReset(w, r, "reset.html", 200, pages.ResetData{Token: token})
+	case http.MethodPost:
+		if !s.validCSRF(r) {
+			s.renderReset(w, r, "reset.html", 403, pages.ResetData{Token: token, Error: loginErrExpired})
+			return
+		}
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			s.renderReset(w, r, "reset.html", 400, pages.ResetData{Token: token, Error: resetErrMismatch})
+			return
+		}
+		err := s.pwManager.CheckPassword(user, password)
+		if err != nil {
+			data := pages.ResetData{Token: token, Error: resetErrWeak, Problems: s.policyProblems(w, r, err)}
+			s.renderReset(w, r, "reset.html", 400, data)
+			return
+		}
+
+		// only one request can remove the token
+		if !s.resetStore.Remove(token) {
+			s.renderReset(w, r, "reset.html", 400, pages.ResetData{Error: resetErrInvalid})
+			return
+		}
+		err = s.pwManager.SetPassword(user, password)
+		if err != nil {
+			logging.Error(err)
+			w.WriteHeader(500)
+			return
+		}
+		s.sessionStore.RemoveUser(user)
+		s.passwordChanged(w, r, user, "reset link")
+		s.renderReset(w, r, "reset.html", 200, pages.ResetData{Done: true})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(405)
+	}
+}
+
+/// Audits the change of user's password and forgets anything verified with
+/// the old one. Sessions are left to the caller. If PasswordReset.Notify is
+/// set the user is emailed about the change
+func (s *Server) passwordChanged(w http.ResponseWriter, r *http.Request, user string, detail string) {
+	s.basicCache.clear()
+	s.lockout.Reset(user)
+	s.audit(r, logging.AuditPasswordChange, user, detail)
+
+	live := s.live()
+	addr := s.pwManager.Email(user)
+	if !live.conf.PasswordReset.Notify || !live.mail.Enabled() || addr == "" {
+		return
+	}
+	t := s.locale(w, r).T
+	body := strings.NewReplacer("{user}", user).Replace(t["reset.changed_body"])
+	s.sendMail(addr, t["reset.changed_subject"], body)
+}
+
+/// Returns the translated rules of the password policy a new password broke,
+/// as listed by err if it is a pw.PolicyError
+func (s *Server) policyProblems(w http.ResponseWriter, r *http.Request, err error) []string {
+	violations, ok := err.(pw.PolicyError)
+	if !ok {
+		logging.Error(err)
+		return nil
+	}
+	t := s.locale(w, r).T
+	problems := []string{}
+	for _, v := range violations {
+		problems = append(problems, strings.ReplaceAll(t["policy."+v.Rule], "{n}", strconv.Itoa(v.N)))
+	}
+	return problems
+}
+
+/// Sends an email in the background, so how long the mail server takes
+/// cannot be timed to tell whether a user exists
+func (s *Server) sendMail(to string, subject string, body string) {
+	sender := s.live().mail
+	go func() {
+		err := sender.Send(to, subject, body)
+		if err != nil {
+			logging.Error(fmt.Errorf("unable to email %s: %s", to, err))
+		}
+	}()
+}
+
+/// Renders forgot.html or reset.html with status
+func (s *Server) renderReset(w http.ResponseWriter, r *http.Request, name string, status int, data pages.ResetData) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	live := s.live()
+	data.Branding = live.pages.Branding()
+	data.Locale = s.locale(w, r)
+	data.CSRFToken = csrf
+	err = live.pages.Render(w, status, name, data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
@@ -1,25 +1,48 @@
 package main
 
 import (
+	"better_auth/access"
 	"better_auth/config"
+	"better_auth/geoip"
+	"better_auth/invite"
+	"better_auth/lockout"
+	"better_auth/logging"
+	"better_auth/pages"
 	"better_auth/pw"
 	"better_auth/token_store"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-
-	"github.com/jbrodriguez/mlog"
+	"strings"
+	"sync"
+	"time"
 )
 
 const CSRF_TOKEN string = "csrf_token"
 const SESSION_TOKEN string = "better_auth_session_token"
+const REQUEST_ID_HEADER string = "X-Request-ID"
 
 type Server struct {
 	//addr         string
 	pwManager    *pw.PWManager
 	csrfStore    *token_store.TokenStore
 	sessionStore *token_store.TokenStore
-	addr         string
+	resetStore   *token_store.TokenStore
+	invites      *invite.Store
+	lockout      *lockout.Tracker
+	basicCache   *basicAuthCache
+	logins       *geoip.History
+	devices      *deviceTracker
+
+	startConf *config.Config // config the server was started with
+	current   *liveConfig    // replaced by reloadConfig
+	lock      sync.RWMutex
+	build     func() (*config.Config, error) // config.Build unless testing
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
@@ -27,95 +50,467 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	pwm.SetPolicy(pw.NewPolicy(cfg.PasswordPolicy))
+	live, err := newLiveConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &Server{
 		pwManager:    pwm,
-		csrfStore:    token_store.New(CSRF_TOKEN, 15*60),
-		sessionStore: token_store.New(SESSION_TOKEN, cfg.SessionTimeout),
-		addr:         fmt.Sprintf("%s:%d", cfg.Address, cfg.Port),
+		csrfStore:    token_store.New(CSRF_TOKEN, token_store.KindCSRF, 15*60),
+		sessionStore: token_store.New(SESSION_TOKEN, token_store.KindSession, cfg.SessionTimeout),
+		resetStore:   token_store.New(RESET_TOKEN, token_store.KindReset, cfg.PasswordReset.Lifetime),
+		invites:      invite.New(cfg.InvitesFile()),
+		lockout:      lockout.New(cfg.Lockout.MaxAttempts, cfg.Lockout.Window, cfg.Lockout.Duration),
+		basicCache:   newBasicAuthCache(cfg.BasicAuth.CacheTTL),
+		logins:       geoip.NewHistory(),
+		devices:      newDeviceTracker(),
+		startConf:    cfg,
+		current:      live,
 	}, nil
 }
 
 func (s *Server) StartAndBlock() {
+	l, err := listen(s.startConf)
+	if err != nil {
+		logging.Error(err)
+		os.Exit(1)
+	}
+
+	go s.reloadOnSIGHUP()
+	srv := &http.Server{Handler: s.handler()}
+
+	if s.startConf.TLS.Enabled() {
+		logging.Info("Serving https at %s\n", l.Addr())
+		srv.TLSConfig = s.serverTLSConfig()
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		logging.Info("Serving at %s\n", l.Addr())
+		err = srv.Serve(l)
+	}
+
+	if err != nil && err.Error() != "http: Server closed" {
+		logging.Error(err)
+		os.Exit(1)
+	}
+}
+
+func (s *Server) handler() http.Handler {
 	m := http.NewServeMux()
-	m.HandleFunc("/reloadpasswd", s.reloadPasswd)
+	m.HandleFunc("/reloadpasswd", localOnly(s.reloadPasswd))
+	m.HandleFunc("/reloadconfig", localOnly(s.reloadConfigHandler))
 	m.HandleFunc("/authrequest", s.authrequest)
 	m.HandleFunc("/login", s.login)
-	mlog.Info("Serving at %s\n", s.addr)
-	err := http.ListenAndServe(s.addr, m)
+	m.HandleFunc("/login/forgot", s.forgotPassword)
+	m.HandleFunc("/login/reset", s.resetPassword)
+	m.HandleFunc("/login/change", s.changePassword)
+	m.HandleFunc("/login/invite", s.acceptInvite)
+	m.HandleFunc("/logout", s.logout)
+	m.HandleFunc("/account", s.account)
+	m.HandleFunc("/account/password", s.accountPassword)
+	if admin := s.startConf.Admin; admin.Enabled {
+		m.HandleFunc(admin.Path, s.adminUsers)
+		m.HandleFunc(admin.Path+"/user", s.adminUser)
+	}
+	return withRequestID(m)
+}
 
-	if err != nil && err.Error() != "http: Server closed" {
-		mlog.Error(err)
-		os.Exit(1)
+/// Makes sure every request has an X-Request-ID header so its log and audit
+/// entries can be matched up with nginx's. nginx's id is used if it sent one
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if len(id) > 64 {
+			id = id[:64]
+		}
+		if id == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		r.Header.Set(REQUEST_ID_HEADER, id)
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+/// Returns the ip address of the client that sent r, looking through
+/// trusted proxies
+func (s *Server) clientIP(r *http.Request) string {
+	return s.live().ips.IP(r)
+}
+
+/// Checks the access rules for the original request nginx is asking about.
+/// Decisions to allow or deny are audited, `auth` is the usual login flow
+func (s *Server) checkAccess(r *http.Request) access.Decision {
+	host := r.Header.Get("X-Original-Host")
+	if host == "" {
+		host = r.Host
+	}
+	uri := r.Header.Get("X-Original-URI")
+	if uri == "" {
+		uri = r.URL.Path
+	}
+	path := access.CleanPath(uri)
+
+	d := s.live().rules.Check(s.clientIP(r), host, path)
+	switch d.Action {
+	case config.ActionAllow:
+		s.audit(r, logging.AuditAccessAllowed, "", fmt.Sprintf("%s %s%s", d, host, path))
+	case config.ActionDeny:
+		s.audit(r, logging.AuditAccessDenied, "", fmt.Sprintf("%s %s%s", d, host, path))
 	}
+	return d
+}
+
+/// Writes event about r to the audit log
+func (s *Server) audit(r *http.Request, event string, user string, detail string) {
+	s.auditEvent(logging.AuditEvent{
+		Time:      time.Now(),
+		Event:     event,
+		User:      user,
+		IP:        s.clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: r.Header.Get(REQUEST_ID_HEADER),
+		Detail:    detail,
+	})
+}
+
+/// Writes e to the audit log and sends it to webhooks
+func (s *Server) auditEvent(e logging.AuditEvent) {
+	logging.Audit(e)
+	s.live().webhooks.Send(e)
 }
 
-/// GET returns login page html
-/// POST verfies user/password.
-///  If name/password aren't valid returns 511. This isn't 100% proper, but makes
-///    more sense than putting an error in the body and parsing it at the client
-///  If successful starts new session and assigns a cookie to the client.
+const (
+	loginErrInvalid = "invalid_login"
+	loginErrExpired = "expired"
+	loginErrLocked  = "locked_out"
+	loginErrDenied  = "denied"
+	loginErrUnusual = "unusual_login"
+)
+
+/// GET returns login page html with a csrf token in both a cookie and the form
+/// POST verfies user/password. Plain form posts are answered with html, posts
+///   sent with `Accept: application/json` (the login page's javascript) with json
+///  If the csrf token isn't valid or the form token doesn't match the cookie
+///    returns 403
+///  If name/password aren't valid returns 401
+///  If the user is locked out after too many failures returns 429
+///  If an access rule denies the client returns 403 for both methods
+///  If GeoIP flags the login as unusual and blocks it returns 403
+///  If the user's password has expired or must be changed the session is
+///    restricted to /login/change, where they are sent instead
+///  Failed form posts re-render the login page with the matching error banner,
+///    json posts get {"error": "<code>"}
+///  If successful starts new session and assigns a cookie to the client, then
+///    redirects form posts with a 303 or returns {"redirect": "<path>"} as json
 ///  If an error occurred generating the ID a 500 is returned
 func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if s.checkAccess(r).Action == config.ActionDeny {
+		s.loginFailed(w, r, 403, loginErrDenied)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		csrfCookie, err := r.Cookie(CSRF_TOKEN)
-		if err != nil {
-			token, err := s.csrfStore.NewToken()
-			if err != nil {
-				mlog.Error(err)
-				w.WriteHeader(500)
-				return
-			}
-			http.SetCookie(w, token.ToCookie())
-		}
-
-		if err != nil {
-
-		} else {
-			s.csrfStore.IsValid(csrfCookie.Value)
+		if id, _ := r.Cookie(SESSION_TOKEN); id != nil && s.sessionStore.IsRestricted(id.Value) {
+			http.Redirect(w, r, changePasswordURL(loginRedirect(r)), http.StatusSeeOther)
+			return
 		}
-		http.ServeFile(w, r, "./static/login.html")
+		s.renderLogin(w, r, 200, "")
 		return
 	case http.MethodPost:
-		csrfCookie, err := r.Cookie(CSRF_TOKEN)
-		if err != nil || !s.csrfStore.IsValid(csrfCookie.Value) {
-			w.WriteHeader(511)
+		if !s.validCSRF(r) {
+			s.loginFailed(w, r, 403, loginErrExpired)
 			return
 		}
 
 		usr := r.FormValue("username")
 		pwd := r.FormValue("password")
-		mlog.Info("Login attempt for user %s from %s", usr, r.RemoteAddr)
+		logging.Debug("Login attempt for user %s from %s", usr, s.clientIP(r))
+
+		if s.lockout.IsLocked(usr) {
+			s.audit(r, logging.AuditLoginFailure, usr, "locked out")
+			s.loginFailed(w, r, 429, loginErrLocked)
+			return
+		}
 
 		if s.pwManager.Verify(usr, pwd) {
-			token, err := s.sessionStore.NewToken()
+			loc, ok := s.checkLoginLocation(r, usr)
+			if !ok {
+				s.loginFailed(w, r, 403, loginErrUnusual)
+				return
+			}
+
+			s.lockout.Reset(usr)
+			token, err := s.sessionStore.NewUserToken(usr, s.clientIP(r))
 			if err != nil {
-				mlog.Error(err)
+				logging.Error(err)
 				w.WriteHeader(500)
 				return
 			}
+			s.sessionStore.SetUserAgent(token.ID(), r.UserAgent())
+			details := []string{}
+			if loc != nil {
+				s.sessionStore.SetLocation(token.ID(), loc.Country, loc.ASN)
+				details = append(details, loc.String())
+			}
+			next := loginRedirect(r)
+			if reason := s.pwManager.ChangeRequired(usr); reason != "" {
+				s.sessionStore.SetRestricted(token.ID(), true)
+				details = append(details, "password change required: "+reason)
+				next = changePasswordURL(next)
+			}
 
-			mlog.Info("Login attempt successful for user %s from %s", usr, r.RemoteAddr)
+			s.audit(r, logging.AuditLoginSuccess, usr, strings.Join(details, "; "))
+			if s.loginDevice(w, r, usr) {
+				s.audit(r, logging.AuditNewDevice, usr, r.UserAgent())
+			}
 			http.SetCookie(w, token.ToCookie())
-			w.WriteHeader(200)
+			s.loginSucceeded(w, r, next)
 			return
 		}
-		mlog.Info("Login attempt failed for user %s from %s", usr, r.RemoteAddr)
-		w.WriteHeader(401)
+		s.audit(r, logging.AuditLoginFailure, usr, "invalid username or password")
+		if s.lockout.Fail(usr) {
+			s.audit(r, logging.AuditLockout, usr, "")
+		}
+		s.loginFailed(w, r, 401, loginErrInvalid)
 		return
 	}
 }
 
+/// Looks up where a login by user with the correct password comes from and
+/// compares it to their earlier logins. Unusual logins are audited, and ok is
+/// false if GeoIP.Action blocks them. loc is nil unless GeoIP is enabled
+func (s *Server) checkLoginLocation(r *http.Request, user string) (loc *geoip.Location, ok bool) {
+	live := s.live()
+	if !live.geo.Enabled() {
+		return nil, true
+	}
+
+	geo := live.conf.GeoIP
+	found := live.geo.Lookup(s.clientIP(r))
+	now := time.Now()
+	reasons := s.logins.Check(user, found, now, geoip.Limits{
+		NewCountry:       geo.NewCountry,
+		ImpossibleTravel: geo.ImpossibleTravel,
+		MaxSpeed:         geo.MaxSpeed,
+	})
+	if len(reasons) > 0 {
+		detail := fmt.Sprintf("%s: %s", found, strings.Join(reasons, "; "))
+		if geo.Action == config.GeoIPBlock {
+			s.audit(r, logging.AuditLoginFlagged, user, detail+", blocked")
+			return &found, false
+		}
+		s.audit(r, logging.AuditLoginFlagged, user, detail)
+	}
+	s.logins.Record(user, found, now)
+	return &found, true
+}
+
+/// Renders the login page with status and an optional error code
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, status int, errCode string) {
+	csrf, err := s.csrfToken(w, r)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+
+	live := s.live()
+	data := pages.LoginData{
+		Branding:  live.pages.Branding(),
+		Locale:    s.locale(w, r),
+		CSRFToken: csrf,
+		Next:      loginRedirect(r),
+		Error:     errCode,
+
+		ForgotPassword: live.conf.PasswordReset.Enabled,
+	}
+	if errCode == loginErrInvalid {
+		data.Username = r.FormValue("username")
+	}
+
+	err = live.pages.Render(w, status, "login.html", data)
+	if err != nil {
+		logging.Error(err)
+		w.WriteHeader(500)
+	}
+}
+
+/// Returns the language and messages to render a page for r in
+func (s *Server) locale(w http.ResponseWriter, r *http.Request) pages.Locale {
+	w.Header().Add("Vary", "Accept-Language")
+	lang, t := s.live().i18n.ForRequest(r)
+	return pages.Locale{Lang: lang, T: t}
+}
+
+func (s *Server) loginFailed(w http.ResponseWriter, r *http.Request, status int, errCode string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, map[string]string{"error": errCode})
+		return
+	}
+	s.renderLogin(w, r, status, errCode)
+}
+
+/// Sends the client to next after logging in
+func (s *Server) loginSucceeded(w http.ResponseWriter, r *http.Request, next string) {
+	if wantsJSON(r) {
+		writeJSON(w, 200, map[string]string{"redirect": next})
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+/// Returns the local path a user should be sent to after logging in. This is
+/// the `next` form field if posted, otherwise the uri nginx originally
+/// received. Anything that isn't a path on this site becomes "/"
+func loginRedirect(r *http.Request) string {
+	next := r.PostFormValue("next")
+	if next == "" {
+		next = r.Header.Get("X-Original-URI")
+	}
+	return localPath(next)
+}
+
+/// Returns next if it is a path on this site outside of /login, otherwise "/"
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.HasPrefix(next, "/\\") || strings.HasPrefix(next, "/login") {
+		return "/"
+	}
+	return next
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logging.Error(err)
+	}
+}
+
+/// Ends the client's session, if any, and redirects to "/"
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	id, _ := r.Cookie(SESSION_TOKEN)
+	if id != nil {
+		usr := s.sessionStore.User(id.Value)
+		if s.sessionStore.Remove(id.Value) {
+			s.audit(r, logging.AuditLogout, usr, "")
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_TOKEN,
+		Value:    "",
+		MaxAge:   -1,
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Path:     "/",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+/// Returns the client's csrf token if it is still valid, otherwise creates a
+/// new one and sets it as a cookie on w
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	csrfCookie, err := r.Cookie(CSRF_TOKEN)
+	if err == nil && s.csrfStore.IsValid(csrfCookie.Value) {
+		return csrfCookie.Value, nil
+	}
+
+	token, err := s.csrfStore.NewToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, token.ToCookie())
+	return token.ID(), nil
+}
+
+/// Returns bool indicating if the csrf token posted with r is valid and
+/// matches its cookie
+func (s *Server) validCSRF(r *http.Request) bool {
+	csrfCookie, err := r.Cookie(CSRF_TOKEN)
+	return err == nil && s.csrfStore.IsValid(csrfCookie.Value) &&
+		subtle.ConstantTimeCompare([]byte(r.FormValue(CSRF_TOKEN)), []byte(csrfCookie.Value)) == 1
+}
+
 /// Handles auth subrequest from nginx
+///  Access rules are checked first. Allowed clients need no session and denied
+///    clients get 403, which nginx passes on instead of showing the login page
+///  A valid session cookie is always accepted, unless it is restricted to
+///    changing an expired password. If Basic auth is enabled for the
+///    original location the Authorization header is checked as a fallback
 func (s *Server) authrequest(w http.ResponseWriter, r *http.Request) {
+	switch s.checkAccess(r).Action {
+	case config.ActionAllow:
+		return
+	case config.ActionDeny:
+		w.WriteHeader(403)
+		return
+	}
+
 	id, _ := r.Cookie(SESSION_TOKEN)
-	if id != nil && s.sessionStore.IsValid(id.Value) {
+	if id != nil && s.sessionStore.IsValid(id.Value) && !s.sessionStore.IsRestricted(id.Value) {
 		return
 	}
+
+	if s.basicAuthAllowed(r) {
+		if s.verifyBasicAuth(r) {
+			return
+		}
+		if s.live().conf.BasicAuth.Challenge {
+			s.setBasicAuthChallenge(w)
+		}
+	}
 	w.WriteHeader(401)
 }
 
+/// Wraps handler so it only answers clients on the same host, or clients
+/// that presented a certificate signed by TLS.ClientCAFile. Others get 403
+func localOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isLocalPeer(r) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			logging.Warning("Refused %s from %s, which is not on this host", r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(403)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+/// Returns bool indicating if the client connected over the unix socket, over
+/// loopback or from the address it connected to, as the cli does when
+/// Address is one of the host's own addresses. Proxy headers are ignored
+func isLocalPeer(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// unix socket peers have no host and port
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	localHost, _, err := net.SplitHostPort(local.String())
+	return err == nil && net.ParseIP(localHost).Equal(ip)
+}
+
 func (s *Server) reloadPasswd(w http.ResponseWriter, r *http.Request) {
+	s.basicCache.clear()
 	if s.pwManager.Reload() != nil {
 		w.WriteHeader(500)
 	}
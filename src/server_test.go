@@ -2,21 +2,30 @@ package main
 
 import (
 	"better_auth/config"
+	"better_auth/email/emailtest"
+	"better_auth/geoip/geoiptest"
+	"better_auth/invite"
+	"better_auth/logging"
 	"better_auth/pw"
+	"better_auth/token_store"
+	"better_auth/webhook"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"testing"
-
-	"github.com/jbrodriguez/mlog"
+	"time"
 )
 
 func TestMain(m *testing.M) {
-	mlog.Start(mlog.LevelError, "")
+	logging.SetLevel(logging.LevelError)
 	r := m.Run()
 	os.Exit(r)
 }
@@ -36,44 +45,168 @@ func makeClient() *http.Client {
 	}
 }
 
-var port int = 9000
-
 func mockConfig(t *testing.T) *config.Config {
-	port += 1
 	return &config.Config{
 		Address:    "localhost",
-		Port:       port,
 		PasswdFile: path.Join(t.TempDir(), "better_auth.pw"),
 	}
 }
 
-func TestMissingHTML(t *testing.T) {
-	cfg := mockConfig(t)
-	srv, _ := NewServer(cfg)
-	addr := fmt.Sprintf("http://%s:%d/", cfg.Address, cfg.Port)
-	go srv.StartAndBlock()
+/// Starts a test server for cfg and returns it with its base url
+func newTestServer(t *testing.T, cfg *config.Config) (*Server, string) {
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.handler())
+	t.Cleanup(ts.Close)
+	return srv, ts.URL + "/"
+}
 
-	err := os.Rename("./static/login.html", "./static/login.html.backup")
+/// Starts a test server for cfg and returns its base url
+func startServer(t *testing.T, cfg *config.Config) string {
+	_, addr := newTestServer(t, cfg)
+	return addr
+}
+
+/// Adds a user to the pw file of cfg and returns a manager of that file
+func addUser(t *testing.T, cfg *config.Config, user string, pass string) *pw.PWManager {
+	pwMan, err := pw.New(cfg.PasswdFile)
 	if err != nil {
-		t.Fatalf("Failed to temporarily move login.html: %s", err)
+		t.Fatal(err)
 	}
+	if err = pwMan.AddUser(user, pass); err != nil {
+		t.Fatal(err)
+	}
+	return pwMan
+}
 
-	defer os.Rename("./static/login.html.backup", "./static/login.html")
+/// Returns a new client that has requested the login page, and the csrf token
+/// it was given
+func csrfClient(t *testing.T, addr string) (*http.Client, string) {
+	client := makeClient()
+	csrf, err := getCSRF(client, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, csrf
+}
 
-	resp, err := http.Get(addr)
+/// Posts form to target with the csrf token, unless it is empty, and any
+/// extra headers
+func postForm(t *testing.T, client *http.Client, target string, csrf string, form url.Values, header http.Header) *http.Response {
+	if csrf != "" {
+		form.Set("csrf_token", csrf)
+	}
+	req, _ := http.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if resp.StatusCode != 404 {
+	return resp
+}
+
+/// Logs user in from a new client and returns the client, its session cookie,
+/// nil if the login failed, and its csrf token
+func loginAs(t *testing.T, addr string, user string, pass string, header http.Header) (*http.Client, *http.Cookie, string) {
+	client, csrf := csrfClient(t, addr)
+	resp := postForm(t, client, addr+"login", csrf, url.Values{"username": {user}, "password": {pass}}, header)
+	return client, getCookie(SESSION_TOKEN, resp), csrf
+}
+
+/// Sends an auth request with the session cookie and returns its status code
+func authStatus(t *testing.T, addr string, session *http.Cookie) int {
+	req, _ := http.NewRequest(http.MethodGet, addr+"authrequest", nil)
+	req.AddCookie(session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+/// Tests that the login page does not depend on the working directory
+func TestEmbeddedHTML(t *testing.T) {
+	cfg := mockConfig(t)
+	cfg.LoginPage.Title = "Sterling Archer Login"
+	addr := startServer(t, cfg)
+
+	wd, _ := os.Getwd()
+	err := os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	client := makeClient()
+	resp, err := client.Get(addr + "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
 		t.Fatalf("Unexpected status code %d", resp.StatusCode)
 	}
+
+	body, _ := io.ReadAll(resp.Body)
+	csrf := getCookie(CSRF_TOKEN, resp)
+	if csrf == nil {
+		t.Fatal("csrf cookie not in response")
+	}
+	if !strings.Contains(string(body), csrf.Value) {
+		t.Fatal("csrf token not in login form")
+	}
+	if !strings.Contains(string(body), "<title>Sterling Archer Login</title>") {
+		t.Fatal("Configured title not in login page")
+	}
+}
+
+func TestLoginLanguage(t *testing.T) {
+	cfg := mockConfig(t)
+	addr := startServer(t, cfg)
+
+	req, _ := http.NewRequest(http.MethodGet, addr+"login", nil)
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `<html lang="de">`) || !strings.Contains(string(body), "Benutzername") {
+		t.Fatal("Login page not rendered in requested language")
+	}
+}
+
+func TestCSRFFormMismatch(t *testing.T) {
+	cfg := mockConfig(t)
+	addr := startServer(t, cfg)
+	client := makeClient()
+
+	_, err := getCSRF(client, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.PostForm(addr+"login", url.Values{
+		"csrf_token": {"not the cookie value"},
+		"username":   {"Pam"},
+		"password":   {"a bad pass"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 403 {
+		t.Fatalf("unexpected status code %d for mismatched csrf token", resp.StatusCode)
+	}
 }
 
 func TestGetLogin(t *testing.T) {
 	cfg := mockConfig(t)
-	srv, _ := NewServer(cfg)
-	addr := fmt.Sprintf("http://%s:%d/", cfg.Address, cfg.Port)
-	go srv.StartAndBlock()
+	addr := startServer(t, cfg)
 
 	httpclient := makeClient()
 
@@ -114,9 +247,7 @@ func TestPostLogin(t *testing.T) {
 	pwMan, _ := pw.New(cfg.PasswdFile)
 	pwMan.AddUser(TESTUSER, TESTPASS)
 
-	srv, _ := NewServer(cfg)
-	addr := fmt.Sprintf("http://%s:%d/", cfg.Address, cfg.Port)
-	go srv.StartAndBlock()
+	addr := startServer(t, cfg)
 
 	client := makeClient()
 
@@ -127,20 +258,21 @@ func TestPostLogin(t *testing.T) {
 	})
 	_ = err
 
-	if resp.StatusCode != 511 {
+	if resp.StatusCode != 403 {
 		t.Fatalf("unexpected status code %d for no-csrf post", resp.StatusCode)
 	}
 
 	// get csrf
-	_, err = client.Get(addr + "login")
+	csrf, err := getCSRF(client, addr)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// post bad username and password
 	resp, err = client.PostForm(addr+"login", url.Values{
-		"username": {"a bad user"},
-		"password": {"a bad pass"},
+		"csrf_token": {csrf},
+		"username":   {"a bad user"},
+		"password":   {"a bad pass"},
 	})
 
 	if err != nil {
@@ -153,8 +285,9 @@ func TestPostLogin(t *testing.T) {
 
 	// post bad username
 	resp, _ = client.PostForm(addr+"login", url.Values{
-		"username": {"a bad user"},
-		"password": {TESTPASS},
+		"csrf_token": {csrf},
+		"username":   {"a bad user"},
+		"password":   {TESTPASS},
 	})
 
 	if resp.StatusCode != 401 {
@@ -163,8 +296,9 @@ func TestPostLogin(t *testing.T) {
 
 	// post bad password
 	resp, _ = client.PostForm(addr+"login", url.Values{
-		"username": {TESTUSER},
-		"password": {"a bad pass"},
+		"csrf_token": {csrf},
+		"username":   {TESTUSER},
+		"password":   {"a bad pass"},
 	})
 
 	if resp.StatusCode != 401 {
@@ -173,20 +307,98 @@ func TestPostLogin(t *testing.T) {
 
 	// post good login
 	resp, _ = client.PostForm(addr+"login", url.Values{
-		"username": {TESTUSER},
-		"password": {TESTPASS},
+		"csrf_token": {csrf},
+		"username":   {TESTUSER},
+		"password":   {TESTPASS},
 	})
 
-	if resp.StatusCode != 200 {
+	if resp.StatusCode != 303 {
 		t.Fatalf("unexpected status code %d for good login post", resp.StatusCode)
 	}
+	if resp.Header.Get("Location") != "/" {
+		t.Fatalf("unexpected redirect `%s` for good login post", resp.Header.Get("Location"))
+	}
+}
+
+/// Tests that a failed form post re-renders the login page and a good one
+/// redirects back to the page nginx was asked for
+func TestFormLogin(t *testing.T) {
+	const TESTUSER string = "Cyril"
+	const TESTPASS string = "chet_manly_1"
+	cfg := mockConfig(t)
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	addr := startServer(t, cfg)
+	client := makeClient()
+
+	req, _ := http.NewRequest(http.MethodGet, addr+"login", nil)
+	req.Header.Set("X-Original-URI", "/secret_hideout/?tab=2")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `name="next" value="/secret_hideout/?tab=2"`) {
+		t.Fatal("original uri not in login form")
+	}
+	csrf := getCookie(CSRF_TOKEN, resp).Value
+
+	resp = postForm(t, client, addr+"login", csrf, url.Values{
+		"next":     {"/secret_hideout/?tab=2"},
+		"username": {TESTUSER},
+		"password": {"a bad pass"},
+	}, nil)
+	if resp.StatusCode != 401 {
+		t.Fatalf("unexpected status code %d for bad password post", resp.StatusCode)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `<div id="invalidLoginWarn" class="warnBanner">`) {
+		t.Fatal("error banner not shown after bad password post")
+	}
+
+	resp = postForm(t, client, addr+"login", csrf, url.Values{
+		"next":     {"/secret_hideout/?tab=2"},
+		"username": {TESTUSER},
+		"password": {TESTPASS},
+	}, nil)
+	if resp.StatusCode != 303 {
+		t.Fatalf("unexpected status code %d for good login post", resp.StatusCode)
+	}
+	if resp.Header.Get("Location") != "/secret_hideout/?tab=2" {
+		t.Fatalf("unexpected redirect `%s`", resp.Header.Get("Location"))
+	}
+}
+
+/// Tests the json responses used by the login page's javascript
+func TestJSONLogin(t *testing.T) {
+	const TESTUSER string = "Ray"
+	const TESTPASS string = "bionic_legs"
+	cfg := mockConfig(t)
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	addr := startServer(t, cfg)
+	client, csrf := csrfClient(t, addr)
+	post := func(form url.Values) (int, map[string]string) {
+		resp := postForm(t, client, addr+"login", csrf, form, http.Header{"Accept": {"application/json"}})
+		result := make(map[string]string)
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	status, result := post(url.Values{"username": {TESTUSER}, "password": {"a bad pass"}})
+	if status != 401 || result["error"] != "invalid_login" {
+		t.Fatalf("unexpected response %d %v for bad password post", status, result)
+	}
+
+	status, result = post(url.Values{"username": {TESTUSER}, "password": {TESTPASS}, "next": {"//evil.example"}})
+	if status != 200 || result["redirect"] != "/" {
+		t.Fatalf("unexpected response %d %v for good login post", status, result)
+	}
 }
 
 func TestReloadPW(t *testing.T) {
 	cfg := mockConfig(t)
-	srv, _ := NewServer(cfg)
-	addr := fmt.Sprintf("http://%s:%d/", cfg.Address, cfg.Port)
-	go srv.StartAndBlock()
+	addr := startServer(t, cfg)
 
 	client := makeClient()
 
@@ -194,27 +406,28 @@ func TestReloadPW(t *testing.T) {
 	const PASSWORD string = "found@tion"
 
 	// get csrf
-	_, err := client.Get(addr + "login")
+	csrf, err := getCSRF(client, addr)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// attempt to log in
 	resp, err := client.PostForm(addr+"login", url.Values{
-		"username": {USERNAME},
-		"password": {PASSWORD},
+		"csrf_token": {csrf},
+		"username":   {USERNAME},
+		"password":   {PASSWORD},
 	})
 
 	if err != nil {
 		t.Fatal(err)
 	}
-	if resp.StatusCode == 200 {
+	if resp.StatusCode == 303 {
 		t.Fatal("login succeeded with test user. remove Asimov and run test again")
 	}
 
 	pw_man, err := pw.New(cfg.PasswdFile)
 	if err != nil {
-		mlog.Error(err)
+		logging.Error(err)
 		return
 	}
 
@@ -235,15 +448,16 @@ func TestReloadPW(t *testing.T) {
 
 	// attempt to log in
 	resp, err = client.PostForm(addr+"login", url.Values{
-		"username": {USERNAME},
-		"password": {PASSWORD},
+		"csrf_token": {csrf},
+		"username":   {USERNAME},
+		"password":   {PASSWORD},
 	})
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if resp.StatusCode != 200 {
+	if resp.StatusCode != 303 {
 		t.Fatalf("login failed: %d", resp.StatusCode)
 	}
 }
@@ -255,19 +469,17 @@ func TestAuthRequest(t *testing.T) {
 
 	pw_man, err := pw.New(cfg.PasswdFile)
 	if err != nil {
-		mlog.Error(err)
+		logging.Error(err)
 		return
 	}
 
 	err = pw_man.AddUser(TESTUSER, TESTPASS)
 	if err != nil {
-		mlog.Error(err)
+		logging.Error(err)
 		return
 	}
 
-	srv, _ := NewServer(cfg)
-	addr := fmt.Sprintf("http://%s:%d/", cfg.Address, cfg.Port)
-	go srv.StartAndBlock()
+	addr := startServer(t, cfg)
 	client := makeClient()
 	resp, err := client.Get(addr + "authrequest")
 	if err != nil {
@@ -278,19 +490,20 @@ func TestAuthRequest(t *testing.T) {
 	}
 
 	// log in...
-	_, err = client.Get(addr + "login")
+	csrf, err := getCSRF(client, addr)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	resp, err = client.PostForm(addr+"login", url.Values{
-		"username": {TESTUSER},
-		"password": {TESTPASS},
+		"csrf_token": {csrf},
+		"username":   {TESTUSER},
+		"password":   {TESTPASS},
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if resp.StatusCode != 200 {
+	if resp.StatusCode != 303 {
 		t.Fatalf("unexpected status code %d", resp.StatusCode)
 	}
 
@@ -301,6 +514,863 @@ func TestAuthRequest(t *testing.T) {
 
 }
 
+func TestLoginLockout(t *testing.T) {
+	const TESTUSER string = "Lana"
+	const TESTPASS string = "guesswhat_itsme"
+	cfg := mockConfig(t)
+	cfg.Lockout = config.LockoutConfig{MaxAttempts: 2, Window: 60, Duration: 60}
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	addr := startServer(t, cfg)
+	client, csrf := csrfClient(t, addr)
+	login := func(pass string) int {
+		return postForm(t, client, addr+"login", csrf, url.Values{"username": {TESTUSER}, "password": {pass}}, nil).StatusCode
+	}
+
+	for i := 0; i < 2; i++ {
+		if status := login("a bad pass"); status != 401 {
+			t.Fatalf("unexpected status code %d for bad password post", status)
+		}
+	}
+
+	// good password is refused while locked out
+	if status := login(TESTPASS); status != 429 {
+		t.Fatalf("unexpected status code %d for locked out user", status)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	const TESTUSER string = "Krieger"
+	const TESTPASS string = "virtual_girlfriend"
+	cfg := mockConfig(t)
+	cfg.Lockout = config.LockoutConfig{MaxAttempts: 2, Window: 60, Duration: 60}
+	cfg.BasicAuth = config.BasicAuthConfig{
+		Enabled:   true,
+		Locations: []string{"/dav/"},
+		CacheTTL:  60,
+		Challenge: true,
+		Realm:     "better_auth",
+	}
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	srv, addr := newTestServer(t, cfg)
+	get := func(uri string, user string, pass string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, addr+"authrequest", nil)
+		req.Header.Set("X-Original-URI", uri)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("/dav/file.txt", "", "")
+	if resp.StatusCode != 401 {
+		t.Fatalf("unexpected status code %d without credentials", resp.StatusCode)
+	}
+	if resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatal("WWW-Authenticate challenge not sent")
+	}
+
+	resp = get("/dav/file.txt", TESTUSER, TESTPASS)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status code %d for good credentials", resp.StatusCode)
+	}
+
+	// cached verification still succeeds
+	resp = get("/dav/other.txt", TESTUSER, TESTPASS)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status code %d for cached credentials", resp.StatusCode)
+	}
+
+	// locations are matched on the cleaned path, which access tests cover in full
+	for _, uri := range []string{"/dashboard/", "/dav/../dashboard/", "/dav%2F..%2Fdashboard/", "/davfoo"} {
+		resp = get(uri, TESTUSER, TESTPASS)
+		if resp.StatusCode != 401 {
+			t.Fatalf("unexpected status code %d for %s outside of basic auth locations", resp.StatusCode, uri)
+		}
+		if resp.Header.Get("WWW-Authenticate") != "" {
+			t.Fatalf("WWW-Authenticate challenge sent for %s outside of basic auth locations", uri)
+		}
+	}
+
+	// cached credentials stop working once the user is disabled
+	srv.pwManager.SetDisabled(TESTUSER, true)
+	if resp = get("/dav/file.txt", TESTUSER, TESTPASS); resp.StatusCode != 401 {
+		t.Fatalf("unexpected status code %d for cached credentials of a disabled user", resp.StatusCode)
+	}
+	srv.pwManager.SetDisabled(TESTUSER, false)
+
+	resp = get("/dav/file.txt", TESTUSER, "a bad pass")
+	if resp.StatusCode != 401 {
+		t.Fatalf("unexpected status code %d for bad password", resp.StatusCode)
+	}
+	get("/dav/file.txt", TESTUSER, "a bad pass")
+
+	// locked out, even with cached good credentials
+	resp = get("/dav/file.txt", TESTUSER, TESTPASS)
+	if resp.StatusCode != 401 {
+		t.Fatalf("unexpected status code %d for locked out user", resp.StatusCode)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	const TESTUSER string = "Cheryl"
+	const TESTPASS string = "carol_tunt_1"
+	cfg := mockConfig(t)
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	addr := startServer(t, cfg)
+	client, session, _ := loginAs(t, addr, TESTUSER, TESTPASS, nil)
+	if session == nil {
+		t.Fatal("session cookie not in response")
+	}
+
+	resp, err := client.Get(addr + "logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 303 {
+		t.Fatalf("unexpected status code %d", resp.StatusCode)
+	}
+	if c := getCookie(SESSION_TOKEN, resp); c == nil || c.MaxAge >= 0 {
+		t.Fatal("session cookie not cleared")
+	}
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Fatal("response has no request id")
+	}
+
+	// the old session id is no longer accepted
+	if status := authStatus(t, addr, session); status != 401 {
+		t.Fatalf("unexpected status code %d for logged out session", status)
+	}
+}
+
+/// Tests that sessions record the client's address from a trusted proxy's
+/// headers, and ignore headers from anyone else
+func TestClientIP(t *testing.T) {
+	const TESTUSER string = "Ray"
+	const TESTPASS string = "bionic_legs_22"
+
+	for _, c := range []struct {
+		trusted []string
+		want    string
+	}{
+		{[]string{"127.0.0.1", "::1"}, "203.0.113.7"},
+		{[]string{"10.0.0.0/8"}, "127.0.0.1"},
+	} {
+		cfg := mockConfig(t)
+		cfg.TrustedProxies = c.trusted
+		cfg.SessionTimeout = 60
+		addUser(t, cfg, TESTUSER, TESTPASS)
+
+		srv, addr := newTestServer(t, cfg)
+		_, session, _ := loginAs(t, addr, TESTUSER, TESTPASS, http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}})
+		if session == nil {
+			t.Fatal("session cookie not in response")
+		}
+		if ip := srv.sessionStore.IP(session.Value); ip != c.want {
+			t.Fatalf("Session from %s trusting %v, expected %s", ip, c.trusted, c.want)
+		}
+	}
+}
+
+/// Tests that the server applies its access rules to auth requests and the
+/// login page, and replaces them on a reload. Matching itself is covered by
+/// the access package's tests
+func TestAccessRules(t *testing.T) {
+	cfg := mockConfig(t)
+	cfg.Rules = []config.AccessRule{
+		{Networks: []string{"127.0.0.0/8"}, Paths: []string{"/public/"}, Action: config.ActionAllow},
+		{Hosts: []string{"*.blocked.example"}, Action: config.ActionDeny},
+	}
+	srv, addr := newTestServer(t, cfg)
+
+	get := func(endpoint string, host string, uri string, accept string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, addr+endpoint, nil)
+		req.Header.Set("X-Original-Host", host)
+		req.Header.Set("X-Original-URI", uri)
+		req.Header.Set("Accept", accept)
+		resp, err := makeClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, c := range []struct {
+		host string
+		uri  string
+		want int
+	}{
+		{"app.example", "/public/index.html?q=1", 200},
+		{"app.example", "/private/", 401},
+		{"www.blocked.example", "/private/", 403},
+	} {
+		resp := get("authrequest", c.host, c.uri, "")
+		if resp.StatusCode != c.want {
+			t.Fatalf("%s%s returned %d, expected %d", c.host, c.uri, resp.StatusCode, c.want)
+		}
+	}
+
+	// the login page shows why instead of a form
+	resp := get("login", "www.blocked.example", "/private/", "")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 403 || strings.Contains(string(body), "<form") {
+		t.Fatalf("Denied client got login form, status %d", resp.StatusCode)
+	}
+	resp = get("login", "www.blocked.example", "/private/", "application/json")
+	var data map[string]string
+	json.NewDecoder(resp.Body).Decode(&data)
+	if resp.StatusCode != 403 || data["error"] != loginErrDenied {
+		t.Fatalf("Unexpected response %d %v", resp.StatusCode, data)
+	}
+
+	// rules are replaced by a reload
+	newCfg := *cfg
+	newCfg.Rules = []config.AccessRule{{Networks: []string{"127.0.0.1"}, Action: config.ActionDeny}}
+	_, err := srv.applyConfig(&newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := get("authrequest", "app.example", "/public/", ""); resp.StatusCode != 403 {
+		t.Fatalf("Reloaded rules not applied, got %d", resp.StatusCode)
+	}
+}
+
+func TestGeoIPLogin(t *testing.T) {
+	const TESTUSER string = "Cyril"
+	const TESTPASS string = "figgis_agency_1"
+
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.PasswordPolicy = config.Default().PasswordPolicy
+	cfg.TrustedProxies = []string{"127.0.0.1"}
+	cfg.GeoIP = config.GeoIPConfig{
+		Databases: []string{geoiptest.WriteDB(t, map[string]map[string]any{
+			"91.0.0.0/10":   {"country": map[string]any{"iso_code": "DE"}},
+			"130.56.0.0/16": {"country": map[string]any{"iso_code": "AU"}},
+		})},
+		NewCountry: true,
+		Action:     config.GeoIPBlock,
+	}
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	srv, addr := newTestServer(t, cfg)
+	login := func(ip string) *http.Response {
+		client, csrf := csrfClient(t, addr)
+		return postForm(t, client, addr+"login", csrf, url.Values{"username": {TESTUSER}, "password": {TESTPASS}}, http.Header{
+			"Accept":          {"application/json"},
+			"X-Forwarded-For": {ip},
+		})
+	}
+
+	resp := login("91.1.2.3")
+	session := getCookie(SESSION_TOKEN, resp)
+	if resp.StatusCode != 200 || session == nil {
+		t.Fatalf("First login failed with %d", resp.StatusCode)
+	}
+	if country, _ := srv.sessionStore.Location(session.Value); country != "DE" {
+		t.Fatalf("Session from `%s`, expected DE", country)
+	}
+
+	resp = login("130.56.1.1")
+	var data map[string]string
+	json.NewDecoder(resp.Body).Decode(&data)
+	if resp.StatusCode != 403 || data["error"] != loginErrUnusual {
+		t.Fatalf("Login from new country not blocked: %d %v", resp.StatusCode, data)
+	}
+	if resp := login("91.200.0.1"); resp.StatusCode != 200 {
+		t.Fatalf("Login from known country failed with %d", resp.StatusCode)
+	}
+
+	// logged instead of blocked
+	newCfg := *cfg
+	newCfg.GeoIP.Action = config.GeoIPLog
+	_, err := srv.applyConfig(&newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := login("130.56.1.1"); resp.StatusCode != 200 {
+		t.Fatalf("Logged login failed with %d", resp.StatusCode)
+	}
+
+	// the session given after a required password change has a location too
+	srv.pwManager.SetMustChange(TESTUSER, true)
+	header := http.Header{"X-Forwarded-For": {"91.1.2.3"}}
+	client, _, csrf := loginAs(t, addr, TESTUSER, TESTPASS, header)
+	resp = postForm(t, client, addr+"login/change", csrf, url.Values{"password": {"new_agency_2"}, "confirm": {"new_agency_2"}}, header)
+	session = getCookie(SESSION_TOKEN, resp)
+	if session == nil {
+		t.Fatalf("No session after the password change, got %d", resp.StatusCode)
+	}
+	if country, _ := srv.sessionStore.Location(session.Value); country != "DE" {
+		t.Fatalf("Session after the password change from `%s`, expected DE", country)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	const TESTUSER string = "Ray"
+	const TESTPASS string = "bionic_legs_22"
+
+	events := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r.Header.Get(webhook.EventHeader)
+	}))
+	defer receiver.Close()
+
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.Webhooks = []config.WebhookTarget{{
+		URL:    receiver.URL,
+		Events: []string{logging.AuditLoginSuccess, logging.AuditNewDevice, logging.AuditLockout},
+	}}
+	cfg.WebhookDelivery = config.WebhookDeliveryConfig{QueueSize: 10, Retries: 0, Timeout: 5}
+	addUser(t, cfg, TESTUSER, TESTPASS)
+	addr := startServer(t, cfg)
+
+	expect := func(want ...string) {
+		for _, w := range want {
+			select {
+			case e := <-events:
+				if e != w {
+					t.Fatalf("Received %s, expected %s", e, w)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s not received", w)
+			}
+		}
+	}
+
+	laptop, csrf := csrfClient(t, addr)
+	login := func(password string) {
+		postForm(t, laptop, addr+"login", csrf, url.Values{"username": {TESTUSER}, "password": {password}}, nil)
+	}
+	login("wrong")
+	login(TESTPASS)
+	expect(logging.AuditLoginSuccess)
+	login(TESTPASS)
+	expect(logging.AuditLoginSuccess)
+
+	loginAs(t, addr, TESTUSER, TESTPASS, nil)
+	expect(logging.AuditLoginSuccess, logging.AuditNewDevice)
+	select {
+	case e := <-events:
+		t.Fatalf("Unexpected event %s", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+/// Tests the whole forgot password flow: the emailed link sets a new password
+/// once, ends the user's sessions and the user is told of the change
+func TestPasswordReset(t *testing.T) {
+	const TESTUSER string = "Pam"
+	const TESTPASS string = "cocaine_bear_9"
+	const NEWPASS string = "snowball_fight_2"
+	mailSrv := emailtest.NewServer(t)
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.Lockout = config.LockoutConfig{MaxAttempts: 5, Window: 60, Duration: 60}
+	cfg.PasswordPolicy = config.Default().PasswordPolicy
+	cfg.SMTP = mailSrv.Config()
+	cfg.PasswordReset = config.PasswordResetConfig{
+		Enabled:  true,
+		URL:      "https://isis.example.com/",
+		Lifetime: 600,
+		Notify:   true,
+	}
+	addUser(t, cfg, TESTUSER, TESTPASS).SetEmail(TESTUSER, "pam@isis.example.com")
+	addUser(t, cfg, "Brett", TESTPASS)
+
+	addr := startServer(t, cfg)
+	client, session, csrf := loginAs(t, addr, TESTUSER, TESTPASS, nil)
+	if session == nil {
+		t.Fatal("unable to log in before reset")
+	}
+	post := func(page string, form url.Values) (int, string) {
+		resp := postForm(t, client, addr+page, csrf, form, nil)
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	forgot := func(name string) string {
+		status, body := post("login/forgot", url.Values{"username": {name}})
+		if status != 200 {
+			t.Fatalf("unexpected status code %d requesting a reset for `%s`", status, name)
+		}
+		return body
+	}
+
+	// unknown users and users without email get the same response, and no email
+	sent := forgot("Cyril")
+	if forgot("Brett") != sent {
+		t.Fatal("response differs for a user without an email address")
+	}
+	mailSrv.None(t, 100*time.Millisecond)
+
+	if forgot("PAM@isis.example.com") != sent {
+		t.Fatal("response differs for a user with an email address")
+	}
+	msg := mailSrv.Next(t)
+	if msg.To[0] != "pam@isis.example.com" {
+		t.Fatalf("reset link sent to %v", msg.To)
+	}
+	start := strings.Index(msg.Body, "https://isis.example.com/login/reset?token=")
+	if start == -1 {
+		t.Fatalf("no reset link in email `%s`", msg.Body)
+	}
+	link, _ := url.Parse(strings.Fields(msg.Body[start:])[0])
+	token := link.Query().Get("token")
+
+	resp, err := client.Get(addr + "login/reset?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("unexpected response %d for reset page", resp.StatusCode)
+	}
+
+	reset := func(pass string, confirm string) (int, string) {
+		return post("login/reset", url.Values{"token": {token}, "password": {pass}, "confirm": {confirm}})
+	}
+	if code, _ := reset(NEWPASS, "something_else"); code != 400 {
+		t.Fatalf("unexpected status code %d for mismatched passwords", code)
+	}
+	code, body := reset("short", "short")
+	if code != 400 {
+		t.Fatalf("unexpected status code %d for a short password", code)
+	}
+	if !strings.Contains(body, "Use at least 8 characters") {
+		t.Fatal("broken password rule not shown")
+	}
+	if code, _ := reset(NEWPASS, NEWPASS); code != 200 {
+		t.Fatalf("unexpected status code %d for a good password", code)
+	}
+	if code, _ := reset("third_password", "third_password"); code != 400 {
+		t.Fatalf("unexpected status code %d reusing a reset link", code)
+	}
+
+	msg = mailSrv.Next(t)
+	if !strings.Contains(msg.Body, "password of Pam was just changed") {
+		t.Fatalf("unexpected notification `%s`", msg.Body)
+	}
+
+	// the old session ended with the old password
+	if status := authStatus(t, addr, session); status != 401 {
+		t.Fatalf("unexpected status code %d for a session from before the reset", status)
+	}
+	login := func(pass string) int {
+		code, _ := post("login", url.Values{"username": {TESTUSER}, "password": {pass}})
+		return code
+	}
+	if login(TESTPASS) != 401 {
+		t.Fatal("old password still works")
+	}
+	if login(NEWPASS) != 303 {
+		t.Fatal("new password does not work")
+	}
+}
+
+/// Tests that the reset pages do not exist unless enabled
+func TestPasswordResetDisabled(t *testing.T) {
+	addr := startServer(t, mockConfig(t))
+	for _, page := range []string{"login/forgot", "login/reset"} {
+		resp, err := http.Get(addr + page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 404 {
+			t.Fatalf("unexpected status code %d for %s", resp.StatusCode, page)
+		}
+	}
+}
+
+/// Tests that a user who must change their password gets a session that only
+/// reaches the change password page until they have
+func TestPasswordChange(t *testing.T) {
+	const TESTUSER string = "Algernop"
+	const TESTPASS string = "krieger_clone_3"
+	const NEWPASS string = "not_a_clone_44"
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.PasswordPolicy = config.Default().PasswordPolicy
+	pwMan := addUser(t, cfg, TESTUSER, TESTPASS)
+	pwMan.SetMustChange(TESTUSER, true)
+
+	addr := startServer(t, cfg)
+	client, csrf := csrfClient(t, addr)
+	resp := postForm(t, client, addr+"login", csrf, url.Values{
+		"next":     {"/lab/"},
+		"username": {TESTUSER},
+		"password": {TESTPASS},
+	}, nil)
+	if resp.StatusCode != 303 || resp.Header.Get("Location") != "/login/change?next=%2Flab%2F" {
+		t.Fatalf("unexpected response %d to %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	restricted := getCookie(SESSION_TOKEN, resp)
+	if authStatus(t, addr, restricted) != 401 {
+		t.Fatal("restricted session accepted by authrequest")
+	}
+
+	// nginx shows the login page for the 401, which sends the user back
+	resp, err := client.Get(addr + "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 303 || !strings.HasPrefix(resp.Header.Get("Location"), "/login/change") {
+		t.Fatalf("unexpected response %d to %s for restricted session", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	change := func(pass string, confirm string) *http.Response {
+		return postForm(t, client, addr+"login/change", csrf, url.Values{
+			"next":     {"/lab/"},
+			"password": {pass},
+			"confirm":  {confirm},
+		}, nil)
+	}
+	if resp := change(NEWPASS, "something_else"); resp.StatusCode != 400 {
+		t.Fatalf("unexpected status code %d for mismatched passwords", resp.StatusCode)
+	}
+	if resp := change(TESTUSER+"!!", TESTUSER+"!!"); resp.StatusCode != 400 {
+		t.Fatalf("unexpected status code %d for password containing the username", resp.StatusCode)
+	}
+	resp = change(NEWPASS, NEWPASS)
+	if resp.StatusCode != 303 || resp.Header.Get("Location") != "/lab/" {
+		t.Fatalf("unexpected response %d to %s for a good password", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if authStatus(t, addr, getCookie(SESSION_TOKEN, resp)) != 200 {
+		t.Fatal("session after changing password not accepted")
+	}
+	if authStatus(t, addr, restricted) != 401 {
+		t.Fatal("restricted session still exists")
+	}
+	if pwMan.Reload(); !pwMan.Verify(TESTUSER, NEWPASS) || pwMan.ChangeRequired(TESTUSER) != "" {
+		t.Fatal("new password not saved")
+	}
+}
+
+func TestAccountPassword(t *testing.T) {
+	const TESTUSER string = "Cyril"
+	const TESTPASS string = "figgis_embezzles"
+	const NEWPASS string = "totally_honest_22"
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.PasswordPolicy = config.Default().PasswordPolicy
+	pwMan := addUser(t, cfg, TESTUSER, TESTPASS)
+
+	addr := startServer(t, cfg)
+	client, session, csrf := loginAs(t, addr, TESTUSER, TESTPASS, nil)
+	_, other, _ := loginAs(t, addr, TESTUSER, TESTPASS, nil)
+
+	resp, err := makeClient().Get(addr + "account/password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 303 || resp.Header.Get("Location") != "/login" {
+		t.Fatalf("unexpected response %d to %s without a session", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, err = client.Get(addr + "account/password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status code %d for the account page", resp.StatusCode)
+	}
+
+	for _, c := range []struct {
+		current, pass, confirm string
+		want                   int
+	}{
+		{"wrong_password", NEWPASS, NEWPASS, 401},
+		{TESTPASS, NEWPASS, "something_else", 400},
+		{TESTPASS, "short", "short", 400},
+		{TESTPASS, NEWPASS, NEWPASS, 200},
+	} {
+		resp := postForm(t, client, addr+"account/password", csrf, url.Values{
+			"current":  {c.current},
+			"password": {c.pass},
+			"confirm":  {c.confirm},
+			"revoke":   {"on"},
+		}, nil)
+		if resp.StatusCode != c.want {
+			t.Fatalf("unexpected status code %d for %v, expected %d", resp.StatusCode, c, c.want)
+		}
+	}
+
+	for cookie, want := range map[*http.Cookie]int{session: 200, other: 401} {
+		if status := authStatus(t, addr, cookie); status != want {
+			t.Fatalf("unexpected status code %d for a session after revoking others, expected %d", status, want)
+		}
+	}
+	if pwMan.Reload(); !pwMan.Verify(TESTUSER, NEWPASS) {
+		t.Fatal("new password not saved")
+	}
+
+	// without a csrf token nothing is changed
+	resp = postForm(t, client, addr+"account/password", "", url.Values{
+		"current":  {NEWPASS},
+		"password": {TESTPASS + "!"},
+		"confirm":  {TESTPASS + "!"},
+	}, nil)
+	if resp.StatusCode != 403 {
+		t.Fatalf("unexpected status code %d without a csrf token", resp.StatusCode)
+	}
+}
+
+func TestAccountSessions(t *testing.T) {
+	const TESTUSER string = "Pam"
+	const TESTPASS string = "underground_fights"
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.AuditFile = path.Join(t.TempDir(), "audit.log")
+	err := logging.Start(logging.Options{Level: "error", Format: logging.FormatText, Output: logging.OutputStdout, AuditFile: cfg.AuditFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logging.Start(logging.Options{Level: "error", Format: logging.FormatText, Output: logging.OutputStdout})
+	})
+	addUser(t, cfg, TESTUSER, TESTPASS)
+
+	addr := startServer(t, cfg)
+	login := func(userAgent string) (*http.Client, *http.Cookie, string) {
+		return loginAs(t, addr, TESTUSER, TESTPASS, http.Header{"User-Agent": {userAgent}})
+	}
+	client, session, csrf := login("Mozilla/5.0 (X11; Linux x86_64; rv:130.0) Gecko/20100101 Firefox/130.0")
+	_, other, _ := login("curl/8.5.0")
+
+	resp, err := client.Get(addr + "account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status code %d for the account page", resp.StatusCode)
+	}
+	for _, want := range []string{"Firefox, Linux", "curl", "This session", "Logged in", "Sign out everywhere else"} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("`%s` missing from the account page", want)
+		}
+	}
+
+	revoke := func(form url.Values) {
+		if resp := postForm(t, client, addr+"account", csrf, form, nil); resp.StatusCode != 303 {
+			t.Fatalf("unexpected status code %d signing out a session", resp.StatusCode)
+		}
+	}
+	valid := func(cookie *http.Cookie) bool {
+		return authStatus(t, addr, cookie) == 200
+	}
+
+	// the current session is ended by logging out, not here
+	revoke(url.Values{"revoke": {token_store.Handle(session.Value)}})
+	if !valid(session) || !valid(other) {
+		t.Fatal("session ended by revoking the current session")
+	}
+	revoke(url.Values{"revoke": {token_store.Handle(other.Value)}})
+	if !valid(session) || valid(other) {
+		t.Fatal("other session not ended")
+	}
+
+	_, other, _ = login("curl/8.5.0")
+	revoke(url.Values{"revoke_others": {"on"}})
+	if !valid(session) || valid(other) {
+		t.Fatal("other sessions not ended")
+	}
+}
+
+func TestAdminConsole(t *testing.T) {
+	const ADMIN string = "Sterling"
+	const ADMINPASS string = "danger_zone_1"
+	const USER string = "Lana"
+	const USERPASS string = "duchess_ocelot"
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.PasswordPolicy = config.Default().PasswordPolicy
+	cfg.Admin = config.AdminConfig{Enabled: true, Path: "/admin", Group: "admin"}
+	pwMan := addUser(t, cfg, ADMIN, ADMINPASS)
+	pwMan.SetGroups(ADMIN, []string{"admin"})
+	addUser(t, cfg, USER, USERPASS)
+
+	addr := startServer(t, cfg)
+	admin, _, csrf := loginAs(t, addr, ADMIN, ADMINPASS, nil)
+	user, session, _ := loginAs(t, addr, USER, USERPASS, nil)
+
+	get := func(client *http.Client, path string) (int, string) {
+		resp, err := client.Get(addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, _ := get(user, "admin"); status != 403 {
+		t.Fatalf("unexpected status code %d for a user outside the admin group", status)
+	}
+	if status, body := get(admin, "admin"); status != 200 || !strings.Contains(body, USER) {
+		t.Fatalf("unexpected status code %d or users missing from the admin console", status)
+	}
+
+	post := func(path string, form url.Values) *http.Response {
+		return postForm(t, admin, addr+path, csrf, form, nil)
+	}
+	for _, c := range []struct {
+		pass, confirm, groups string
+		want                  int
+	}{
+		{"mad_science_9", "something_else", "", 400},
+		{"short", "short", "", 400},
+		{"mad_science_9", "mad_science_9", "lab, not a group", 400},
+		{"mad_science_9", "mad_science_9", "lab, science", 303},
+	} {
+		resp := post("admin", url.Values{"username": {"Krieger"}, "password": {c.pass}, "confirm": {c.confirm}, "groups": {c.groups}})
+		if resp.StatusCode != c.want {
+			t.Fatalf("unexpected status code %d adding user %v, expected %d", resp.StatusCode, c, c.want)
+		}
+	}
+
+	// names that would add lines or fields to the pw file are refused
+	before, _ := os.ReadFile(cfg.PasswdFile)
+	for _, name := range []string{"Barry\nKatya:$2a$10$not_a_hash:groups=admin", "Barry:groups=admin", "Bar\rry", "Bar\x00ry", "Barry Dylan"} {
+		resp := post("admin", url.Values{"username": {name}, "password": {"mad_science_9"}, "confirm": {"mad_science_9"}})
+		if resp.StatusCode != 400 {
+			t.Fatalf("unexpected status code %d adding user %q", resp.StatusCode, name)
+		}
+	}
+	if after, _ := os.ReadFile(cfg.PasswdFile); string(after) != string(before) {
+		t.Fatal("pw file changed by a refused username")
+	}
+
+	pwMan.Reload()
+	if !pwMan.Verify("Krieger", "mad_science_9") || !pwMan.InGroup("Krieger", "science") || pwMan.ChangeRequired("Krieger") != "" {
+		t.Fatal("added user not saved")
+	}
+	if status, _ := get(admin, "admin/user?name=Krieger"); status != 200 {
+		t.Fatalf("unexpected status code %d for a user's page", status)
+	}
+	if status, _ := get(admin, "admin/user?name=Barry"); status != 404 {
+		t.Fatalf("unexpected status code %d for a user that does not exist", status)
+	}
+
+	// admins cannot lock themselves out
+	for _, action := range []string{"disable", "delete"} {
+		if resp := post("admin/user", url.Values{"name": {ADMIN}, "action": {action}}); resp.StatusCode != 400 {
+			t.Fatalf("unexpected status code %d for %s of the admin themselves", resp.StatusCode, action)
+		}
+	}
+	if resp := post("admin/user", url.Values{"name": {ADMIN}, "action": {"groups"}, "groups": {"field_agents"}}); resp.StatusCode != 400 {
+		t.Fatalf("unexpected status code %d for the admin leaving the admin group", resp.StatusCode)
+	}
+
+	resp := post("admin/user", url.Values{"name": {USER}, "action": {"disable"}})
+	if resp.StatusCode != 303 || resp.Header.Get("Location") != "/admin/user?done=disable&name=Lana" {
+		t.Fatalf("unexpected response %d to %s disabling a user", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if authStatus(t, addr, session) != 401 {
+		t.Fatal("disabled user's session still valid")
+	}
+	if _, session, _ := loginAs(t, addr, USER, USERPASS, nil); session != nil {
+		t.Fatal("disabled user logged in")
+	}
+
+	resp = post("admin/user", url.Values{"name": {USER}, "action": {"password"}, "password": {"archer_is_an_idiot"}, "confirm": {"archer_is_an_idiot"}, "must_change": {"on"}})
+	if resp.StatusCode != 303 {
+		t.Fatalf("unexpected status code %d setting a password", resp.StatusCode)
+	}
+	post("admin/user", url.Values{"name": {USER}, "action": {"enable"}})
+	pwMan.Reload()
+	if !pwMan.Verify(USER, "archer_is_an_idiot") || pwMan.ChangeRequired(USER) != pw.ChangeFlagged {
+		t.Fatal("password set by admin not saved")
+	}
+
+	resp = post("admin/user", url.Values{"name": {"Krieger"}, "action": {"delete"}})
+	if resp.StatusCode != 303 || resp.Header.Get("Location") != "/admin" {
+		t.Fatalf("unexpected response %d to %s deleting a user", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if pwMan.Reload(); pwMan.Exists("Krieger") {
+		t.Fatal("deleted user still exists")
+	}
+
+	// without a csrf token nothing is changed
+	resp = postForm(t, admin, addr+"admin/user", "", url.Values{"name": {USER}, "action": {"delete"}}, nil)
+	if resp.StatusCode != 403 || !pwMan.Exists(USER) {
+		t.Fatalf("unexpected status code %d without a csrf token", resp.StatusCode)
+	}
+}
+
+/// Tests that an invite link lets its user choose a password once, and puts
+/// them in the invite's groups
+func TestInvite(t *testing.T) {
+	const TESTUSER string = "Ray"
+	const TESTPASS string = "bionic_legs_77"
+	cfg := mockConfig(t)
+	cfg.SessionTimeout = 60
+	cfg.PasswordPolicy = config.Default().PasswordPolicy
+	token, err := invite.New(cfg.InvitesFile()).Create(TESTUSER, []string{"agents"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startServer(t, cfg)
+	client, csrf := csrfClient(t, addr)
+
+	resp, err := client.Get(addr + "login/invite?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("unexpected response %d for invite page", resp.StatusCode)
+	}
+	resp, err = client.Get(addr + "login/invite?token=not" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Fatalf("unexpected status code %d for an unknown invite", resp.StatusCode)
+	}
+
+	for _, c := range []struct {
+		pass, confirm string
+		want          int
+	}{
+		{TESTPASS, "something_else", 400},
+		{"short", "short", 400},
+		{TESTPASS, TESTPASS, 200},
+		{"another_password", "another_password", 400},
+	} {
+		resp := postForm(t, client, addr+"login/invite", csrf, url.Values{"token": {token}, "password": {c.pass}, "confirm": {c.confirm}}, nil)
+		if resp.StatusCode != c.want {
+			t.Fatalf("unexpected status code %d accepting with `%s`, wanted %d", resp.StatusCode, c.pass, c.want)
+		}
+	}
+
+	pwMan, _ := pw.New(cfg.PasswdFile)
+	if !pwMan.Verify(TESTUSER, TESTPASS) || !pwMan.InGroup(TESTUSER, "agents") {
+		t.Fatal("invited user not added with their password and groups")
+	}
+	if _, session, _ := loginAs(t, addr, TESTUSER, TESTPASS, nil); session == nil {
+		t.Fatal("unable to log in after accepting an invite")
+	}
+}
+
+/// Requests the login page and returns the csrf token it set
+func getCSRF(client *http.Client, addr string) (string, error) {
+	_, err := client.Get(addr + "login")
+	if err != nil {
+		return "", err
+	}
+	u, _ := url.Parse(addr)
+	for _, c := range client.Jar.Cookies(u) {
+		if c.Name == CSRF_TOKEN {
+			return c.Value, nil
+		}
+	}
+	return "", fmt.Errorf("csrf cookie not set")
+}
+
 func getCookie(name string, resp *http.Response) *http.Cookie {
 	for _, c := range resp.Cookies() {
 		if c.Name == name {
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"better_auth/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	der      []byte
+	certFile string
+	keyFile  string
+}
+
+/// Creates a certificate for 127.0.0.1 in dir, signed by parent or self
+/// signed if parent is nil
+func newTestCert(t *testing.T, dir string, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		der:      der,
+		certFile: path.Join(dir, name+".pem"),
+		keyFile:  path.Join(dir, name+".key"),
+	}
+	os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return c
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func startTLSServer(t *testing.T, cfg *config.Config) (*Server, string) {
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(srv.handler())
+	ts.TLS = srv.serverTLSConfig()
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return srv, ts.URL + "/"
+}
+
+/// Returns a client trusting ca and presenting clientCert, if any
+func tlsClient(ca *testCert, clientCert *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	conf := &tls.Config{RootCAs: pool}
+	if clientCert != nil {
+		conf.Certificates = []tls.Certificate{clientCert.tlsCert()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil, true)
+	cfg := reloadableConfig(t)
+	cfg.TLS = config.TLSConfig{CertFile: cert.certFile, KeyFile: cert.keyFile, MinVersion: "1.3"}
+	_, addr := startTLSServer(t, cfg)
+
+	resp, err := tlsClient(cert, nil).Get(addr + "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.TLS.Version != tls.VersionTLS13 {
+		t.Fatalf("Unexpected response %d over TLS version %x", resp.StatusCode, resp.TLS.Version)
+	}
+
+	old := tlsClient(cert, nil)
+	old.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+	_, err = old.Get(addr + "login")
+	if err == nil {
+		t.Fatal("TLS 1.2 accepted with MinVersion 1.3")
+	}
+}
+
+/// Tests that clients must present a certificate signed by ClientCAFile
+func TestTLSClientCert(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil, true)
+	ca := newTestCert(t, dir, "ca", nil, true)
+	nginx := newTestCert(t, dir, "nginx", ca, false)
+	stranger := newTestCert(t, dir, "stranger", nil, false)
+
+	cfg := reloadableConfig(t)
+	cfg.TLS = config.TLSConfig{CertFile: cert.certFile, KeyFile: cert.keyFile, MinVersion: "1.2", ClientCAFile: ca.certFile}
+	_, addr := startTLSServer(t, cfg)
+
+	for name, client := range map[string]*http.Client{
+		"no certificate":        tlsClient(cert, nil),
+		"untrusted certificate": tlsClient(cert, stranger),
+	} {
+		if _, err := client.Get(addr + "authrequest"); err == nil {
+			t.Fatalf("Request with %s accepted", name)
+		}
+	}
+
+	resp, err := tlsClient(cert, nginx).Get(addr + "authrequest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 401 {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+}
+
+/// Tests that a reload picks up a renewed certificate
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil, true)
+	cfg := reloadableConfig(t)
+	cfg.TLS = config.TLSConfig{CertFile: cert.certFile, KeyFile: cert.keyFile, MinVersion: "1.2"}
+	srv, addr := startTLSServer(t, cfg)
+
+	renewed := newTestCert(t, dir, "server", nil, true)
+	_, err := srv.applyConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := tlsClient(renewed, nil)
+	resp, err := client.Get(addr + "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.TLS.PeerCertificates[0].Equal(renewed.cert) {
+		t.Fatal("Renewed certificate not served after reload")
+	}
+
+	// a broken certificate keeps the running one
+	os.WriteFile(cert.keyFile, []byte("not a key"), 0600)
+	if _, err = srv.applyConfig(cfg); err == nil {
+		t.Fatal("Broken certificate applied")
+	}
+	client.CloseIdleConnections()
+	if _, err = client.Get(addr + "login"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/// Tests that adduser only talks to a server presenting the configured certificate
+func TestLocalClient(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil, true)
+	cfg := reloadableConfig(t)
+	cfg.TLS = config.TLSConfig{CertFile: cert.certFile, KeyFile: cert.keyFile, MinVersion: "1.2"}
+	_, addr := startTLSServer(t, cfg)
+
+	u, _ := url.Parse(addr)
+	cfg.Address = u.Hostname()
+	cfg.Port, _ = strconv.Atoi(u.Port())
+	client, baseURL, err := localClient(cfg)
+	if err != nil || baseURL+"/" != addr {
+		t.Fatalf("Unexpected client for %s: %s %s", addr, baseURL, err)
+	}
+	if _, err = client.Get(baseURL + "/login"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestCert(t, t.TempDir(), "other", nil, true)
+	cfg.TLS.CertFile, cfg.TLS.KeyFile = other.certFile, other.keyFile
+	client, _, _ = localClient(cfg)
+	if _, err = client.Get(addr + "login"); err == nil {
+		t.Fatal("Server with another certificate trusted")
+	}
+}
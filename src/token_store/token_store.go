@@ -2,27 +2,69 @@ package token_store
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 const TOKEN_LEN int = 42
 
+/// Kinds of token. Every id starts with its kind, so a token of one kind is
+/// never accepted as another
+const (
+	KindSession string = "sess"
+	KindCSRF    string = "csrf"
+	KindReset   string = "reset"
+	KindInvite  string = "invite"
+)
+
+/// Format of new ids, which look like sess_v1_<hex>. Ids of every version in
+/// acceptedVersions stay valid, so the format can change without ending
+/// every session
+const version string = "v1"
+
+var acceptedVersions = map[string]bool{version: true}
+
+/// Length in hex of a handle, see Handle
+const handleLen int = 32
+
+type entry struct {
+	expires   time.Time
+	user      string
+	ip        string
+	country   string
+	asn       uint
+	userAgent string
+	created   time.Time
+	lastSeen  time.Time
+
+	restricted bool
+}
+
+/// TokenStore keeps tokens of one kind. Tokens are kept by the SHA-256 of
+/// their id, the id itself is only given to the client, so nothing in the
+/// store can be replayed as a token
 type TokenStore struct {
 	name     string
-	tokens   map[string]time.Time
+	kind     string
+	tokens   map[string]*entry // by key of the id
 	lifetime time.Duration
 	lock     sync.Mutex
 }
 
-func New(name string, lifetime int) *TokenStore {
-
+/// Creates a store for tokens of kind, one of the Kind constants, that are
+/// set as cookies or sent as name
+func New(name string, kind string, lifetime int) *TokenStore {
 	return &TokenStore{
 		name:     name,
-		tokens:   make(map[string]time.Time),
+		kind:     kind,
+		tokens:   make(map[string]*entry),
 		lifetime: time.Second * time.Duration(lifetime),
 		lock:     sync.Mutex{},
 	}
@@ -31,16 +73,24 @@ func New(name string, lifetime int) *TokenStore {
 /// Creates a new token with a random id
 /// Returns a Token that contains the id and expiration timestamp
 func (s *TokenStore) NewToken() (*Token, error) {
+	return s.NewUserToken("", "")
+}
+
+/// Creates a new token with a random id that belongs to user, who logged in
+/// from ip
+/// Returns a Token that contains the id and expiration timestamp
+func (s *TokenStore) NewUserToken(user string, ip string) (*Token, error) {
 	s.cleanExpired()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	id, err := s.randomID()
 	if err != nil {
 		return nil, err
 	}
 	exp := s.makeEpiryTimestamp()
-
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	s.tokens[id] = exp
+	now := time.Now()
+	s.tokens[s.key(id)] = &entry{expires: exp, user: user, ip: ip, created: now, lastSeen: now}
 	return &Token{name: s.name, id: id, expires: &exp}, nil
 }
 
@@ -48,20 +98,19 @@ func (s *TokenStore) cleanExpired() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	now := time.Now()
-	for _, k := range Keys(s.tokens) {
-		if s.tokens[k].Before(now) {
+	for k, e := range s.tokens {
+		if e.expires.Before(now) {
 			delete(s.tokens, k)
 		}
 	}
 }
 
-func Keys[M ~map[K]V, K comparable, V any](m M) []K {
-	i := 0
-	r := make([]K, len(m))
-	for k := range m {
-		r[i] = k
-	}
-	return r
+/// Changes the lifetime, in seconds, of tokens created or refreshed from now on.
+/// Existing tokens keep their expiry until they are next used
+func (s *TokenStore) SetLifetime(lifetime int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lifetime = time.Second * time.Duration(lifetime)
 }
 
 func (s *TokenStore) makeEpiryTimestamp() time.Time {
@@ -69,53 +118,185 @@ func (s *TokenStore) makeEpiryTimestamp() time.Time {
 }
 
 func (s *TokenStore) randomID() (string, error) {
-	rngContainer := make([]byte, TOKEN_LEN)
 	for {
-		_, err := io.ReadFull(rand.Reader, rngContainer)
+		id, err := NewID(s.kind)
 		if err != nil {
 			return "", err
 		}
-		id := hex.EncodeToString(rngContainer)
 
-		_, exists := s.tokens[id]
+		_, exists := s.tokens[s.key(id)]
 		if !exists {
 			return id, nil
 		}
 	}
 }
 
+/// Returns the key token id is stored by, see Key
+func (s *TokenStore) key(id string) string {
+	return Key(s.kind, id)
+}
+
+/// Returns a new random id of kind in the current format, for tokens that
+/// are kept outside of a TokenStore, such as invites
+func NewID(kind string) (string, error) {
+	rngContainer := make([]byte, TOKEN_LEN)
+	_, err := io.ReadFull(rand.Reader, rngContainer)
+	if err != nil {
+		return "", err
+	}
+	return kind + "_" + version + "_" + hex.EncodeToString(rngContainer), nil
+}
+
+/// Returns the key a token id of kind is kept by, the hex SHA-256 of the id.
+/// Ids of another kind or an unknown version have the empty key, which is
+/// never kept
+func Key(kind string, id string) string {
+	parts := strings.SplitN(id, "_", 3)
+	if len(parts) != 3 || parts[0] != kind || !acceptedVersions[parts[1]] || parts[2] == "" {
+		return ""
+	}
+	return hashOf(id)
+}
+
+func hashOf(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
 /// Checks if token id exists and is not expired.
 /// Returns bool indicating if id is a valid token and was able to be updated
 func (s *TokenStore) IsValid(id string) bool {
-	exp, contains := s.tokens[id]
-	if !contains || exp.Before(time.Now()) {
-		delete(s.tokens, id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := s.key(id)
+	e, contains := s.tokens[key]
+	if !contains || e.expires.Before(time.Now()) {
+		delete(s.tokens, key)
 		return false
 	}
-	s.tokens[id] = s.makeEpiryTimestamp()
+	e.expires = s.makeEpiryTimestamp()
+	e.lastSeen = time.Now()
 	return contains
 }
 
+/// Returns the user token id belongs to, or an empty string if the token
+/// does not exist, has expired, or does not belong to a user
+func (s *TokenStore) User(id string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains || e.expires.Before(time.Now()) {
+		return ""
+	}
+	return e.user
+}
+
+/// Returns the ip address token id was created for, or an empty string if
+/// the token does not exist or has expired
+func (s *TokenStore) IP(id string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains || e.expires.Before(time.Now()) {
+		return ""
+	}
+	return e.ip
+}
+
+/// Records the country code and autonomous system the ip of token id is in.
+/// Returns bool indicating if the token exists
+func (s *TokenStore) SetLocation(id string, country string, asn uint) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains {
+		return false
+	}
+	e.country = country
+	e.asn = asn
+	return true
+}
+
+/// Returns the country code and autonomous system number set by SetLocation,
+/// or empty values if unknown or the token does not exist or has expired
+func (s *TokenStore) Location(id string) (string, uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains || e.expires.Before(time.Now()) {
+		return "", 0
+	}
+	return e.country, e.asn
+}
+
+/// Records the user agent of the client token id was created for.
+/// Returns bool indicating if the token exists
+func (s *TokenStore) SetUserAgent(id string, userAgent string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains {
+		return false
+	}
+	e.userAgent = userAgent
+	return true
+}
+
+/// Marks token id as restricted, eg a session that may only change its
+/// user's password.
+/// Returns bool indicating if the token exists
+func (s *TokenStore) SetRestricted(id string, restricted bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains {
+		return false
+	}
+	e.restricted = restricted
+	return true
+}
+
+/// Returns bool indicating if token id was marked restricted by SetRestricted.
+/// Tokens that do not exist or have expired are not restricted
+func (s *TokenStore) IsRestricted(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, contains := s.tokens[s.key(id)]
+	if !contains || e.expires.Before(time.Now()) {
+		return false
+	}
+	return e.restricted
+}
+
 /// Extends token exipration from now using lifetime.
 /// Returns error if token does not exist or has already expired
 func (s *TokenStore) RefreshExp(token *Token) error {
 	s.cleanExpired()
 
-	exp, contains := s.tokens[token.id]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := s.key(token.id)
+	e, contains := s.tokens[key]
 	if !contains {
 		return fmt.Errorf("invalid token")
 	}
 
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
-	if exp.Before(time.Now()) {
-		delete(s.tokens, token.id)
+	if e.expires.Before(time.Now()) {
+		delete(s.tokens, key)
 		return fmt.Errorf("invalid token")
 	}
 
-	exp = s.makeEpiryTimestamp()
-	s.tokens[token.id] = exp
+	exp := s.makeEpiryTimestamp()
+	e.expires = exp
 	token.expires = &exp
 
 	return nil
@@ -124,7 +305,96 @@ func (s *TokenStore) RefreshExp(token *Token) error {
 /// Removes token, rendering the id invalid.
 /// Returns bool indicating if the id existed to begin with
 func (s *TokenStore) Remove(id string) bool {
-	_, exists := s.tokens[id]
-	delete(s.tokens, id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := s.key(id)
+	_, exists := s.tokens[key]
+	delete(s.tokens, key)
 	return exists
 }
+
+/// Removes every token belonging to user, eg to end all of their sessions.
+/// Returns the number of tokens removed
+func (s *TokenStore) RemoveUser(user string) int {
+	return s.RemoveUserExcept(user, "")
+}
+
+/// Removes every token belonging to user other than keep, eg to end their
+/// other sessions. Returns the number of tokens removed
+func (s *TokenStore) RemoveUserExcept(user string, keep string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keepKey := s.key(keep)
+	removed := 0
+	for key, e := range s.tokens {
+		if e.user == user && key != keepKey {
+			delete(s.tokens, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+/// Session describes one of a user's tokens, as listed by Sessions. Handle
+/// identifies the token without revealing its id
+type Session struct {
+	Handle     string
+	IP         string
+	UserAgent  string
+	Country    string
+	ASN        uint
+	Created    time.Time
+	LastSeen   time.Time
+	Restricted bool
+}
+
+/// Returns the handle of token id, which can be shown to its user without
+/// letting anyone use the token
+func Handle(id string) string {
+	return hashOf(id)[:handleLen]
+}
+
+/// Returns the unexpired tokens belonging to user, most recently used first
+func (s *TokenStore) Sessions(user string) []Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	found := []Session{}
+	for key, e := range s.tokens {
+		if e.user != user || e.expires.Before(now) {
+			continue
+		}
+		found = append(found, Session{
+			Handle:     key[:handleLen],
+			IP:         e.ip,
+			UserAgent:  e.userAgent,
+			Country:    e.country,
+			ASN:        e.asn,
+			Created:    e.created,
+			LastSeen:   e.lastSeen,
+			Restricted: e.restricted,
+		})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].LastSeen.After(found[j].LastSeen)
+	})
+	return found
+}
+
+/// Removes the token of user with handle, as returned by Sessions.
+/// Returns bool indicating if it existed
+func (s *TokenStore) RemoveHandle(user string, handle string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, e := range s.tokens {
+		if e.user == user && subtle.ConstantTimeCompare([]byte(key[:handleLen]), []byte(handle)) == 1 {
+			delete(s.tokens, key)
+			return true
+		}
+	}
+	return false
+}
@@ -1,25 +1,29 @@
 package token_store
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestNew(t *testing.T) {
-	s := New("Test", 1)
+	s := New("Test", KindSession, 1)
 	if s.tokens == nil {
 		t.Fatal("Tokens map may not be nil")
 	}
 	if s.name != "Test" {
 		t.Fatalf("Incorrect token store name `%s`", s.name)
 	}
+	if s.kind != KindSession {
+		t.Fatalf("Incorrect token store kind `%s`", s.kind)
+	}
 	if s.lifetime != 1*time.Second {
 		t.Fatalf("Incorrect lifetime `%s`", s.lifetime)
 	}
 }
 
 func TestUniqueID(t *testing.T) {
-	s := New("Test", 1)
+	s := New("Test", KindSession, 1)
 
 	ids := make(map[string]struct{})
 
@@ -37,7 +41,7 @@ func TestUniqueID(t *testing.T) {
 }
 
 func TestStartNewSession(t *testing.T) {
-	s := New("Test", 1)
+	s := New("Test", KindSession, 1)
 
 	token, err := s.NewToken()
 	if err != nil {
@@ -58,7 +62,7 @@ func TestStartNewSession(t *testing.T) {
 }
 
 func TestIsValid(t *testing.T) {
-	s := New("Test", 1)
+	s := New("Test", KindSession, 1)
 
 	token, _ := s.NewToken()
 
@@ -73,7 +77,7 @@ func TestIsValid(t *testing.T) {
 }
 
 func TestRefresh(t *testing.T) {
-	s := New("Test", 1)
+	s := New("Test", KindSession, 1)
 
 	token, _ := s.NewToken()
 	time.Sleep(time.Millisecond * 500)
@@ -100,3 +104,133 @@ func TestRefresh(t *testing.T) {
 	}
 
 }
+
+func TestUser(t *testing.T) {
+	s := New("Test", KindSession, 1)
+
+	token, err := s.NewUserToken("Malory", "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.User(token.id) != "Malory" {
+		t.Fatalf("Incorrect token user `%s`", s.User(token.id))
+	}
+	if s.IP(token.id) != "203.0.113.7" {
+		t.Fatalf("Incorrect token ip `%s`", s.IP(token.id))
+	}
+	s.SetLocation(token.id, "NL", 1136)
+	if country, asn := s.Location(token.id); country != "NL" || asn != 1136 {
+		t.Fatalf("Incorrect token location %s AS%d", country, asn)
+	}
+
+	if s.IsRestricted(token.id) {
+		t.Fatal("New token is restricted")
+	}
+	s.SetRestricted(token.id, true)
+	if !s.IsRestricted(token.id) {
+		t.Fatal("Token not restricted")
+	}
+
+	token, _ = s.NewToken()
+	if s.User(token.id) != "" {
+		t.Fatal("Token without a user has a user")
+	}
+
+	if s.User("not a token") != "" {
+		t.Fatal("Invalid token has a user")
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	s := New("Test", KindSession, 60)
+
+	first, _ := s.NewUserToken("Malory", "")
+	second, _ := s.NewUserToken("Malory", "")
+	other, _ := s.NewUserToken("Lana", "")
+
+	if n := s.RemoveUser("Malory"); n != 2 {
+		t.Fatalf("Removed %d tokens, expected 2", n)
+	}
+	if s.IsValid(first.id) || s.IsValid(second.id) {
+		t.Fatal("Removed user's token still valid")
+	}
+	if !s.IsValid(other.id) {
+		t.Fatal("Other user's token removed")
+	}
+}
+
+func TestRemoveUserExcept(t *testing.T) {
+	s := New("Test", KindSession, 60)
+
+	current, _ := s.NewUserToken("Malory", "")
+	old, _ := s.NewUserToken("Malory", "")
+
+	if n := s.RemoveUserExcept("Malory", current.id); n != 1 {
+		t.Fatalf("Removed %d tokens, expected 1", n)
+	}
+	if !s.IsValid(current.id) || s.IsValid(old.id) {
+		t.Fatal("Wrong token removed")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := New("Test", KindSession, 60)
+
+	first, _ := s.NewUserToken("Pam", "10.0.0.1")
+	s.SetUserAgent(first.id, "curl/8.0")
+	second, _ := s.NewUserToken("Pam", "10.0.0.2")
+	s.NewUserToken("Cheryl", "10.0.0.3")
+	time.Sleep(10 * time.Millisecond)
+	s.IsValid(first.id)
+
+	sessions := s.Sessions("Pam")
+	if len(sessions) != 2 {
+		t.Fatalf("Listed %d sessions, expected 2", len(sessions))
+	}
+	if sessions[0].Handle != Handle(first.id) || sessions[0].UserAgent != "curl/8.0" || sessions[1].IP != "10.0.0.2" {
+		t.Fatalf("Unexpected sessions %v", sessions)
+	}
+
+	if s.RemoveHandle("Cheryl", Handle(second.id)) {
+		t.Fatal("Removed another user's session")
+	}
+	if !s.RemoveHandle("Pam", Handle(second.id)) || s.IsValid(second.id) {
+		t.Fatal("Session not removed by handle")
+	}
+}
+
+func TestTokenFormat(t *testing.T) {
+	s := New("Test", KindSession, 60)
+
+	token, _ := s.NewUserToken("Archer", "")
+	if !strings.HasPrefix(token.id, "sess_v1_") {
+		t.Fatalf("Token id `%s` does not start with its kind and version", token.id)
+	}
+	for key := range s.tokens {
+		if strings.Contains(token.id, key) || key != hashOf(token.id) {
+			t.Fatalf("Token stored by `%s` rather than the hash of its id", key)
+		}
+	}
+
+	random := strings.TrimPrefix(token.id, "sess_v1_")
+	for _, id := range []string{random, "csrf_v1_" + random, "sess_v0_" + random, "sess_v1_"} {
+		if s.IsValid(id) || s.User(id) != "" {
+			t.Fatalf("Token id `%s` accepted", id)
+		}
+	}
+
+	// ids kept outside a store follow the same format
+	id, _ := NewID(KindInvite)
+	if !strings.HasPrefix(id, "invite_v1_") || Key(KindInvite, id) != hashOf(id) || Key(KindSession, id) != "" {
+		t.Fatalf("Unexpected key of invite id `%s`", id)
+	}
+
+	// ids of an older version stay valid while it is accepted
+	acceptedVersions["v0"] = true
+	defer delete(acceptedVersions, "v0")
+	old := "sess_v0_" + random
+	s.tokens[hashOf(old)] = &entry{expires: time.Now().Add(time.Minute), user: "Lana"}
+	if s.User(old) != "Lana" || !s.IsValid(token.id) {
+		t.Fatal("Token of an accepted version not valid")
+	}
+}
@@ -0,0 +1,260 @@
+package webhook
+
+import (
+	"better_auth/config"
+	"better_auth/logging"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/// Headers sent with every request
+const (
+	EventHeader     = "X-Better-Auth-Event"
+	TimestampHeader = "X-Better-Auth-Timestamp"
+	SignatureHeader = "X-Better-Auth-Signature"
+)
+
+/// Longest wait between retries
+const maxBackoff = time.Minute
+
+type target struct {
+	url      string
+	events   map[string]bool // empty sends every event
+	secret   []byte
+	template *template.Template
+	queue    chan logging.AuditEvent
+}
+
+/// Dispatcher sends audit events to webhooks in the background. Each webhook
+/// has its own queue and worker, so a slow or failing receiver only delays
+/// its own events and never the request that caused them
+type Dispatcher struct {
+	targets        []*target
+	client         *http.Client
+	retries        int
+	backoff        time.Duration // wait before the first retry
+	deadLetterFile string
+	deadLetterLock sync.Mutex
+	wg             sync.WaitGroup
+	closed         bool
+	lock           sync.RWMutex // held to send or close
+}
+
+/// Creates a Dispatcher for hooks and starts its workers. Stop them with Close
+func New(hooks []config.WebhookTarget, opts config.WebhookDeliveryConfig) (*Dispatcher, error) {
+	d := &Dispatcher{
+		client:         &http.Client{Timeout: time.Second * time.Duration(opts.Timeout)},
+		retries:        opts.Retries,
+		backoff:        time.Second,
+		deadLetterFile: opts.DeadLetterFile,
+	}
+	for _, h := range hooks {
+		tmpl, err := h.ParseTemplate()
+		if err != nil {
+			return nil, err
+		}
+		t := &target{
+			url:      h.URL,
+			events:   make(map[string]bool),
+			secret:   []byte(h.Secret),
+			template: tmpl,
+			queue:    make(chan logging.AuditEvent, opts.QueueSize),
+		}
+		for _, e := range h.Events {
+			t.events[e] = true
+		}
+		d.targets = append(d.targets, t)
+	}
+
+	for _, t := range d.targets {
+		d.wg.Add(1)
+		go d.work(t)
+	}
+	return d, nil
+}
+
+/// Queues e for every webhook that wants it. Never blocks; if a webhook's
+/// queue is full e is written to the dead letter file instead
+func (d *Dispatcher) Send(e logging.AuditEvent) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for _, t := range d.targets {
+		if len(t.events) > 0 && !t.events[e.Event] {
+			continue
+		}
+		// a request may still hold the dispatcher a config reload replaced
+		if d.closed {
+			d.deadLetter(t, e, 0, fmt.Errorf("dispatcher closed by a config reload"))
+			continue
+		}
+		select {
+		case t.queue <- e:
+		default:
+			d.deadLetter(t, e, 0, fmt.Errorf("queue full"))
+		}
+	}
+}
+
+/// Stops accepting events. Events already queued are still sent, Wait blocks
+/// until they have been
+func (d *Dispatcher) Close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	for _, t := range d.targets {
+		close(t.queue)
+	}
+}
+
+/// Blocks until every worker has finished after Close
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) work(t *target) {
+	defer d.wg.Done()
+	for e := range t.queue {
+		body, err := t.body(e)
+		if err != nil {
+			d.deadLetter(t, e, 0, err)
+			continue
+		}
+
+		wait := d.backoff
+		attempts := 0
+		for {
+			attempts++
+			retry, err := d.post(t, e.Event, body)
+			if err == nil {
+				break
+			}
+			if !retry || attempts > d.retries {
+				d.deadLetter(t, e, attempts, err)
+				break
+			}
+			logging.Debug("Webhook %s failed, retrying in %s: %s", t.url, wait, err)
+			time.Sleep(wait)
+			wait *= 2
+			if wait > maxBackoff {
+				wait = maxBackoff
+			}
+		}
+	}
+}
+
+/// Renders the request body for e, its audit log json unless the webhook
+/// has a template. A template output that is not json is an error, so it is
+/// never sent
+func (t *target) body(e logging.AuditEvent) ([]byte, error) {
+	if t.template == nil {
+		return json.Marshal(e)
+	}
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("template did not produce json")
+	}
+	return buf.Bytes(), nil
+}
+
+/// Sends one request. Returns whether a failed request is worth repeating,
+/// which it is not if the receiver refused it as a client error
+func (d *Dispatcher) post(t *target, event string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "better_auth")
+	req.Header.Set(EventHeader, event)
+	if len(t.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(t.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("%s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return false, fmt.Errorf("%s", resp.Status)
+	default:
+		return true, fmt.Errorf("%s", resp.Status)
+	}
+}
+
+/// Returns the hex HMAC-SHA256 of timestamp and body joined by a dot. The
+/// timestamp is signed so receivers can refuse replayed requests
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/// deadLetter is a line of the dead letter file
+type deadLetter struct {
+	Time     time.Time          `json:"time"`
+	URL      string             `json:"url"`
+	Attempts int                `json:"attempts"`
+	Error    string             `json:"error"`
+	Event    logging.AuditEvent `json:"event"`
+}
+
+/// Records an event that could not be sent to t
+func (d *Dispatcher) deadLetter(t *target, e logging.AuditEvent, attempts int, cause error) {
+	logging.Warning("Webhook %s not sent %s event after %d attempts: %s", t.url, e.Event, attempts, cause)
+	if d.deadLetterFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		Time:     time.Now(),
+		URL:      t.url,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Event:    e,
+	})
+	if err != nil {
+		logging.Error(err)
+		return
+	}
+
+	d.deadLetterLock.Lock()
+	defer d.deadLetterLock.Unlock()
+	f, err := os.OpenFile(d.deadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		logging.Error(fmt.Errorf("unable to write webhook dead letter: %s", err))
+	}
+}
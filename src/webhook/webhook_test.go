@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"better_auth/config"
+	"better_auth/logging"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type request struct {
+	header http.Header
+	body   string
+}
+
+/// Starts a receiver answering with the statuses in order, then 200
+func startReceiver(t *testing.T, statuses ...int) (string, func() []request) {
+	var lock sync.Mutex
+	received := []request{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, request{header: r.Header, body: string(body)})
+		if len(received) <= len(statuses) {
+			w.WriteHeader(statuses[len(received)-1])
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, func() []request {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]request{}, received...)
+	}
+}
+
+func options(t *testing.T) config.WebhookDeliveryConfig {
+	return config.WebhookDeliveryConfig{
+		QueueSize:      10,
+		Retries:        2,
+		Timeout:        5,
+		DeadLetterFile: path.Join(t.TempDir(), "dead.log"),
+	}
+}
+
+func newDispatcher(t *testing.T, hooks []config.WebhookTarget, opts config.WebhookDeliveryConfig) *Dispatcher {
+	d, err := New(hooks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.backoff = time.Millisecond
+	return d
+}
+
+func deadLetters(t *testing.T, opts config.WebhookDeliveryConfig) []deadLetter {
+	data, _ := os.ReadFile(opts.DeadLetterFile)
+	letters := []deadLetter{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var l deadLetter
+		err := json.Unmarshal([]byte(line), &l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, l)
+	}
+	return letters
+}
+
+func TestSend(t *testing.T) {
+	allURL, all := startReceiver(t)
+	lockoutURL, lockouts := startReceiver(t)
+	opts := options(t)
+	d := newDispatcher(t, []config.WebhookTarget{
+		{URL: allURL, Secret: "swordfish", Template: `{"text": {{json (printf "%s: %s" .Event .User)}}}`},
+		{URL: lockoutURL, Events: []string{logging.AuditLockout}},
+	}, opts)
+
+	d.Send(logging.AuditEvent{Event: logging.AuditLoginSuccess, User: "Pam"})
+	d.Send(logging.AuditEvent{Event: logging.AuditLockout, User: `Krieger "Doc"`})
+	d.Close()
+	d.Wait()
+
+	got := all()
+	if len(got) != 2 || got[1].body != `{"text": "lockout: Krieger \"Doc\""}` {
+		t.Fatalf("Unexpected requests %+v", got)
+	}
+	h := got[0].header
+	if h.Get(EventHeader) != logging.AuditLoginSuccess ||
+		h.Get(SignatureHeader) != "sha256="+Sign([]byte("swordfish"), h.Get(TimestampHeader), []byte(got[0].body)) {
+		t.Fatalf("Unexpected headers %v", h)
+	}
+
+	got = lockouts()
+	var e logging.AuditEvent
+	if len(got) != 1 || json.Unmarshal([]byte(got[0].body), &e) != nil || e.User != `Krieger "Doc"` {
+		t.Fatalf("Unexpected requests %+v", got)
+	}
+	if got[0].header.Get(SignatureHeader) != "" {
+		t.Fatal("Request signed without a secret")
+	}
+	if len(deadLetters(t, opts)) != 0 {
+		t.Fatal("Delivered events in dead letter file")
+	}
+
+	// ignored once closed
+	d.Send(logging.AuditEvent{Event: logging.AuditLockout})
+}
+
+func TestRetry(t *testing.T) {
+	url, received := startReceiver(t, 500, 429)
+	opts := options(t)
+	d := newDispatcher(t, []config.WebhookTarget{{URL: url}}, opts)
+	d.Send(logging.AuditEvent{Event: logging.AuditLogout})
+	d.Close()
+	d.Wait()
+
+	if len(received()) != 3 || len(deadLetters(t, opts)) != 0 {
+		t.Fatalf("Expected 2 retries, got %d requests", len(received()))
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	failingURL, failing := startReceiver(t, 502, 502, 502)
+	refusingURL, refusing := startReceiver(t, 400)
+	opts := options(t)
+	d := newDispatcher(t, []config.WebhookTarget{{URL: failingURL}, {URL: refusingURL}}, opts)
+	d.Send(logging.AuditEvent{Event: logging.AuditLockout, User: "Cheryl"})
+	d.Close()
+	d.Wait()
+
+	if len(failing()) != 3 || len(refusing()) != 1 {
+		t.Fatalf("Unexpected attempts %d and %d", len(failing()), len(refusing()))
+	}
+	letters := deadLetters(t, opts)
+	if len(letters) != 2 {
+		t.Fatalf("Unexpected dead letters %+v", letters)
+	}
+	for _, l := range letters {
+		if l.Event.User != "Cheryl" || (l.URL == failingURL) != (l.Attempts == 3) {
+			t.Fatalf("Unexpected dead letter %+v", l)
+		}
+	}
+}
+
+/// Tests that a template quoting values itself is not sent once a value
+/// breaks its json
+func TestTemplateNotJSON(t *testing.T) {
+	url, received := startReceiver(t)
+	opts := options(t)
+	d := newDispatcher(t, []config.WebhookTarget{{URL: url, Template: `{"text": "{{.User}}"}`}}, opts)
+	d.Send(logging.AuditEvent{Event: logging.AuditLockout, User: `Cheryl "Doc"`})
+	d.Close()
+	d.Wait()
+
+	letters := deadLetters(t, opts)
+	if len(received()) != 0 || len(letters) != 1 || letters[0].Error != "template did not produce json" {
+		t.Fatalf("Unexpected dead letters %+v", letters)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+
+	opts := options(t)
+	opts.QueueSize = 1
+	d := newDispatcher(t, []config.WebhookTarget{{URL: ts.URL}}, opts)
+
+	// the first is being sent, the second waits in the queue
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		d.Send(logging.AuditEvent{Event: logging.AuditLoginFailure})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Send blocked on a slow receiver")
+	}
+	close(release)
+	d.Close()
+	d.Wait()
+
+	letters := deadLetters(t, opts)
+	if len(letters) != 3 || letters[0].Error != "queue full" {
+		t.Fatalf("Unexpected dead letters %+v", letters)
+	}
+}
+
+/// Tests that events sent after Close are dead lettered rather than dropped
+func TestSendAfterClose(t *testing.T) {
+	url, received := startReceiver(t)
+	opts := options(t)
+	d := newDispatcher(t, []config.WebhookTarget{{URL: url}}, opts)
+	d.Close()
+	d.Send(logging.AuditEvent{Event: logging.AuditLogout, User: "Ray"})
+	d.Wait()
+
+	letters := deadLetters(t, opts)
+	if len(received()) != 0 || len(letters) != 1 || letters[0].Event.User != "Ray" {
+		t.Fatalf("Unexpected dead letters %+v", letters)
+	}
+}